@@ -2,24 +2,123 @@
 package config
 
 import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
+	"low-tide/internal/logging"
 )
 
 // AppConfig represents a single download app definition.
 type AppConfig struct {
 	Name               string   `yaml:"name" json:"name"`
 	ID                 string   `yaml:"id" json:"id"`
-	Command            string   `yaml:"command" json:"command"` // e.g. "yt-dlp %u"
-	Args               []string `yaml:"args" json:"args"`       // optional fixed args
-	Regex              string   `yaml:"regex" json:"regex"`     // optional regex to auto-match URLs
-	StripTrailingSlash bool     `yaml:"strip_trailing_slash" json:"strip_trailing_slash"`
+	Command            string   `yaml:"command" json:"command"`       // e.g. "yt-dlp"
+	Args               []string `yaml:"args,omitempty" json:"args"`   // optional fixed args
+	Regex              string   `yaml:"regex,omitempty" json:"regex"` // optional regex to auto-match URLs
+	StripTrailingSlash bool     `yaml:"strip_trailing_slash,omitempty" json:"strip_trailing_slash"`
+	// FetchMetadata controls whether the page is fetched for a title/og:image. Defaults to true.
+	FetchMetadata *bool `yaml:"fetch_metadata,omitempty" json:"fetch_metadata,omitempty"`
+	// RenameToTitle renames the job's primary (largest) output file to a slug of the job title.
+	RenameToTitle bool `yaml:"rename_to_title,omitempty" json:"rename_to_title"`
+	// AllowedHosts, when set, limits the app to URLs on these hosts. DeniedHosts
+	// rejects URLs on these hosts and wins over AllowedHosts. "*.example.com"
+	// matches any subdomain of example.com, but not example.com itself.
+	AllowedHosts []string `yaml:"allowed_hosts,omitempty" json:"allowed_hosts,omitempty"`
+	DeniedHosts  []string `yaml:"denied_hosts,omitempty" json:"denied_hosts,omitempty"`
+	// KillGrace overrides the global kill_grace for this app when set.
+	KillGrace time.Duration `yaml:"kill_grace,omitempty" json:"kill_grace,omitempty"`
+	// ScratchDir runs the command in a throwaway directory outside downloads_dir,
+	// so caches and temp files it leaves in its cwd aren't recorded as job files.
+	// Outputs must be written to the job directory, passed in args as "%o".
+	ScratchDir bool `yaml:"scratch_dir,omitempty" json:"scratch_dir"`
+	// AppendURL passes the URL as the last argument when no arg places it with "%u".
+	AppendURL bool `yaml:"append_url,omitempty" json:"append_url"`
+	// MaxFiles overrides the global max_files for this app when set.
+	MaxFiles int `yaml:"max_files,omitempty" json:"max_files,omitempty"`
+	// Env adds environment variables for the command, on top of the server's own.
+	Env map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	// Concurrency is what "%n" in args expands to, for tools with a parallelism
+	// flag (yt-dlp's -N, aria2c's -x).
+	Concurrency int `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+	// EchoCommand controls whether the job log starts with the "$ command" line.
+	// Defaults to true; the command line is kept on the job either way.
+	EchoCommand *bool `yaml:"echo_command,omitempty" json:"echo_command,omitempty"`
+
+	// fromAppsDir marks apps loaded from apps_dir; SaveApps only writes inline apps.
+	fromAppsDir bool
+}
+
+// FromAppsDir reports whether the app was loaded from a file in apps_dir rather
+// than the inline apps list.
+func (a *AppConfig) FromAppsDir() bool {
+	return a.fromAppsDir
+}
+
+// ShouldFetchMetadata reports whether jobs for this app should fetch page metadata.
+func (a *AppConfig) ShouldFetchMetadata() bool {
+	return a.FetchMetadata == nil || *a.FetchMetadata
+}
+
+// ShouldEchoCommand reports whether the command line is echoed into the job log.
+func (a *AppConfig) ShouldEchoCommand() bool {
+	return a.EchoCommand == nil || *a.EchoCommand
+}
+
+// ShouldAppendURL reports whether the URL is added as a last argument: when
+// append_url is set and no arg places it with "%u".
+func (a *AppConfig) ShouldAppendURL() bool {
+	return a.AppendURL && !slices.ContainsFunc(a.Args, func(arg string) bool { return strings.Contains(arg, "%u") })
+}
+
+// TakesURL reports whether the command is given the URL at all.
+func (a *AppConfig) TakesURL() bool {
+	return a.AppendURL || slices.ContainsFunc(a.Args, func(arg string) bool { return strings.Contains(arg, "%u") })
+}
+
+// CheckHost reports whether the app's allowed_hosts/denied_hosts permit host.
+func (a *AppConfig) CheckHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, p := range a.DeniedHosts {
+		if hostMatches(p, host) {
+			return fmt.Errorf("host %s is denied for app %s", host, a.ID)
+		}
+	}
+	if len(a.AllowedHosts) == 0 {
+		return nil
+	}
+	for _, p := range a.AllowedHosts {
+		if hostMatches(p, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not in allowed_hosts for app %s", host, a.ID)
+}
+
+func hostMatches(pattern, host string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
 }
 
+// MatchAppForURL returns a copy of the first app whose regex matches u.
 func (c *Config) MatchAppForURL(u string) *AppConfig {
-	for i, a := range c.Apps {
+	c.appsMu.RLock()
+	defer c.appsMu.RUnlock()
+	for _, a := range c.Apps {
 		if a.Regex == "" {
 			continue
 		}
@@ -28,28 +127,218 @@ func (c *Config) MatchAppForURL(u string) *AppConfig {
 			continue
 		}
 		if re.MatchString(u) {
-			return &c.Apps[i]
+			return &a
 		}
 	}
 	return nil
 }
 
+// GetApp returns a copy of the app with the given id. It stays valid (and
+// unchanged) while the command runs even if the app list is edited meanwhile.
 func (c *Config) GetApp(id string) *AppConfig {
-	for i, a := range c.Apps {
+	c.appsMu.RLock()
+	defer c.appsMu.RUnlock()
+	for _, a := range c.Apps {
 		if a.ID == id {
-			return &c.Apps[i]
+			return &a
 		}
 	}
 	return nil
 }
 
+// AppList returns a copy of the app list.
+func (c *Config) AppList() []AppConfig {
+	c.appsMu.RLock()
+	defer c.appsMu.RUnlock()
+	return append([]AppConfig(nil), c.Apps...)
+}
+
+// SetApps swaps in a new app list. Apps handed out earlier are copies, so they
+// are unaffected; callers must not modify apps afterwards.
+func (c *Config) SetApps(apps []AppConfig) {
+	c.appsMu.Lock()
+	defer c.appsMu.Unlock()
+	c.Apps = apps
+}
+
 // Config is the top-level configuration structure.
 type Config struct {
-	ListenAddr         string      `yaml:"listen_addr" json:"listen_addr"`
-	DBPath             string      `yaml:"db_path" json:"db_path"`
-	DownloadsDir       string      `yaml:"downloads_dir" json:"downloads_dir"`
-	Apps               []AppConfig `yaml:"apps" json:"apps"`
-	StrictURLValidation bool        `yaml:"-" json:"strict_url_validation"`
+	ListenAddr   string      `yaml:"listen_addr" json:"listen_addr"`
+	DBPath       string      `yaml:"db_path" json:"db_path"`
+	DownloadsDir string      `yaml:"downloads_dir" json:"downloads_dir"`
+	Apps         []AppConfig `yaml:"apps" json:"apps"`
+	// AppsDir is a conf.d-style directory of *.yaml files, each holding one app or a
+	// list of apps, merged after the inline apps. Relative paths are resolved
+	// against the config file's directory.
+	AppsDir             string `yaml:"apps_dir" json:"apps_dir,omitempty"`
+	StrictURLValidation bool   `yaml:"-" json:"strict_url_validation"`
+	// MaxThumbnailBytes caps the size of downloaded/uploaded thumbnails. Defaults to 5MB.
+	MaxThumbnailBytes int64 `yaml:"max_thumbnail_bytes" json:"max_thumbnail_bytes"`
+	// JobsListLimit is how many jobs GET /api/jobs returns by default. Defaults to 100.
+	JobsListLimit int `yaml:"jobs_list_limit" json:"jobs_list_limit"`
+	// URLNormalization controls how submitted URLs are compared when deduplicating.
+	URLNormalization URLNormalization `yaml:"url_normalization" json:"url_normalization"`
+	// TransliterateFilenames reduces generated download names to ASCII ("Café" -> "cafe").
+	TransliterateFilenames bool `yaml:"transliterate_filenames" json:"transliterate_filenames"`
+	// BatchSnapshots coalesces the job snapshots produced in one publish tick into a
+	// single "job_snapshots" event instead of one frame per job.
+	BatchSnapshots bool `yaml:"batch_snapshots" json:"batch_snapshots"`
+	// WSCompression enables permessage-deflate on the state websocket. Defaults to true;
+	// turn it off on very small devices where CPU matters more than bandwidth.
+	WSCompression *bool `yaml:"ws_compression" json:"ws_compression,omitempty"`
+	// WSCompressionLevel is the flate level (1 fastest .. 9 smallest). Defaults to 1.
+	WSCompressionLevel int `yaml:"ws_compression_level" json:"ws_compression_level,omitempty"`
+	// WatchMode is how job output is discovered: "fsnotify" (default) or "poll" for
+	// filesystems without reliable change events (NFS, SMB).
+	WatchMode string `yaml:"watch_mode" json:"watch_mode,omitempty"`
+	// PollInterval is how often the running job's dir is rescanned when polling. Defaults to 2s.
+	PollInterval time.Duration `yaml:"poll_interval" json:"poll_interval,omitempty"`
+	// MaxJobRuntime kills any job still running after this long and marks it failed.
+	// Zero means no limit.
+	MaxJobRuntime time.Duration `yaml:"max_job_runtime" json:"max_job_runtime,omitempty"`
+	// KillGrace is how long a cancelled or timed-out job gets to exit after SIGTERM
+	// before it is SIGKILLed. Zero (the default) kills it right away.
+	KillGrace time.Duration `yaml:"kill_grace" json:"kill_grace,omitempty"`
+	// MaxFiles stops a job that creates more files than this and marks it failed,
+	// keeping runaway jobs from flooding the database and UI. Zero means no limit.
+	MaxFiles int `yaml:"max_files" json:"max_files,omitempty"`
+	// DirMode and FileMode set permissions (octal, e.g. "0775") on job dirs, thumbnails
+	// and job output files. Unset keeps the defaults (0755/0644 minus umask).
+	DirMode  Perm `yaml:"dir_mode" json:"dir_mode,omitempty"`
+	FileMode Perm `yaml:"file_mode" json:"file_mode,omitempty"`
+	// LogLevel is the minimum level printed: debug, info (default), warn or error.
+	// Per-request and per-file detail is only logged at debug.
+	LogLevel string `yaml:"log_level" json:"log_level"`
+	// Timezone is an IANA zone name (e.g. "Europe/Berlin" or "Local") that API
+	// timestamps are rendered in. Empty leaves them as stored (UTC).
+	Timezone string `yaml:"timezone" json:"timezone,omitempty"`
+	// AdminToken enables the /api/admin/ endpoints; requests must send it as a Bearer token.
+	// Admin endpoints are disabled while this is empty.
+	AdminToken string `yaml:"admin_token" json:"-"`
+	// VacuumOnStartup compacts the database before the server starts accepting requests.
+	VacuumOnStartup bool `yaml:"vacuum_on_startup" json:"vacuum_on_startup"`
+	// Path is the file this config was loaded from; SaveApps writes back to it.
+	Path string `yaml:"-" json:"-"`
+	// MetadataFullScan keeps parsing page metadata past </head> (slower, but catches late SPA tags).
+	MetadataFullScan bool `yaml:"metadata_full_scan" json:"metadata_full_scan"`
+	// MetadataConcurrency caps how many page/thumbnail fetches run at once; the rest
+	// wait their turn. Defaults to 4.
+	MetadataConcurrency int `yaml:"metadata_concurrency" json:"metadata_concurrency"`
+	// TerminalRows is the screen height commands are given; cursor addressing
+	// (progress bars, redrawn tables) works within it. Defaults to 24.
+	TerminalRows int `yaml:"terminal_rows" json:"terminal_rows"`
+	// ScrollbackLines is how many lines of a job's output are kept, screen
+	// included. Defaults to 500.
+	ScrollbackLines int `yaml:"scrollback_lines" json:"scrollback_lines"`
+
+	// appsMu guards Apps, which /api/apps edits at runtime. Once the config is in
+	// use, read it through GetApp, MatchAppForURL or AppList and replace it with
+	// SetApps.
+	appsMu sync.RWMutex
+}
+
+// KillGraceFor returns the kill grace period for app, falling back to the global one.
+func (c *Config) KillGraceFor(app *AppConfig) time.Duration {
+	if app != nil && app.KillGrace > 0 {
+		return app.KillGrace
+	}
+	return c.KillGrace
+}
+
+// MaxFilesFor returns the file count limit for app's jobs, falling back to the
+// global one. Zero means no limit.
+func (c *Config) MaxFilesFor(app *AppConfig) int {
+	if app != nil && app.MaxFiles > 0 {
+		return app.MaxFiles
+	}
+	return c.MaxFiles
+}
+
+// ShouldCompressWS reports whether websocket compression is enabled.
+func (c *Config) ShouldCompressWS() bool {
+	return c.WSCompression == nil || *c.WSCompression
+}
+
+// Watch modes for Config.WatchMode.
+const (
+	WatchModeFsnotify = "fsnotify"
+	WatchModePoll     = "poll"
+)
+
+const (
+	// DefaultMaxThumbnailBytes is used when max_thumbnail_bytes is unset.
+	DefaultMaxThumbnailBytes = 5 * 1024 * 1024
+	// DefaultJobsListLimit is used when jobs_list_limit is unset.
+	DefaultJobsListLimit = 100
+	// MaxJobsListLimit bounds both the config default and the ?limit= query param.
+	MaxJobsListLimit = 1000
+	// DefaultMetadataConcurrency is used when metadata_concurrency is unset.
+	DefaultMetadataConcurrency = 4
+	// DefaultTerminalRows is used when terminal_rows is unset.
+	DefaultTerminalRows = 24
+	// DefaultScrollbackLines is used when scrollback_lines is unset.
+	DefaultScrollbackLines = 500
+)
+
+// Perm is a permission mode written in octal in YAML ("0775", 0775 or 0o775).
+// The zero value means "use the default".
+type Perm os.FileMode
+
+func (p *Perm) UnmarshalYAML(n *yaml.Node) error {
+	v := strings.TrimPrefix(strings.ToLower(n.Value), "0o")
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return fmt.Errorf("invalid permission mode %q (want octal like 0775)", n.Value)
+	}
+	*p = Perm(mode)
+	return nil
+}
+
+// Or returns p as a file mode, or def if p is unset.
+func (p Perm) Or(def os.FileMode) os.FileMode {
+	if p == 0 {
+		return def
+	}
+	return os.FileMode(p)
+}
+
+// URLNormalization holds the optional, potentially lossy normalization rules.
+// Lowercasing the scheme/host and dropping default ports always applies; the query
+// string is never touched so query-sensitive URLs aren't collapsed.
+type URLNormalization struct {
+	StripTrailingSlash bool `yaml:"strip_trailing_slash" json:"strip_trailing_slash"`
+	StripFragment      bool `yaml:"strip_fragment" json:"strip_fragment"`
+}
+
+// NormalizeURL returns a canonical form of u suitable for duplicate detection.
+func (n URLNormalization) NormalizeURL(u *url.URL) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	host := strings.ToLower(c.Hostname())
+	port := c.Port()
+	if (c.Scheme == "http" && port == "80") || (c.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // bare IPv6 literal
+	}
+	c.Host = host
+
+	if c.Path == "" {
+		c.Path = "/"
+		c.RawPath = ""
+	}
+	if n.StripTrailingSlash && c.Path != "/" && strings.HasSuffix(c.Path, "/") {
+		c.Path = strings.TrimRight(c.Path, "/")
+		c.RawPath = ""
+	}
+	if n.StripFragment {
+		c.Fragment = ""
+		c.RawFragment = ""
+	}
+	return c.String()
 }
 
 // Load reads the YAML config file from path.
@@ -67,6 +356,22 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.AppsDir != "" {
+		dir := cfg.AppsDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+		apps, err := loadAppsDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for i := range apps {
+			apps[i].fromAppsDir = true
+		}
+		cfg.Apps = append(cfg.Apps, apps...)
+	}
+	cfg.Path = path
+
 	// Apply defaults
 	if cfg.ListenAddr == "" {
 		cfg.ListenAddr = ":8080"
@@ -77,6 +382,24 @@ func Load(path string) (*Config, error) {
 	if cfg.DownloadsDir == "" {
 		cfg.DownloadsDir = "downloads"
 	}
+	if cfg.MaxThumbnailBytes <= 0 {
+		cfg.MaxThumbnailBytes = DefaultMaxThumbnailBytes
+	}
+	if cfg.JobsListLimit <= 0 {
+		cfg.JobsListLimit = DefaultJobsListLimit
+	}
+	if cfg.JobsListLimit > MaxJobsListLimit {
+		cfg.JobsListLimit = MaxJobsListLimit
+	}
+	if cfg.MetadataConcurrency <= 0 {
+		cfg.MetadataConcurrency = DefaultMetadataConcurrency
+	}
+	if cfg.TerminalRows <= 0 {
+		cfg.TerminalRows = DefaultTerminalRows
+	}
+	if cfg.ScrollbackLines <= 0 {
+		cfg.ScrollbackLines = DefaultScrollbackLines
+	}
 
 	// Strict URL validation is enabled by default.
 	// It prevents Server-Side Request Forgery (SSRF) by rejecting URLs
@@ -87,7 +410,8 @@ func Load(path string) (*Config, error) {
 		cfg.StrictURLValidation = false
 	}
 
-	// Allow environment variables to override config values
+	// Allow environment variables to override config values.
+	// Precedence: env > file > default.
 	if env := os.Getenv("LOWTIDE_LISTEN_ADDR"); env != "" {
 		cfg.ListenAddr = env
 	}
@@ -98,13 +422,308 @@ func Load(path string) (*Config, error) {
 		cfg.DBPath = env
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
-// GetConfigPath returns the config file path, checking LOWTIDE_CONFIG env var first.
-func GetConfigPath() string {
+// saveMu serializes SaveApps within this process; the lock file covers other
+// processes where flock is available.
+var saveMu sync.Mutex
+
+// SaveApps rewrites the inline apps list in the config file at c.Path, leaving
+// every other key (and its comments) as it was. Apps loaded from apps_dir are
+// skipped; they live in their own files. The file is replaced atomically, so
+// the lock is taken on a "<config>.lock" file next to it, which is left in
+// place: removing it could race with a process that has just opened it.
+func (c *Config) SaveApps(apps []AppConfig) error {
+	if c.Path == "" {
+		return errors.New("config was not loaded from a file")
+	}
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	lock, err := os.OpenFile(c.Path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: top level is not a mapping", c.Path)
+	}
+
+	var inline []AppConfig
+	for _, a := range apps {
+		if !a.fromAppsDir {
+			inline = append(inline, a)
+		}
+	}
+	var appsNode yaml.Node
+	if err := appsNode.Encode(inline); err != nil {
+		return err
+	}
+
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "apps" {
+			// Keep comments attached to the old value (e.g. section banners).
+			appsNode.HeadComment = root.Content[i+1].HeadComment
+			root.Content[i+1] = &appsNode
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "apps"}, &appsNode)
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(c.Path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), c.Path)
+}
+
+// loadAppsDir decodes every *.yaml file in dir, in lexical order. A file may hold
+// a single app mapping or a list of apps.
+func loadAppsDir(dir string) ([]AppConfig, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("apps_dir: %w", err)
+	}
+	var apps []AppConfig
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if len(node.Content) == 0 {
+			continue // empty file
+		}
+		switch node.Content[0].Kind {
+		case yaml.SequenceNode:
+			var list []AppConfig
+			if err := node.Decode(&list); err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			apps = append(apps, list...)
+		case yaml.MappingNode:
+			var app AppConfig
+			if err := node.Decode(&app); err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			apps = append(apps, app)
+		default:
+			return nil, fmt.Errorf("%s: expected an app or a list of apps", file)
+		}
+	}
+	return apps, nil
+}
+
+// Location returns the configured timezone, or nil when none is set.
+func (c *Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return nil, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// Validate reports configuration mistakes that would otherwise surface as
+// confusing runtime behavior, such as two apps sharing an ID.
+func (c *Config) Validate() error {
+	var errs []error
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if _, err := c.Location(); err != nil {
+		errs = append(errs, fmt.Errorf("timezone: %w", err))
+	}
+	switch c.WatchMode {
+	case "", WatchModeFsnotify, WatchModePoll:
+	default:
+		errs = append(errs, fmt.Errorf("watch_mode: unknown mode %q (want %s or %s)", c.WatchMode, WatchModeFsnotify, WatchModePoll))
+	}
+	if c.WSCompressionLevel < 0 || c.WSCompressionLevel > 9 {
+		errs = append(errs, fmt.Errorf("ws_compression_level: %d is out of range 1-9", c.WSCompressionLevel))
+	}
+	if c.PollInterval < 0 {
+		errs = append(errs, errors.New("poll_interval must be positive"))
+	}
+	if c.MaxJobRuntime < 0 {
+		errs = append(errs, errors.New("max_job_runtime must be positive"))
+	}
+	if c.KillGrace < 0 {
+		errs = append(errs, errors.New("kill_grace must be positive"))
+	}
+	if c.MaxFiles < 0 {
+		errs = append(errs, errors.New("max_files must be positive"))
+	}
+	if c.TerminalRows < 0 {
+		errs = append(errs, errors.New("terminal_rows must be positive"))
+	}
+	if c.ScrollbackLines < 0 {
+		errs = append(errs, errors.New("scrollback_lines must be positive"))
+	}
+	if c.TerminalRows > 0 && c.ScrollbackLines > 0 && c.ScrollbackLines < c.TerminalRows {
+		errs = append(errs, errors.New("scrollback_lines must be at least terminal_rows"))
+	}
+	seen := make(map[string]bool)
+	for i, a := range c.Apps {
+		if a.ID == "" {
+			errs = append(errs, fmt.Errorf("apps[%d] (%q): missing id", i, a.Name))
+			continue
+		}
+		if seen[a.ID] {
+			errs = append(errs, fmt.Errorf("apps: duplicate id %q", a.ID))
+		}
+		seen[a.ID] = true
+		if a.Command == "" {
+			errs = append(errs, fmt.Errorf("app %q: missing command", a.ID))
+		} else if strings.Contains(a.Command, "%u") {
+			errs = append(errs, fmt.Errorf("app %q: command is run as-is; put %%u in args instead", a.ID))
+		}
+		if a.Regex != "" {
+			if _, err := regexp.Compile(a.Regex); err != nil {
+				errs = append(errs, fmt.Errorf("app %q: invalid regex: %w", a.ID, err))
+			}
+		}
+		if a.KillGrace < 0 {
+			errs = append(errs, fmt.Errorf("app %q: kill_grace must be positive", a.ID))
+		}
+		if a.MaxFiles < 0 {
+			errs = append(errs, fmt.Errorf("app %q: max_files must be positive", a.ID))
+		}
+		if a.Concurrency < 0 {
+			errs = append(errs, fmt.Errorf("app %q: concurrency must be positive", a.ID))
+		}
+		if a.Concurrency == 0 && slices.ContainsFunc(a.Args, func(arg string) bool { return strings.Contains(arg, "%n") }) {
+			errs = append(errs, fmt.Errorf("app %q: %%n in args needs concurrency to be set", a.ID))
+		}
+		for name := range a.Env {
+			if name == "" || strings.ContainsAny(name, "=\x00") {
+				errs = append(errs, fmt.Errorf("app %q: invalid env var name %q", a.ID, name))
+			}
+		}
+		if a.ScratchDir && !slices.ContainsFunc(a.Args, func(arg string) bool { return strings.Contains(arg, "%o") }) {
+			errs = append(errs, fmt.Errorf("app %q: scratch_dir needs %%o in args, or outputs are thrown away with the scratch dir", a.ID))
+		}
+		for _, h := range append(append([]string{}, a.AllowedHosts...), a.DeniedHosts...) {
+			if h == "" || strings.Contains(strings.TrimPrefix(h, "*."), "*") {
+				errs = append(errs, fmt.Errorf("app %q: invalid host pattern %q (wildcards are only allowed as a leading \"*.\")", a.ID, h))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Warnings lists likely mistakes that don't stop the config from loading.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	for _, a := range c.AppList() {
+		if !a.TakesURL() {
+			warnings = append(warnings, fmt.Sprintf("app %q: no arg contains %%u, so the command never gets the URL; add \"%%u\" to args or set append_url: true", a.ID))
+		}
+	}
+	return warnings
+}
+
+//go:embed default.yaml
+var defaultConfig []byte
+
+// WriteDefault writes the commented starter config to path unless a file is
+// already there. It reports whether a new file was created.
+func WriteDefault(path string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Write(defaultConfig); err != nil {
+		f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
+
+// ResolveConfigPath picks the config file to load. An explicit path (from the
+// --config flag) or LOWTIDE_CONFIG is used as-is; otherwise the first existing
+// file from SearchPaths wins.
+func ResolveConfigPath(explicit string) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
 	if env := os.Getenv("LOWTIDE_CONFIG"); env != "" {
-		return env
+		return env, nil
+	}
+	paths := SearchPaths()
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no config file found; searched %s", strings.Join(paths, ", "))
+}
+
+// SearchPaths lists the default config locations, most specific first.
+func SearchPaths() []string {
+	var paths []string
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			xdg = filepath.Join(home, ".config")
+		}
+	}
+	if xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "lowtide", "config.yaml"))
 	}
-	return "config/config.yaml"
+	return append(paths,
+		"/etc/lowtide/config.yaml",
+		"config.yaml",
+		filepath.Join("config", "config.yaml"), // repo checkout layout
+	)
 }
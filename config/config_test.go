@@ -0,0 +1,453 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	lenient := URLNormalization{}
+	strict := URLNormalization{StripTrailingSlash: true, StripFragment: true}
+
+	tests := []struct {
+		name     string
+		norm     URLNormalization
+		in       string
+		expected string
+	}{
+		{"lowercases host", lenient, "http://Example.COM/Path", "http://example.com/Path"},
+		{"lowercases scheme", lenient, "HTTPS://example.com/", "https://example.com/"},
+		{"empty path is root", lenient, "http://example.com", "http://example.com/"},
+		{"drops default http port", lenient, "http://example.com:80/a", "http://example.com/a"},
+		{"drops default https port", lenient, "https://example.com:443/a", "https://example.com/a"},
+		{"keeps non-default port", lenient, "http://example.com:8080/a", "http://example.com:8080/a"},
+		{"keeps trailing slash by default", lenient, "http://example.com/a/", "http://example.com/a/"},
+		{"keeps fragment by default", lenient, "http://example.com/a#t=10", "http://example.com/a#t=10"},
+		{"strips trailing slash", strict, "http://example.com/a/", "http://example.com/a"},
+		{"keeps root slash", strict, "http://example.com/", "http://example.com/"},
+		{"strips fragment", strict, "http://example.com/a#t=10", "http://example.com/a"},
+		{"never touches query", strict, "http://example.com/watch?v=ABC&b=2", "http://example.com/watch?v=ABC&b=2"},
+		{"ipv6 default port", lenient, "http://[::1]:80/a", "http://[::1]/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.norm.NormalizeURL(u); got != tt.expected {
+				t.Errorf("NormalizeURL(%q) = %q; want %q", tt.in, got, tt.expected)
+			}
+		})
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeConfig(t, `
+listen_addr: ":9000"
+db_path: "file.db"
+downloads_dir: "file-downloads"
+`)
+
+	t.Run("file beats default", func(t *testing.T) {
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ListenAddr != ":9000" || cfg.DBPath != "file.db" || cfg.DownloadsDir != "file-downloads" {
+			t.Fatalf("expected file values, got %+v", cfg)
+		}
+	})
+
+	t.Run("env beats file", func(t *testing.T) {
+		t.Setenv("LOWTIDE_LISTEN_ADDR", ":7000")
+		t.Setenv("LOWTIDE_DB_PATH", "env.db")
+		t.Setenv("LOWTIDE_DOWNLOADS_DIR", "env-downloads")
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ListenAddr != ":7000" || cfg.DBPath != "env.db" || cfg.DownloadsDir != "env-downloads" {
+			t.Fatalf("expected env values, got %+v", cfg)
+		}
+	})
+
+	t.Run("env beats default", func(t *testing.T) {
+		t.Setenv("LOWTIDE_DB_PATH", "env.db")
+		cfg, err := Load(writeConfig(t, "apps: []\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.DBPath != "env.db" || cfg.ListenAddr != ":8080" {
+			t.Fatalf("expected env db_path and default listen_addr, got %+v", cfg)
+		}
+	})
+}
+
+func TestLoadAppsDir(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "apps.d"), 0o755)
+	os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+apps_dir: apps.d
+apps:
+  - id: inline
+    name: Inline
+    command: echo
+`), 0o644)
+	os.WriteFile(filepath.Join(dir, "apps.d", "a.yaml"), []byte(`
+id: single
+name: Single
+command: curl
+`), 0o644)
+	os.WriteFile(filepath.Join(dir, "apps.d", "b.yaml"), []byte(`
+- id: list-1
+  name: List 1
+  command: yt-dlp
+- id: list-2
+  name: List 2
+  command: yt-dlp
+`), 0o644)
+	os.WriteFile(filepath.Join(dir, "apps.d", "ignored.txt"), []byte("not yaml"), 0o644)
+
+	cfg, err := Load(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, a := range cfg.Apps {
+		ids = append(ids, a.ID)
+	}
+	want := []string{"inline", "single", "list-1", "list-2"}
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected apps %v, got %v", want, ids)
+	}
+
+	// A duplicate ID in another file fails validation.
+	os.WriteFile(filepath.Join(dir, "apps.d", "c.yaml"), []byte("id: single\nname: Again\ncommand: curl\n"), 0o644)
+	if _, err := Load(filepath.Join(dir, "config.yaml")); err == nil || !strings.Contains(err.Error(), `duplicate id "single"`) {
+		t.Fatalf("expected duplicate id error, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		apps    []AppConfig
+		wantErr string
+	}{
+		{"valid", []AppConfig{{ID: "a", Command: "echo"}, {ID: "b", Command: "echo", Regex: "^https://"}}, ""},
+		{"missing id", []AppConfig{{Name: "A", Command: "echo"}}, "missing id"},
+		{"missing command", []AppConfig{{ID: "a"}}, "missing command"},
+		{"duplicate id", []AppConfig{{ID: "a", Command: "echo"}, {ID: "a", Command: "echo"}}, `duplicate id "a"`},
+		{"bad regex", []AppConfig{{ID: "a", Command: "echo", Regex: "("}}, "invalid regex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{Apps: tt.apps}).Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	if _, err := Load("config.yaml"); err != nil {
+		t.Fatalf("shipped example config should load: %v", err)
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	xdg := t.TempDir()
+	cwd := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv("LOWTIDE_CONFIG", "")
+	t.Chdir(cwd)
+
+	if _, err := ResolveConfigPath(""); err == nil || !strings.Contains(err.Error(), filepath.Join(xdg, "lowtide", "config.yaml")) {
+		t.Fatalf("expected error listing searched paths, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(cwd, "config.yaml"), []byte("apps: []\n"), 0o644)
+	if got, err := ResolveConfigPath(""); err != nil || got != "config.yaml" {
+		t.Fatalf("expected ./config.yaml, got %q (%v)", got, err)
+	}
+
+	xdgPath := filepath.Join(xdg, "lowtide", "config.yaml")
+	os.MkdirAll(filepath.Dir(xdgPath), 0o755)
+	os.WriteFile(xdgPath, []byte("apps: []\n"), 0o644)
+	if got, err := ResolveConfigPath(""); err != nil || got != xdgPath {
+		t.Fatalf("expected XDG config to win over ./config.yaml, got %q (%v)", got, err)
+	}
+
+	t.Setenv("LOWTIDE_CONFIG", "/from/env.yaml")
+	if got, _ := ResolveConfigPath(""); got != "/from/env.yaml" {
+		t.Fatalf("expected LOWTIDE_CONFIG to win over search paths, got %q", got)
+	}
+	if got, _ := ResolveConfigPath("/from/flag.yaml"); got != "/from/flag.yaml" {
+		t.Fatalf("expected --config to win over everything, got %q", got)
+	}
+}
+
+func TestWriteDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lowtide", "config.yaml")
+
+	created, err := WriteDefault(path)
+	if err != nil || !created {
+		t.Fatalf("expected starter config to be created, got created=%v err=%v", created, err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("starter config should load: %v", err)
+	}
+	if len(cfg.Apps) < 2 {
+		t.Fatalf("expected example apps in starter config, got %d", len(cfg.Apps))
+	}
+
+	os.WriteFile(path, []byte("apps: []\n"), 0o644)
+	if created, err := WriteDefault(path); err != nil || created {
+		t.Fatalf("expected existing config to be left alone, got created=%v err=%v", created, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "apps: []\n" {
+		t.Fatalf("existing config was overwritten: %q", data)
+	}
+}
+
+func TestSaveAppsSkipsAppsDir(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "apps.d"), 0o755)
+	os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("apps_dir: apps.d\napps: []\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "apps.d", "a.yaml"), []byte("id: external\nname: External\ncommand: curl\n"), 0o644)
+
+	cfg, err := Load(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := append(cfg.Apps, AppConfig{ID: "inline", Name: "Inline", Command: "echo"})
+	if err := cfg.SaveApps(apps); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := Load(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("saved config should load (apps_dir app must not be duplicated inline): %v", err)
+	}
+	if len(reloaded.Apps) != 2 || reloaded.Apps[0].ID != "inline" || !reloaded.Apps[1].FromAppsDir() {
+		t.Fatalf("unexpected apps after save: %+v", reloaded.Apps)
+	}
+}
+
+func TestValidateLogLevel(t *testing.T) {
+	if err := (&Config{LogLevel: "debug"}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (&Config{LogLevel: "loud"}).Validate(); err == nil || !strings.Contains(err.Error(), "log_level") {
+		t.Fatalf("expected log_level error, got %v", err)
+	}
+}
+
+func TestPermYAML(t *testing.T) {
+	for _, in := range []string{`"0775"`, `0775`, `0o775`, `"775"`} {
+		cfg, err := Load(writeConfig(t, "dir_mode: "+in+"\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if cfg.DirMode != 0o775 {
+			t.Fatalf("%s: expected 0775, got %o", in, cfg.DirMode)
+		}
+	}
+	if _, err := Load(writeConfig(t, "file_mode: rw-r--r--\n")); err == nil {
+		t.Fatal("expected error for non-octal mode")
+	}
+	if got := Perm(0).Or(0o644); got != 0o644 {
+		t.Fatalf("expected default when unset, got %o", got)
+	}
+}
+
+func TestWatchModeConfig(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "watch_mode: poll\npoll_interval: 500ms\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.WatchMode != WatchModePoll || cfg.PollInterval != 500*time.Millisecond {
+		t.Fatalf("unexpected watch settings: %q %s", cfg.WatchMode, cfg.PollInterval)
+	}
+	if _, err := Load(writeConfig(t, "watch_mode: inotify\n")); err == nil || !strings.Contains(err.Error(), "watch_mode") {
+		t.Fatalf("expected watch_mode error, got %v", err)
+	}
+}
+
+func TestValidateTimezone(t *testing.T) {
+	if err := (&Config{Timezone: "UTC"}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (&Config{Timezone: "Mars/Olympus"}).Validate(); err == nil || !strings.Contains(err.Error(), "timezone") {
+		t.Fatalf("expected timezone error, got %v", err)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	app := AppConfig{
+		ID:           "yt",
+		AllowedHosts: []string{"youtube.com", "*.youtube.com", "youtu.be"},
+		DeniedHosts:  []string{"music.youtube.com"},
+	}
+	for host, ok := range map[string]bool{
+		"youtube.com":       true,
+		"www.youtube.com":   true,
+		"m.YouTube.com.":    true,
+		"youtu.be":          true,
+		"music.youtube.com": false, // denied wins over the wildcard
+		"evilyoutube.com":   false,
+		"youtube.com.evil":  false,
+		"example.com":       false,
+	} {
+		if err := app.CheckHost(host); (err == nil) != ok {
+			t.Errorf("CheckHost(%q) = %v, want allowed=%v", host, err, ok)
+		}
+	}
+
+	denyOnly := AppConfig{ID: "any", DeniedHosts: []string{"*.internal"}}
+	if err := denyOnly.CheckHost("example.com"); err != nil {
+		t.Errorf("expected hosts outside denied_hosts to be allowed, got %v", err)
+	}
+	if err := denyOnly.CheckHost("nas.internal"); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("expected denied error, got %v", err)
+	}
+}
+
+func TestValidateHostPatterns(t *testing.T) {
+	cfg := &Config{Apps: []AppConfig{{ID: "a", Command: "true", AllowedHosts: []string{"*.ok.com", "bad.*.com"}}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "bad.*.com") {
+		t.Fatalf("expected invalid host pattern error, got %v", err)
+	}
+}
+
+func TestValidateScratchDirNeedsOutputDir(t *testing.T) {
+	cfg := &Config{Apps: []AppConfig{{ID: "a", Command: "yt-dlp", Args: []string{"-P", ".", "%u"}, ScratchDir: true}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "%o") {
+		t.Fatalf("expected scratch_dir without %%o to be rejected, got %v", err)
+	}
+	cfg.Apps[0].Args = []string{"-P", "%o", "%u"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateConcurrencyPlaceholder(t *testing.T) {
+	cfg := &Config{Apps: []AppConfig{{ID: "a", Command: "yt-dlp", Args: []string{"-N", "%n", "%u"}}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "concurrency") {
+		t.Fatalf("expected %%n without concurrency to be rejected, got %v", err)
+	}
+	cfg.Apps[0].Concurrency = 4
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg.Apps[0].Concurrency = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "concurrency must be positive") {
+		t.Fatalf("expected negative concurrency to be rejected, got %v", err)
+	}
+}
+
+func TestValidateTerminalSize(t *testing.T) {
+	cfg := &Config{TerminalRows: 40, ScrollbackLines: 30}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "scrollback_lines must be at least terminal_rows") {
+		t.Fatalf("expected scrollback smaller than the screen to be rejected, got %v", err)
+	}
+	cfg.ScrollbackLines = 5000
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Run with -race: lookups must not race with the app list being replaced.
+func TestAppLookupsDuringReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("apps:\n  - id: a\n    name: A\n    command: echo\n    regex: '^https://a\\.example/'\n"), 0o644)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if app := cfg.GetApp("a"); app == nil || app.Command != "echo" {
+					t.Errorf("lost app a during reload: %+v", app)
+					return
+				}
+				if app := cfg.MatchAppForURL("https://a.example/x"); app == nil || app.ID != "a" {
+					t.Errorf("lost regex match during reload: %+v", app)
+					return
+				}
+				_ = cfg.AppList()
+			}
+		}()
+	}
+	held := cfg.GetApp("a")
+	for i := 0; i < 200; i++ {
+		reloaded, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps := reloaded.AppList()
+		apps[0].Name = fmt.Sprintf("A%d", i)
+		cfg.SetApps(apps)
+	}
+	close(done)
+	wg.Wait()
+
+	if held.Name != "A" {
+		t.Fatalf("an app handed out before the reload changed under its holder: %+v", held)
+	}
+	if got := cfg.GetApp("a").Name; got != "A199" {
+		t.Fatalf("expected the last reload to win, got %q", got)
+	}
+}
+
+func TestWarnsWhenAppNeverGetsURL(t *testing.T) {
+	cfg := &Config{Apps: []AppConfig{
+		{ID: "placed", Command: "curl", Args: []string{"-O", "%u"}},
+		{ID: "appended", Command: "curl", Args: []string{"-O"}, AppendURL: true},
+		{ID: "forgot", Command: "curl", Args: []string{"-O"}},
+	}}
+	warnings := cfg.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `app "forgot"`) {
+		t.Fatalf("expected one warning for the app without %%u, got %q", warnings)
+	}
+	if cfg.Apps[0].ShouldAppendURL() || !cfg.Apps[1].ShouldAppendURL() {
+		t.Fatal("the URL should only be appended when append_url is set and args lack %u")
+	}
+}
@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package config
+
+import "os"
+
+// lockFile is a no-op without flock; saveMu still serializes saves made by this
+// process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on f, waiting for other processes to let go.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
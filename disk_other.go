@@ -0,0 +1,9 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+//go:build !(linux || darwin)
+
+package main
+
+// fsSpace is unavailable without statfs; /api/disk then omits free space.
+func fsSpace(path string) (total, free, avail uint64, ok bool) {
+	return 0, 0, 0, false
+}
@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// fsSpace reports the size, free and available (to unprivileged users) bytes
+// of the filesystem holding path.
+func fsSpace(path string) (total, free, avail uint64, ok bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, 0, false
+	}
+	// Field types differ between platforms (Bsize is int64 on linux, uint32 on
+	// darwin), so convert each one.
+	bs := uint64(st.Bsize)
+	return uint64(st.Blocks) * bs, uint64(st.Bfree) * bs, uint64(st.Bavail) * bs, true
+}
@@ -42,13 +42,13 @@
           '';
         };
 
-        low-tide = pkgs.buildGoModule {
+        low-tide = pkgs.buildGoModule (finalAttrs: {
           pname = "low-tide";
           version = "1.0.0";
           src = ./.;
 
           # vendorHash = pkgs.lib.fakeHash; # keep for development purposes
-          vendorHash = "sha256-Wtv1FSrs1EuPVZYUynwh8Q6/tYPEvMGQP0dwCUxG4EI=";
+          vendorHash = "sha256-17/NHkASE6z69eZJt7QmbRZQ+PyPC9u55r5AGLb6/6o=";
 
           preBuild = ''
             cp -r ${frontend}/static .
@@ -56,13 +56,15 @@
 
           subPackages = [ "." ];
 
+          ldflags = [ "-X main.version=${finalAttrs.version}" "-X main.commit=${self.shortRev or "dirty"}" ];
+
           nativeBuildInputs = [ pkgs.pkg-config pkgs.curl ];
           buildInputs = [ pkgs.sqlite ];
 
           env = {
             CGO_ENABLED = "1";
           };
-        };
+        });
 
         dockerImage = pkgs.dockerTools.buildLayeredImage {
           name = "low-tide";
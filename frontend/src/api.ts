@@ -37,11 +37,51 @@ export async function fetchJobLogs(id: number) {
   } catch (e) { console.error(e); }
 }
 
+// applyJobSnapshot merges a job snapshot and handles auto-navigation.
+function applyJobSnapshot(job: Job) {
+  const state = useJobStore.getState();
+  const oldStatus = state.jobs[job.id]?.status;
+  state.updateJob(job);
+
+  // Handle updates for the currently selected job
+  if (job.id === state.selectedJobId) {
+    if (oldStatus === 'running' && (job.status === 'success' || job.status === 'failed' || job.status === 'cancelled')) {
+      // Current job just finished
+      if (job.status === 'success') {
+        navigate(`/job/${job.id}`);
+      }
+
+      // Check if we should auto-navigate to another running job
+      const otherRunningJob = Object.values(state.jobs).find(j => j.id !== job.id && j.status === 'running');
+      if (otherRunningJob) {
+        navigate(`/job/${otherRunningJob.id}/logs`);
+      } else {
+        // No other job running yet, enable auto-navigation so the next job that starts will be auto-selected
+        state.setShouldAutoNavigateToNewJobs(true);
+      }
+    } else if (oldStatus === 'queued' && job.status === 'running') {
+      // If the currently selected job just started, show logs
+      navigate(`/job/${job.id}/logs`);
+    }
+  } else if (job.status === 'running' && state.shouldAutoNavigateToNewJobs) {
+    // Auto-navigate to a newly running job (only if auto-navigation is enabled)
+    navigate(`/job/${job.id}/logs`);
+  }
+}
+
 let socket: WebSocket | null = null;
 
+// Last event seq seen and the server boot it belongs to, used to detect gaps.
+let lastSeq: number | null = null;
+let lastBoot: number | null = null;
+
 export function connectWebSocket() {
   const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
-  const ws = new WebSocket(proto + '//' + location.host + '/ws/state');
+  let url = proto + '//' + location.host + '/ws/state';
+  if (lastSeq !== null && lastBoot !== null) {
+    url += `?last_seq=${lastSeq}&boot=${lastBoot}`;
+  }
+  const ws = new WebSocket(url);
   socket = ws;
 
   ws.onmessage = (ev) => {
@@ -49,39 +89,30 @@ export function connectWebSocket() {
       if (typeof ev.data !== 'string') return;
 
       const msg = JSON.parse(ev.data);
-      if (msg.type === 'job_snapshot' && msg.job) {
-        const job = (msg.job as Job);
-
-        const state = useJobStore.getState();
-        const oldStatus = state.jobs[job.id]?.status;
-        state.updateJob(job);
-
-        // Handle updates for the currently selected job
-        if (job.id === state.selectedJobId) {
-          if (oldStatus === 'running' && (job.status === 'success' || job.status === 'failed' || job.status === 'cancelled')) {
-            // Current job just finished
-            if (job.status === 'success') {
-              navigate(`/job/${job.id}`);
-            }
-
-            // Check if we should auto-navigate to another running job
-            const otherRunningJob = Object.values(state.jobs).find(j => j.id !== job.id && j.status === 'running');
-            if (otherRunningJob) {
-              navigate(`/job/${otherRunningJob.id}/logs`);
-            } else {
-              // No other job running yet, enable auto-navigation so the next job that starts will be auto-selected
-              state.setShouldAutoNavigateToNewJobs(true);
-            }
-          } else if (oldStatus === 'queued' && job.status === 'running') {
-            // If the currently selected job just started, show logs
-            navigate(`/job/${job.id}/logs`);
-          }
-        } else if (job.status === 'running' && state.shouldAutoNavigateToNewJobs) {
-          // Auto-navigate to a newly running job (only if auto-navigation is enabled)
-          navigate(`/job/${job.id}/logs`);
+      if (msg.type === 'hello') {
+        if (msg.resync) loadInitialData();
+        lastSeq = msg.seq;
+        lastBoot = msg.boot;
+        return;
+      }
+      if (typeof msg.seq === 'number') {
+        if (lastSeq !== null && msg.seq !== lastSeq + 1) {
+          // We missed events (dropped while the socket was slow); refetch the list.
+          loadInitialData();
         }
+        lastSeq = msg.seq;
+      }
+
+      if (msg.type === 'job_snapshot' && msg.job) {
+        applyJobSnapshot(msg.job as Job);
+      } else if (msg.type === 'job_snapshots' && Array.isArray(msg.jobs)) {
+        (msg.jobs as Job[]).forEach(applyJobSnapshot);
       } else if (msg.type === 'job_log') {
         window.dispatchEvent(new CustomEvent('job-log-stream', { detail: msg }));
+      } else if (msg.type === 'job_done') {
+        // Final render replaces whatever the delta stream built up.
+        logBuffers[msg.job_id] = msg.logs;
+        window.dispatchEvent(new CustomEvent('job-logs-loaded', { detail: { jobId: msg.job_id } }));
       }
     } catch (e) { console.error(e); }
   };
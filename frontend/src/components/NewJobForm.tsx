@@ -70,7 +70,7 @@ export const NewJobForm = () => {
         </FieldWrapper>
 
         <FieldWrapper>
-          <label className="lt-label" htmlFor="urls">URLs (one per line or space-separated)</label>
+          <label className="lt-label" htmlFor="urls">URLs (one per line, or separated by spaces or commas)</label>
           <textarea 
             className="lt-textarea"
             id="urls" 
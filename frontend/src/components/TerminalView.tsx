@@ -31,15 +31,29 @@ export const TerminalView = ({ jobId }: { jobId: number }) => {
     const handleStream = (e: any) => {
       if (e.detail.job_id === jobId && termRef.current) {
         const msg = e.detail;
-        // Streams carry “delta lines”: a map of { lineIndex: htmlString }.
-        if (msg.lines) {
+        // Streams carry “delta lines”: a map of { lineIndex: htmlString },
+        // preceded by a scroll count when output pushed lines off the top.
+        if (msg.lines || msg.scroll) {
           const wasAtBottom = isAtBottom();
 
+          // Scrolling drops the top lines and shifts the rest up; the indexes
+          // in msg.lines are already the shifted ones.
+          if (msg.scroll) {
+            termRef.current.querySelectorAll('[data-line]').forEach((div) => {
+              const idx = parseInt(div.getAttribute('data-line') || '0') - msg.scroll;
+              if (idx < 0) {
+                div.remove();
+              } else {
+                div.setAttribute('data-line', String(idx));
+              }
+            });
+          }
+
           // For each line in the delta:
           // - If the line already exists in the DOM, replace it (outerHTML).
           // - If it doesn’t exist (common after a retry that cleared the buffer),
           //   append it to the end so the terminal shows new output immediately.
-          for (const [idxStr, htmlLine] of Object.entries(msg.lines)) {
+          for (const [idxStr, htmlLine] of Object.entries(msg.lines || {})) {
             const idx = parseInt(idxStr);
             let lineDiv = termRef.current.querySelector(`[data-line="${idx}"]`);
             if (lineDiv) {
@@ -4,12 +4,27 @@ export interface FileInfo {
   size_bytes: number;
 }
 
+export interface RunEnv {
+  command: string;
+  args: string[];
+  dir: string;
+  env?: Record<string, string>;
+  rows: number;
+  cols: number;
+}
+
 export interface Job {
   id: number;
   title?: string;
   url?: string;
   original_url?: string;
+  app_id?: string;
+  app_name?: string;
+  command_line?: string;
+  tags?: string[];
+  run_env?: RunEnv;
   status: 'queued' | 'running' | 'success' | 'failed' | 'cancelled' | 'cleaned';
+  failure_kind?: 'exit_nonzero' | 'timeout' | 'no_output' | 'unknown_app' | 'too_many_files' | 'internal';
   created_at: string;
   archived: boolean;
   image_path?: string;
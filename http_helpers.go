@@ -2,32 +2,109 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
-	"unicode"
 
 	"mime"
 	"net/url"
 	"path/filepath"
 
 	"bufio"
+
+	"low-tide/config"
+	"low-tide/internal/logging"
+	"low-tide/internal/slug"
 )
 
+// requireAdmin guards admin endpoints. They are disabled unless admin_token is
+// configured, and then require "Authorization: Bearer <token>".
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Cfg.AdminToken == "" {
+			writeJSONError(w, http.StatusForbidden, "admin endpoints are disabled; set admin_token to enable them")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="low-tide"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSONError responds with {"error": msg}, so API clients can parse failures
+// the same way as successful responses.
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// contentDisposition names a download. Clients that understand filename* get
+// the exact UTF-8 name; the plain filename is an ASCII fallback without
+// characters that Windows or macOS refuse to save (":", "?", "*", ...).
 func contentDisposition(filename string) string {
 	base := filepath.Base(filename)
 
 	return mime.FormatMediaType("attachment", map[string]string{
-		"filename":  base,                             // quoted-string
-		"filename*": "UTF-8''" + url.PathEscape(base), // RFC 5987
+		"filename":  slug.Filename(base, "download"),  // quoted-string
+		"filename*": "UTF-8''" + extValueEscape(base), // RFC 5987
 	})
 }
 
+// extValueEscape percent-encodes everything but RFC 5987 attr-chars, so the
+// encoded name is a token and never ends up quoted.
+func extValueEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// archiveContentTypes are set explicitly: mime.TypeByExtension only knows .zip
+// when the system has a mime.types file, which slim images lack.
+var archiveContentTypes = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+// resolvesWithin reports whether path is inside dir once symlinks in both are
+// resolved, so a symlink in a job dir can't serve files from elsewhere.
+func resolvesWithin(dir, path string) bool {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err == nil {
+		realDir, err = filepath.Abs(realDir)
+	}
+	if err != nil {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(realDir, realPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
 func setDownloadHeaders(w http.ResponseWriter, filename string) {
 	w.Header().Set("Content-Disposition", contentDisposition(filename))
 	if ext := filepath.Ext(filename); ext != "" {
@@ -38,41 +115,340 @@ func setDownloadHeaders(w http.ResponseWriter, filename string) {
 }
 
 // loggingMiddleware logs basic request information for every HTTP request.
+// Requests are logged at debug level; server errors are raised to warn.
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s%s\t| done in %s, served to %s", r.Method, r.URL.Path, r.URL.RawQuery, time.Since(start), r.RemoteAddr)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		logf := logging.Debugf
+		if rec.Status() >= 500 {
+			logf = logging.Warnf
+		}
+		logf("%s %s%s\t| %d, %d bytes in %s, served to %s", r.Method, r.URL.Path, redactedQuery(r.URL.RawQuery), rec.Status(), rec.bytes, time.Since(start), r.RemoteAddr)
 	})
 }
 
-func splitURLs(s string) []string {
+func redactedQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	return "?" + logging.RedactQuery(rawQuery)
+}
+
+// statusRecorder captures the response status and body size for the access log.
+// It passes Hijack and Flush through so websockets and streaming keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the recorded status, 101 for hijacked (websocket) connections
+// and 200 if the handler never wrote anything.
+func (r *statusRecorder) Status() int {
+	switch {
+	case r.status != 0:
+		return r.status
+	case r.hijacked:
+		return http.StatusSwitchingProtocols
+	default:
+		return http.StatusOK
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// compressMinSize is the smallest response worth gzipping.
+const compressMinSize = 1024
+
+// gzipMiddleware compresses text, JSON, JS and CSS responses for clients that
+// accept gzip. Websockets, range requests, HEAD and file/archive downloads are
+// passed through untouched so upgrades, resumable downloads and streaming keep
+// working.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skipCompression(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func skipCompression(r *http.Request) bool {
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		return true
+	}
+	p := r.URL.Path
+	return strings.HasPrefix(p, "/ws/") || strings.HasPrefix(p, "/thumbnails/") ||
+		strings.HasPrefix(p, "/api/jobs/") && (strings.HasSuffix(p, "/zip") || strings.HasSuffix(p, "/tar.gz") || strings.Contains(p, "/files/"))
+}
+
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
+
+func compressibleType(ct string) bool {
+	mt, _, _ := mime.ParseMediaType(ct)
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/javascript", mt == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the first compressMinSize bytes so it can decide
+// whether compressing is worthwhile before any header is sent.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.decided {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		g.buf = append(g.buf, b...)
+		if len(g.buf) < compressMinSize {
+			return len(b), nil
+		}
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressed or not, and flushes the held-back bytes.
+func (g *gzipResponseWriter) decide() error {
+	g.decided = true
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	status := g.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if len(g.buf) >= compressMinSize && status == http.StatusOK &&
+		h.Get("Content-Encoding") == "" && compressibleType(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		// The compressed bytes differ from the original, so a strong validator
+		// would be wrong; If-None-Match compares weakly, so 304s still work.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if g.gz != nil {
+		_, err := g.gz.Write(buf)
+		return err
+	}
+	_, err := g.ResponseWriter.Write(buf)
+	return err
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		_ = g.decide()
+	}
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response; handlers that wrote fewer than compressMinSize
+// bytes (or nothing at all) are sent as-is.
+func (g *gzipResponseWriter) Close() error {
+	if !g.decided {
+		if g.status == 0 && len(g.buf) == 0 {
+			// Nothing written: let net/http send its default response.
+			return nil
+		}
+		if err := g.decide(); err != nil {
+			return err
+		}
+	}
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// rejectedURL describes a token from the submitted URL list that was skipped.
+type rejectedURL struct {
+	Line   int    `json:"line"`
+	Input  string `json:"input"`
+	Reason string `json:"reason"`
+}
+
+// submittedURL is a valid URL from the submitted list, with where it came from
+// so later checks can report it like splitURLs reports rejected tokens.
+type submittedURL struct {
+	URL   string
+	Line  int
+	Input string
+}
+
+// splitURLs extracts the valid http(s) URLs from user input, one or more per line,
+// dropping duplicates as judged by the normalization rules. Skipped tokens are
+// returned alongside so the user can be told which lines failed.
+func splitURLs(s string, norm config.URLNormalization) ([]submittedURL, []rejectedURL) {
 	scanner := bufio.NewScanner(strings.NewReader(s))
 	seen := map[string]struct{}{}
-	var out []string
+	var out []submittedURL
+	var rejected []rejectedURL
 
+	lineNo := 0
 	for scanner.Scan() {
+		lineNo++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
 
-		for _, rawURL := range strings.Fields(line) {
-			u, err := url.ParseRequestURI(rawURL)
-			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
-				log.Printf("skipping invalid URL: %q (err=%v)", rawURL, err)
+		for _, rawURL := range splitURLTokens(line) {
+			u, reason := parseSubmittedURL(rawURL)
+			if reason != "" {
+				rejected = append(rejected, rejectedURL{Line: lineNo, Input: rawURL, Reason: reason})
 				continue
 			}
 
-			f := u.String()
-			if _, ok := seen[f]; ok {
+			key := norm.NormalizeURL(u)
+			if _, ok := seen[key]; ok {
+				rejected = append(rejected, rejectedURL{Line: lineNo, Input: rawURL, Reason: "duplicate"})
 				continue
 			}
-			seen[f] = struct{}{}
-			out = append(out, f)
+			seen[key] = struct{}{}
+			out = append(out, submittedURL{URL: u.String(), Line: lineNo, Input: rawURL})
 		}
 	}
 
+	return out, rejected
+}
+
+// parseSubmittedURL parses one submitted URL, or returns why it was skipped.
+func parseSubmittedURL(rawURL string) (*url.URL, string) {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		logging.Debugf("skipping invalid URL: %q", logging.RedactURL(rawURL))
+		return nil, "invalid URL"
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		logging.Debugf("skipping URL with unsupported scheme: %q", logging.RedactURL(rawURL))
+		return nil, "only http and https URLs are supported"
+	}
+	return u, ""
+}
+
+// splitURLTokens splits a line on whitespace, and additionally on commas and
+// semicolons (as pasted from spreadsheets). Since query strings may legitimately
+// contain those characters, a separator only splits when what follows it is empty
+// or starts a new http(s) URL.
+func splitURLTokens(line string) []string {
+	var out []string
+	for _, field := range strings.Fields(line) {
+		start := 0
+		for i := 0; i < len(field); i++ {
+			if field[i] != ',' && field[i] != ';' {
+				continue
+			}
+			rest := strings.ToLower(strings.TrimLeft(field[i+1:], ",;"))
+			if rest == "" || strings.HasPrefix(rest, "http://") || strings.HasPrefix(rest, "https://") {
+				if part := strings.Trim(field[start:i], ",;"); part != "" {
+					out = append(out, part)
+				}
+				start = i + 1
+			}
+		}
+		if part := strings.Trim(field[start:], ",;"); part != "" {
+			out = append(out, part)
+		}
+	}
 	return out
 }
 
@@ -92,33 +468,62 @@ func toRelPath(root, abs string) string {
 	return rel
 }
 
-// parameterize creates a URL-safe version of the string, similar to Rails parameterize.
-// e.g. "This is my Happy String" -> "this-is-my-happy-string"
-func parameterize(s string, fallback string) string {
-	f := func(r rune) bool {
-		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+// archive helpers
+
+// archiveWriter streams job files into a zip or tar.gz response.
+type archiveWriter interface {
+	// SetRoot names the following files by their path relative to root, under
+	// the folder prefix ("" for the top level).
+	SetRoot(root, prefix string)
+	AddFile(path string) error
+	// AddNote adds a small generated text file in the current folder.
+	AddNote(name, body string) error
+	Close() error
+}
+
+// archiveNames maps file paths to entry names for an archiveWriter.
+type archiveNames struct {
+	rootPath string
+	prefix   string
+}
+
+func (a *archiveNames) SetRoot(root, prefix string) {
+	a.rootPath, a.prefix = root, prefix
+}
+
+// name returns the entry name for path, refusing paths outside the root so
+// entries can't point outside the folder they are extracted into.
+func (a *archiveNames) name(path string) (string, error) {
+	rel, err := filepath.Rel(a.rootPath, path)
+	if err != nil {
+		return "", err
 	}
-	parts := strings.FieldsFunc(strings.ToLower(s), f)
-	res := strings.Join(parts, "-")
-	if res == "" {
-		return fallback
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s is outside %s", path, a.rootPath)
 	}
-	return res
+	return a.join(filepath.ToSlash(rel)), nil
+}
+
+func (a *archiveNames) join(name string) string {
+	if a.prefix == "" {
+		return name
+	}
+	return a.prefix + "/" + name
 }
 
 // zip helpers
 
 type zipWriter struct {
-	zw       *zip.Writer
-	rootPath string
+	archiveNames
+	zw *zip.Writer
 }
 
-func newZipWriter(w http.ResponseWriter, root string) *zipWriter {
-	return &zipWriter{zw: zip.NewWriter(w), rootPath: root}
+func newZipWriter(w io.Writer, root string) *zipWriter {
+	return &zipWriter{archiveNames: archiveNames{rootPath: root}, zw: zip.NewWriter(w)}
 }
 
 func (z *zipWriter) AddFile(path string) error {
-	rel, err := filepath.Rel(z.rootPath, path)
+	name, err := z.name(path)
 	if err != nil {
 		return err
 	}
@@ -135,7 +540,7 @@ func (z *zipWriter) AddFile(path string) error {
 	if err != nil {
 		return err
 	}
-	header.Name = rel
+	header.Name = name
 	header.Method = zip.Deflate
 	w, err := z.zw.CreateHeader(header)
 	if err != nil {
@@ -145,10 +550,76 @@ func (z *zipWriter) AddFile(path string) error {
 	return err
 }
 
+// AddNote adds a small generated text file in the current folder.
+func (z *zipWriter) AddNote(name, body string) error {
+	w, err := z.zw.Create(z.join(name))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, body)
+	return err
+}
+
 func (z *zipWriter) Close() error {
 	return z.zw.Close()
 }
 
+// tar.gz helpers
+
+type tarGzWriter struct {
+	archiveNames
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer, root string) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{archiveNames: archiveNames{rootPath: root}, gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (t *tarGzWriter) AddFile(path string) error {
+	name, err := t.name(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// Copy exactly the size in the header, in case the file grew meanwhile.
+	_, err = io.CopyN(t.tw, f, header.Size)
+	return err
+}
+
+func (t *tarGzWriter) AddNote(name, body string) error {
+	header := &tar.Header{Name: t.join(name), Mode: 0o644, Size: int64(len(body)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.WriteString(t.tw, body)
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
 func isPublicURL(rawURL string) bool {
 	u, err := url.Parse(rawURL)
 	if err != nil {
@@ -158,7 +629,7 @@ func isPublicURL(rawURL string) bool {
 	host := u.Hostname()
 	ips, err := net.LookupIP(host)
 	if err != nil {
-		log.Printf("isPublicURL: lookup failed for %s: %v", host, err)
+		logging.Warnf("isPublicURL: lookup failed for %s: %v", host, err)
 		return false
 	}
 
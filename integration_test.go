@@ -2,17 +2,31 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,6 +34,7 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	"low-tide/config"
+	"low-tide/internal/logging"
 	"low-tide/jobs"
 	"low-tide/store"
 )
@@ -263,6 +278,7 @@ func TestIntegration_RetryAndCleanup(t *testing.T) {
 
 	dbPath := filepath.Join(tmpDir, "test.db")
 	downloadsDir := filepath.Join(tmpDir, "downloads")
+	failFlag := filepath.Join(tmpDir, "fail_flag")
 	os.MkdirAll(downloadsDir, 0755)
 
 	db, _ := sql.Open("sqlite3", dbPath+"?_fk=1")
@@ -272,15 +288,14 @@ func TestIntegration_RetryAndCleanup(t *testing.T) {
 	cfg := &config.Config{
 		DBPath:       dbPath,
 		DownloadsDir: downloadsDir,
-		Apps:         []config.AppConfig{{ID: "fail-then-succeed", Command: "sh", Args: []string{"-c", "if [ -f fail_flag ]; then rm fail_flag; exit 1; else echo success > success.txt; fi"}}},
+		Apps:         []config.AppConfig{{ID: "fail-then-succeed", Command: "sh", Args: []string{"-c", "if [ -f \"$1\" ]; then rm \"$1\"; exit 1; else echo success > success.txt; fi", "sh", failFlag}}},
 		StrictURLValidation: false,
 	}
 
-	// In the new system, jobs run in their own folder.
-	// For the initial run (job 1), we need to create the fail_flag in its folder.
+	// The first run fails while the flag exists. The manager creates the job's
+	// folder itself, so the flag lives outside downloads_dir.
 	job1Dir := filepath.Join(downloadsDir, "1")
-	os.MkdirAll(job1Dir, 0755)
-	os.WriteFile(filepath.Join(job1Dir, "fail_flag"), []byte("fail"), 0644)
+	os.WriteFile(failFlag, []byte("fail"), 0644)
 
 	mgr, _ := jobs.NewManager(db, cfg)
 	srv := NewServer(db, cfg, mgr)
@@ -354,7 +369,7 @@ func TestIntegration_PathSafetyAndWeirdURLs(t *testing.T) {
 
 	// 2. Path Safety
 	// Inject a job file with a malicious path manually into DB
-	store.InsertJob(db, "test", "http://test.com", time.Now())
+	store.InsertJob(db, "test", "Test", "http://test.com", time.Now())
 	secretPath := filepath.Join(tmpDir, "secret.txt")
 	os.WriteFile(secretPath, []byte("sensitive"), 0644)
 
@@ -405,6 +420,28 @@ func TestIntegration_URLValidation(t *testing.T) {
 		t.Fatalf("expected 400 for local URL with validation enabled, got %d", resp.StatusCode)
 	}
 
+	// Alongside a public URL, the local one is reported back as rejected.
+	resp, err = http.PostForm(tsEnabled.URL+"/api/jobs", url.Values{"app_id": {"test"}, "urls": {"http://93.184.216.34/\nhttp://127.0.0.1/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		IDs      []int64 `json:"ids"`
+		Rejected []struct {
+			Line   int    `json:"line"`
+			Input  string `json:"input"`
+			Reason string `json:"reason"`
+		} `json:"rejected"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(result.IDs) != 1 || len(result.Rejected) != 1 {
+		t.Fatalf("expected one job and one rejected URL, got %d: %+v", resp.StatusCode, result)
+	}
+	if rj := result.Rejected[0]; rj.Line != 2 || rj.Input != "http://127.0.0.1/" || rj.Reason != "not a public URL" {
+		t.Fatalf("unexpected rejected entry %+v", rj)
+	}
+
 	// Case 2: Validation Disabled
 	cfgDisabled := &config.Config{
 		DBPath:       dbPath,
@@ -543,3 +580,2549 @@ func TestIntegration_CancelQueuedJob(t *testing.T) {
 	http.Post(ts.URL+fmt.Sprintf("/api/jobs/%d/cancel", runningJobID), "", nil)
 	time.Sleep(300 * time.Millisecond)
 }
+
+// newTestServer wires a Low Tide server against a fresh database and downloads
+// directory. DBPath and DownloadsDir on cfg are filled in for the caller.
+func newTestServer(t *testing.T, cfg *config.Config) (*httptest.Server, *sql.DB, *jobs.Manager) {
+	t.Helper()
+	tmpDir, err := os.MkdirTemp("", "lowtide-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	cfg.DBPath = filepath.Join(tmpDir, "test.db")
+	cfg.DownloadsDir = filepath.Join(tmpDir, "downloads")
+	if err := os.MkdirAll(cfg.DownloadsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", cfg.DBPath+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := store.Init(db); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err := jobs.NewManager(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(db, cfg, mgr).Routes())
+	t.Cleanup(ts.Close)
+	return ts, db, mgr
+}
+
+func TestIntegration_UploadThumbnail(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{})
+
+	jobID, err := store.InsertJob(db, "test", "Test", "http://example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(body []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+fmt.Sprintf("/api/jobs/%d/thumbnail", jobID), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := put([]byte("<html>definitely not an image</html>")); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 for non-image upload, got %d", resp.StatusCode)
+	}
+
+	if resp := put(pngBuf.Bytes()); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 for png upload, got %d", resp.StatusCode)
+	}
+
+	j, _ := store.GetJob(db, jobID)
+	if j.ImagePath == nil || !strings.HasPrefix(*j.ImagePath, fmt.Sprintf("/thumbnails/%d.png", jobID)) {
+		t.Fatalf("expected png image path, got %v", j.ImagePath)
+	}
+
+	resp, err := http.Get(ts.URL + *j.ImagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, pngBuf.Bytes()) {
+		t.Fatalf("expected uploaded png back, got status %d (%d bytes)", resp.StatusCode, len(got))
+	}
+
+	// An oversized multipart upload is cut off while the form is parsed.
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("image", "huge.png")
+	part.Write(bytes.Repeat([]byte{0}, int(config.DefaultMaxThumbnailBytes)+thumbnailUploadOverhead))
+	mw.Close()
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+fmt.Sprintf("/api/jobs/%d/thumbnail", jobID), &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for an oversized multipart upload, got %d", resp2.StatusCode)
+	}
+}
+
+func TestIntegration_MetadataRefetchNotModified(t *testing.T) {
+	_, db, mgr := newTestServer(t, &config.Config{})
+
+	title := "Original Title"
+	var sawIfNoneMatch string
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawIfNoneMatch = r.Header.Get("If-None-Match")
+		if sawIfNoneMatch == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", title)
+	}))
+	defer page.Close()
+
+	jobID, _ := store.InsertJob(db, "test", "Test", page.URL, time.Now())
+	mgr.FetchAndSaveMetadata(jobID, page.URL)
+
+	j, _ := store.GetJob(db, jobID)
+	if j.Title != "Original Title" {
+		t.Fatalf("expected initial title, got %q", j.Title)
+	}
+
+	// The page would now report a different title, but answers 304 to our validator.
+	title = "Changed Title"
+	mgr.RefetchMetadata(jobID, page.URL)
+
+	if sawIfNoneMatch != `"v1"` {
+		t.Fatalf("expected refetch to send If-None-Match, got %q", sawIfNoneMatch)
+	}
+	j, _ = store.GetJob(db, jobID)
+	if j.Title != "Original Title" {
+		t.Fatalf("expected title to be kept on 304, got %q", j.Title)
+	}
+
+	// Another job for the same URL has never fetched it, so it must not reuse
+	// the first job's validators and end up keeping no metadata at all.
+	otherID, _ := store.InsertJob(db, "test", "Test", page.URL, time.Now())
+	mgr.RefetchMetadata(otherID, page.URL)
+	if sawIfNoneMatch != "" {
+		t.Fatalf("expected no If-None-Match for a job without validators, got %q", sawIfNoneMatch)
+	}
+	if other, _ := store.GetJob(db, otherID); other.Title != "Changed Title" {
+		t.Fatalf("expected the other job to get the current title, got %q", other.Title)
+	}
+}
+
+func TestIntegration_FetchMetadataDisabledForApp(t *testing.T) {
+	var hits atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "<html><head><title>Should not be fetched</title></head></html>")
+	}))
+	defer page.Close()
+
+	noFetch := false
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "direct", Command: "true", FetchMetadata: &noFetch}},
+	})
+
+	resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {"direct"}, "urls": {page.URL + "/file.bin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var postResult struct{ IDs []int64 }
+	json.NewDecoder(resp.Body).Decode(&postResult)
+	resp.Body.Close()
+	if len(postResult.IDs) != 1 {
+		t.Fatalf("expected 1 job, got %v", postResult.IDs)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("expected no metadata requests, got %d", n)
+	}
+	j, _ := store.GetJob(db, postResult.IDs[0])
+	if !strings.HasSuffix(j.Title, "/file.bin") {
+		t.Fatalf("expected URL-derived title, got %q", j.Title)
+	}
+}
+
+func TestIntegration_SubmitFetchesMetadata(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Plain Title</title><meta property="og:title" content="Fetched Title"></head></html>`)
+	}))
+	defer page.Close()
+
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "test", Command: "true"}},
+	})
+
+	resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {"test"}, "urls": {page.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var postResult struct{ IDs []int64 }
+	json.NewDecoder(resp.Body).Decode(&postResult)
+	resp.Body.Close()
+	if len(postResult.IDs) != 1 {
+		t.Fatalf("expected 1 job, got %v", postResult.IDs)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		j, _ := store.GetJob(db, postResult.IDs[0])
+		if j.Title == "Fetched Title" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected og:title to be saved by FetchAndSaveMetadata, got %q", j.Title)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestIntegration_SubmitJSONBatch(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Fetched Title</title></head></html>`)
+	}))
+	defer page.Close()
+
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "video", Name: "Video", Command: "true", Regex: `/watch`},
+			{ID: "file", Name: "File", Command: "true"},
+		},
+	})
+
+	batch := `[
+		{"url": "` + page.URL + `/a", "app_id": "file", "title": " My Title ", "tags": ["music", " live ", "", "music"]},
+		{"url": "` + page.URL + `/watch?v=1"},
+		{"url": "ftp://example.com/file"},
+		{"url": "` + page.URL + `/b", "app_id": "nope"}
+	]`
+	resp, err := http.Post(ts.URL+"/api/jobs", "application/json", strings.NewReader(batch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var result struct {
+		IDs      []int64 `json:"ids"`
+		Rejected []struct {
+			Line  int    `json:"line"`
+			Input string `json:"input"`
+		} `json:"rejected"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.IDs) != 2 {
+		t.Fatalf("expected 2 created jobs, got %v", result.IDs)
+	}
+	if len(result.Rejected) != 1 || result.Rejected[0].Line != 3 {
+		t.Fatalf("expected item 3 to be rejected, got %+v", result.Rejected)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], `"nope"`) {
+		t.Fatalf("expected an unknown app error, got %v", result.Errors)
+	}
+
+	// Wait for both metadata fetches, so a fetched title would have landed.
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		pending, _ := store.ListJobsPendingMetadata(db)
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metadata fetches did not finish: %d pending", len(pending))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	custom, _ := store.GetJob(db, result.IDs[0])
+	if custom.AppID != "file" || custom.Title != "My Title" || !custom.CustomTitle {
+		t.Errorf("expected app file with the given title, got %q / %q", custom.AppID, custom.Title)
+	}
+	if strings.Join(custom.Tags, ",") != "music,live" {
+		t.Errorf("expected tags [music live], got %q", custom.Tags)
+	}
+	matched, _ := store.GetJob(db, result.IDs[1])
+	if matched.AppID != "video" || matched.Title != "Fetched Title" || len(matched.Tags) != 0 {
+		t.Errorf("expected auto-matched app video with the fetched title, got %q / %q / %q", matched.AppID, matched.Title, matched.Tags)
+	}
+
+	// A batch where nothing can be created fails as a whole.
+	resp, err = http.Post(ts.URL+"/api/jobs", "application/json", strings.NewReader(`[{"url": "nope"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a batch without valid items, got %d", resp.StatusCode)
+	}
+}
+
+func TestSplitURLsNormalizedDedupe(t *testing.T) {
+	input := "http://Example.com/\nhttp://example.com\nhttp://example.com:80/ https://example.com/video/ https://example.com/video"
+
+	urlsOf := func(submitted []submittedURL) (out []string) {
+		for _, su := range submitted {
+			out = append(out, su.URL)
+		}
+		return out
+	}
+	got, _ := splitURLs(input, config.URLNormalization{})
+	want := []string{"http://Example.com/", "https://example.com/video/", "https://example.com/video"}
+	if !slices.Equal(urlsOf(got), want) {
+		t.Fatalf("lenient: expected %v, got %v", want, got)
+	}
+
+	got, _ = splitURLs(input, config.URLNormalization{StripTrailingSlash: true})
+	want = []string{"http://Example.com/", "https://example.com/video/"}
+	if !slices.Equal(urlsOf(got), want) {
+		t.Fatalf("strip trailing slash: expected %v, got %v", want, got)
+	}
+}
+
+func TestIntegration_SubmitReportsRejectedURLs(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "test", Command: "true"}},
+	})
+
+	input := "http://example.com/ok\nnot-a-url\nftp://example.com/file http://example.com/ok"
+	resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {"test"}, "urls": {input}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result struct {
+		IDs      []int64 `json:"ids"`
+		Rejected []struct {
+			Line   int    `json:"line"`
+			Input  string `json:"input"`
+			Reason string `json:"reason"`
+		} `json:"rejected"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.IDs) != 1 {
+		t.Fatalf("expected 1 created job, got %v", result.IDs)
+	}
+	if len(result.Rejected) != 3 {
+		t.Fatalf("expected 3 rejected entries, got %+v", result.Rejected)
+	}
+	want := []struct {
+		line  int
+		input string
+	}{{2, "not-a-url"}, {3, "ftp://example.com/file"}, {3, "http://example.com/ok"}}
+	for i, w := range want {
+		if result.Rejected[i].Line != w.line || result.Rejected[i].Input != w.input || result.Rejected[i].Reason == "" {
+			t.Errorf("rejected[%d] = %+v; want line %d input %q", i, result.Rejected[i], w.line, w.input)
+		}
+	}
+}
+
+func TestSplitURLTokens(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"http://a.com http://b.com", []string{"http://a.com", "http://b.com"}},
+		{"http://a.com,http://b.com", []string{"http://a.com", "http://b.com"}},
+		{"http://a.com, http://b.com;https://c.com;", []string{"http://a.com", "http://b.com", "https://c.com"}},
+		{"http://a.com,,;HTTPS://b.com", []string{"http://a.com", "HTTPS://b.com"}},
+		{"http://a.com/?ids=1,2;3 http://b.com", []string{"http://a.com/?ids=1,2;3", "http://b.com"}},
+	}
+	for _, tt := range tests {
+		got := splitURLTokens(tt.line)
+		if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("splitURLTokens(%q) = %q; want %q", tt.line, got, tt.expected)
+		}
+	}
+
+	mixed := "http://a.com,http://b.com\nhttp://c.com http://d.com;http://e.com\n\nhttp://f.com/?q=x,y"
+	urls, rejected := splitURLs(mixed, config.URLNormalization{})
+	if len(urls) != 6 || len(rejected) != 0 {
+		t.Fatalf("expected 6 urls and no rejections, got %v / %v", urls, rejected)
+	}
+	if urls[5].URL != "http://f.com/?q=x,y" || urls[5].Line != 4 {
+		t.Fatalf("expected query commas to be preserved on line 4, got %+v", urls[5])
+	}
+}
+
+// waitForJob polls until the job leaves the queued/running states.
+func waitForJob(t *testing.T, db *sql.DB, jobID int64) *store.Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		j, err := store.GetJob(db, jobID)
+		if err == nil && j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for job %d to finish", jobID)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// submitJob posts a single URL for appID and returns the created job ID.
+func submitJob(t *testing.T, ts *httptest.Server, appID, u string) int64 {
+	t.Helper()
+	resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {appID}, "urls": {u}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct{ IDs []int64 }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || len(result.IDs) != 1 {
+		t.Fatalf("submit %s failed: status %d, ids %v, err %v", u, resp.StatusCode, result.IDs, err)
+	}
+	return result.IDs[0]
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/dl/1/video.mp4", `attachment; filename=video.mp4; filename*=UTF-8''video.mp4`},
+		{"/dl/1/Q&A: why?.mp4", `attachment; filename="Q&A_ why_.mp4"; filename*=UTF-8''Q&A%3A%20why%3F.mp4`},
+		{"/dl/1/Café <live>.mp3", `attachment; filename="Cafe _live_.mp3"; filename*=UTF-8''Caf%C3%A9%20%3Clive%3E.mp3`},
+		{"/dl/1/日本語.mp4", `attachment; filename=download.mp4; filename*=UTF-8''%E6%97%A5%E6%9C%AC%E8%AA%9E.mp4`},
+	}
+	for _, tt := range tests {
+		got := contentDisposition(tt.path)
+		if got != tt.expected {
+			t.Errorf("contentDisposition(%q) = %s; want %s", tt.path, got, tt.expected)
+		}
+		// filename* takes precedence when parsed, and must give back the real name.
+		if _, params, err := mime.ParseMediaType(got); err != nil || params["filename"] != filepath.Base(tt.path) {
+			t.Errorf("contentDisposition(%q) decodes to %q (%v)", tt.path, params["filename"], err)
+		}
+	}
+}
+
+func TestIntegration_RenameToTitle(t *testing.T) {
+	noFetch := false
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:            "generic",
+			Command:       "sh",
+			Args:          []string{"-c", "echo hello world > index.html; echo x > other.txt; echo taken > my-great-page.html"},
+			FetchMetadata: &noFetch,
+			RenameToTitle: true,
+		}},
+	})
+
+	// The URL-derived title ("my-great-page") is used since metadata fetching is off.
+	jobID := submitJob(t, ts, "generic", "http://my-great-page/")
+	j := waitForJob(t, db, jobID)
+	if j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	files, _ := store.ListJobFiles(db, jobID)
+	var renamed *store.JobFile
+	for i, f := range files {
+		if strings.HasSuffix(f.Path, "index.html") {
+			t.Fatalf("expected index.html to be renamed, still have %s", f.Path)
+		}
+		if filepath.Base(f.Path) == "my-great-page-2.html" {
+			renamed = &files[i]
+		}
+	}
+	if renamed == nil || len(files) != 3 {
+		t.Fatalf("expected renamed file next to the colliding sibling, got %+v", files)
+	}
+
+	resp, err := http.Get(ts.URL + fmt.Sprintf("/api/jobs/%d/files/%d", jobID, renamed.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello world\n" {
+		t.Fatalf("expected renamed file to be served, got %d %q", resp.StatusCode, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "my-great-page-2.html") {
+		t.Fatalf("expected renamed filename in Content-Disposition, got %q", cd)
+	}
+}
+
+func TestIntegration_MetadataRecordsFinalURL(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/short" {
+			http.Redirect(w, r, "/final/page", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "<html><head><title>Final Page</title></head></html>")
+	}))
+	defer page.Close()
+
+	_, db, mgr := newTestServer(t, &config.Config{})
+
+	short := page.URL + "/short"
+	jobID, _ := store.InsertJob(db, "test", "Test", short, time.Now())
+	mgr.FetchAndSaveMetadata(jobID, short)
+
+	j, _ := store.GetJob(db, jobID)
+	if j.URL != page.URL+"/final/page" {
+		t.Fatalf("expected url to be the redirect target, got %q", j.URL)
+	}
+	if j.OriginalURL != short {
+		t.Fatalf("expected original_url to stay as submitted, got %q", j.OriginalURL)
+	}
+}
+
+func TestIntegration_JobKeepsAppNameAfterAppRemoved(t *testing.T) {
+	cfg := &config.Config{
+		Apps: []config.AppConfig{{ID: "labelled", Name: "Labelled App", Command: "true", FetchMetadata: new(bool)}},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+
+	jobID := submitJob(t, ts, "labelled", "http://example.com/a")
+	waitForJob(t, db, jobID)
+
+	// Simulate the app being dropped from config.
+	cfg.Apps = nil
+
+	resp, err := http.Get(ts.URL + fmt.Sprintf("/api/jobs/%d", jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var j store.Job
+	if err := json.NewDecoder(resp.Body).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	if j.AppID != "labelled" || j.AppName != "Labelled App" {
+		t.Fatalf("expected app label to survive config change, got id=%q name=%q", j.AppID, j.AppName)
+	}
+}
+
+func TestIntegration_StoresCommandLine(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:                 "echo",
+			Command:            "sh",
+			Args:               []string{"-c", "echo \"$0\" > out.txt", "%u"},
+			StripTrailingSlash: true,
+			FetchMetadata:      new(bool),
+		}},
+	})
+
+	jobID := submitJob(t, ts, "echo", "http://example.com/a?x=1&y=2/")
+	j := waitForJob(t, db, jobID)
+	if j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	expected := `sh -c 'echo "$0" > out.txt' 'http://example.com/a?x=1&y=2'`
+	if j.CommandLine != expected {
+		t.Fatalf("expected command line %s, got %s", expected, j.CommandLine)
+	}
+
+	// The URL the command actually received matches the recorded one.
+	files, _ := store.ListJobFiles(db, jobID)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 output file, got %d", len(files))
+	}
+	out, _ := os.ReadFile(files[0].Path)
+	if string(out) != "http://example.com/a?x=1&y=2\n" {
+		t.Fatalf("unexpected command output %q", out)
+	}
+}
+
+func TestIntegration_JobsListLimit(t *testing.T) {
+	cfg := &config.Config{JobsListLimit: 3}
+	ts, db, _ := newTestServer(t, cfg)
+	for i := 0; i < 5; i++ {
+		store.InsertJob(db, "test", "Test", fmt.Sprintf("http://example.com/%d", i), time.Now())
+	}
+
+	count := func(query string) (int, int) {
+		resp, err := http.Get(ts.URL + "/api/jobs" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var list []store.Job
+		json.NewDecoder(resp.Body).Decode(&list)
+		return resp.StatusCode, len(list)
+	}
+
+	if code, n := count(""); code != 200 || n != 3 {
+		t.Fatalf("expected config default of 3 jobs, got %d (status %d)", n, code)
+	}
+	if code, n := count("?limit=2"); code != 200 || n != 2 {
+		t.Fatalf("expected 2 jobs, got %d (status %d)", n, code)
+	}
+	if code, n := count("?limit=1000000"); code != 200 || n != 5 {
+		t.Fatalf("expected oversized limit to be capped and return all 5 jobs, got %d (status %d)", n, code)
+	}
+	for _, bad := range []string{"?limit=abc", "?limit=0", "?limit=-5"} {
+		if code, _ := count(bad); code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", bad, code)
+		}
+	}
+}
+
+func TestIntegration_JobsListStreamingMatchesEncoder(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{})
+
+	read := func() []byte {
+		resp, err := http.Get(ts.URL + "/api/jobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return body
+	}
+	encode := func() []byte {
+		list, err := store.ListJobs(db, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list == nil {
+			list = []store.Job{}
+		}
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(list)
+		return buf.Bytes()
+	}
+
+	if got, want := read(), encode(); !bytes.Equal(got, want) {
+		t.Fatalf("empty list mismatch:\ngot  %q\nwant %q", got, want)
+	}
+
+	for i := 0; i < 50; i++ {
+		id, _ := store.InsertJob(db, "test", "Test", fmt.Sprintf("http://example.com/%d?a=1&b=<2>", i), time.Now())
+		store.UpdateJobTitle(db, id, fmt.Sprintf("Title \"%d\"", i))
+	}
+	if got, want := read(), encode(); !bytes.Equal(got, want) {
+		t.Fatalf("list mismatch:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestIntegration_AdminVacuum(t *testing.T) {
+	cfg := &config.Config{AdminToken: "s3cret"}
+	ts, db, _ := newTestServer(t, cfg)
+	for i := 0; i < 20; i++ {
+		store.InsertJob(db, "test", "Test", fmt.Sprintf("http://example.com/%d", i), time.Now())
+	}
+
+	post := func(token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/vacuum", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := post(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", resp.StatusCode)
+	}
+	if resp := post("wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", resp.StatusCode)
+	}
+
+	resp := post("s3cret")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var out map[string]int64
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out["size_before"] <= 0 || out["size_after"] <= 0 {
+		t.Fatalf("expected sizes in response, got %v", out)
+	}
+}
+
+func TestIntegration_AdminDisabledWithoutToken(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/vacuum", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 when admin_token is unset, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_AppsCRUD(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(cfgPath, []byte(`# keep me
+listen_addr: ":8080" # and me
+apps:
+  - id: "echo"
+    name: "Echo"
+    command: "echo"
+`), 0o644)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AdminToken = "s3cret"
+	ts, _, _ := newTestServer(t, cfg)
+
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	reload := func() *config.Config {
+		c, err := config.Load(cfgPath)
+		if err != nil {
+			t.Fatalf("saved config no longer loads: %v", err)
+		}
+		return c
+	}
+
+	// Create
+	if resp := do("POST", "/api/apps", `{"id":"curl","name":"Curl","command":"curl","args":["-O","%u"]}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	if app := reload().GetApp("curl"); app == nil || app.Command != "curl" {
+		t.Fatalf("expected curl app persisted, got %+v", app)
+	}
+	if cfg.GetApp("curl") == nil {
+		t.Fatal("expected curl app to be live without restart")
+	}
+
+	// Validation rejects duplicates and incomplete apps without touching the file.
+	if resp := do("POST", "/api/apps", `{"id":"curl","name":"Again","command":"curl"}`); resp.StatusCode != 400 {
+		t.Fatalf("expected 400 for duplicate id, got %d", resp.StatusCode)
+	}
+	if resp := do("PUT", "/api/apps/curl", `{"name":"No command"}`); resp.StatusCode != 400 {
+		t.Fatalf("expected 400 for missing command, got %d", resp.StatusCode)
+	}
+	if len(reload().Apps) != 2 {
+		t.Fatal("rejected edits should not be persisted")
+	}
+
+	// Update
+	if resp := do("PUT", "/api/apps/curl", `{"name":"Curl (renamed)","command":"curl"}`); resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	resp := do("GET", "/api/apps/curl", "")
+	var got config.AppConfig
+	json.NewDecoder(resp.Body).Decode(&got)
+	if got.Name != "Curl (renamed)" {
+		t.Fatalf("expected updated name, got %q", got.Name)
+	}
+
+	// Delete
+	if resp := do("DELETE", "/api/apps/echo", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if resp := do("DELETE", "/api/apps/echo", ""); resp.StatusCode != 404 {
+		t.Fatalf("expected 404 for missing app, got %d", resp.StatusCode)
+	}
+	saved := reload()
+	if len(saved.Apps) != 1 || saved.Apps[0].Name != "Curl (renamed)" {
+		t.Fatalf("unexpected persisted apps: %+v", saved.Apps)
+	}
+
+	data, _ := os.ReadFile(cfgPath)
+	if !strings.Contains(string(data), "# keep me") || !strings.Contains(string(data), "# and me") {
+		t.Fatalf("expected comments outside apps to survive, got:\n%s", data)
+	}
+
+	// Without the token the API is closed.
+	req, _ := http.NewRequest("GET", ts.URL+"/api/apps", nil)
+	noAuth, _ := http.DefaultClient.Do(req)
+	noAuth.Body.Close()
+	if noAuth.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", noAuth.StatusCode)
+	}
+}
+
+func TestIntegration_AccessLogStatus(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+	logging.SetLevel(logging.LevelDebug)
+	t.Cleanup(func() { logging.SetLevel(logging.LevelInfo) })
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	resp, err := http.Get(ts.URL + "/definitely-not-here")
+	if err != nil {
+		log.SetOutput(os.Stderr)
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/state"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		log.SetOutput(os.Stderr)
+		t.Fatalf("websocket upgrade through logging middleware failed: %v", err)
+	}
+	conn.Close()
+	log.SetOutput(os.Stderr)
+
+	out := buf.String()
+	if !strings.Contains(out, "GET /definitely-not-here\t| 404,") {
+		t.Fatalf("expected 404 in access log, got:\n%s", out)
+	}
+}
+
+// lockedBuffer lets a test read log output while background goroutines write it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestIntegration_LogsRedactURLSecrets(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "noop", Command: "true"}},
+	})
+	// Debug is the chattiest level; if the secret stays out here it stays out everywhere.
+	logging.SetLevel(logging.LevelDebug)
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		logging.SetLevel(logging.LevelInfo)
+	})
+
+	// Nothing listens on port 1, so the metadata fetch fails and logs its error.
+	jobID := submitJob(t, ts, "noop", "http://127.0.0.1:1/file.mp4?token=supersecret")
+	waitForJob(t, db, jobID)
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), "failed to fetch metadata") && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "failed to fetch metadata") {
+		t.Fatalf("expected metadata failure to be logged, got:\n%s", out)
+	}
+	if strings.Contains(out, "supersecret") {
+		t.Fatalf("secret query value leaked into logs:\n%s", out)
+	}
+}
+
+func TestIntegration_NestedFilesAttributedToJob(t *testing.T) {
+	cfg := &config.Config{
+		Apps: []config.AppConfig{{
+			ID:            "nested",
+			Command:       "sh",
+			Args:          []string{"-c", "mkdir -p a/b && echo hi > a/b/deep.txt && echo top > top.txt"},
+			FetchMetadata: new(bool),
+		}},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+
+	jobID := submitJob(t, ts, "nested", "http://example.com/nested")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	files, _ := store.ListJobFiles(db, jobID)
+	jobDir := filepath.Join(cfg.DownloadsDir, fmt.Sprint(jobID))
+	got := map[string]bool{}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, jobDir+string(os.PathSeparator)) {
+			t.Fatalf("file %s recorded outside the job dir %s", f.Path, jobDir)
+		}
+		got[strings.TrimPrefix(f.Path, jobDir)] = true
+	}
+	for _, want := range []string{"/top.txt", "/a/b/deep.txt"} {
+		if !got[want] {
+			t.Fatalf("expected %s to be attributed to job %d, got %v", want, jobID, got)
+		}
+	}
+}
+
+func TestNewManagerRequiresDownloadsDir(t *testing.T) {
+	if _, err := jobs.NewManager(nil, &config.Config{}); err == nil {
+		t.Fatal("expected an error when downloads_dir is empty")
+	}
+}
+
+func TestIntegration_DirAndFileModes(t *testing.T) {
+	cfg := &config.Config{
+		DirMode:  0o770,
+		FileMode: 0o660,
+		Apps: []config.AppConfig{{
+			ID:            "write",
+			Command:       "sh",
+			Args:          []string{"-c", "mkdir sub && echo hi > sub/out.txt"},
+			FetchMetadata: new(bool),
+		}},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+
+	jobID := submitJob(t, ts, "write", "http://example.com/modes")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	jobDir := filepath.Join(cfg.DownloadsDir, fmt.Sprint(jobID))
+	for path, want := range map[string]os.FileMode{
+		jobDir:                                  0o770,
+		filepath.Join(jobDir, "sub"):            0o770,
+		filepath.Join(jobDir, "sub", "out.txt"): 0o660,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s: expected mode %o, got %o", path, want, got)
+		}
+	}
+}
+
+func TestIntegration_FileEventStorm(t *testing.T) {
+	const dirs, perDir = 8, 50
+	script := fmt.Sprintf(`for d in $(seq 1 %d); do
+  ( mkdir -p "d$d" && for f in $(seq 1 %d); do echo "$d-$f" > "d$d/f$f.txt"; done ) &
+done
+wait`, dirs, perDir)
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:            "storm",
+			Command:       "sh",
+			Args:          []string{"-c", script},
+			FetchMetadata: new(bool),
+		}},
+	})
+
+	jobID := submitJob(t, ts, "storm", "http://example.com/storm")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s: %s", j.Status, j.Logs)
+	}
+
+	files, err := store.ListJobFiles(db, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if seen[f.Path] {
+			t.Fatalf("duplicate record for %s", f.Path)
+		}
+		seen[f.Path] = true
+	}
+	if len(files) != dirs*perDir {
+		t.Fatalf("expected %d file records, got %d", dirs*perDir, len(files))
+	}
+}
+
+func TestIntegration_StateHelloAndResync(t *testing.T) {
+	ts, _, mgr := newTestServer(t, &config.Config{})
+	wsBase := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/state"
+
+	readHello := func(query string) map[string]any {
+		conn, _, err := websocket.DefaultDialer.Dial(wsBase+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var hello map[string]any
+		if err := conn.ReadJSON(&hello); err != nil {
+			t.Fatal(err)
+		}
+		if hello["type"] != "hello" {
+			t.Fatalf("expected hello first, got %v", hello)
+		}
+		return hello
+	}
+
+	hello := readHello("")
+	if hello["resync"] != nil {
+		t.Fatalf("fresh connection should not be asked to resync: %v", hello)
+	}
+	seq := uint64(hello["seq"].(float64))
+	boot := int64(hello["boot"].(float64))
+
+	// Nothing happened since: no resync needed.
+	if h := readHello(fmt.Sprintf("?last_seq=%d&boot=%d", seq, boot)); h["resync"] != nil {
+		t.Fatalf("up-to-date client asked to resync: %v", h)
+	}
+
+	// Events were broadcast while "disconnected".
+	mgr.BroadcastState(map[string]string{"type": "ping"})
+	if h := readHello(fmt.Sprintf("?last_seq=%d&boot=%d", seq, boot)); h["resync"] != true {
+		t.Fatalf("expected resync after missed events: %v", h)
+	}
+
+	// A different boot (server restarted) always resyncs.
+	if h := readHello(fmt.Sprintf("?last_seq=%d&boot=%d", mgr.StateSeq(), boot-1)); h["resync"] != true {
+		t.Fatalf("expected resync after restart: %v", h)
+	}
+}
+
+func TestIntegration_WebsocketCompression(t *testing.T) {
+	negotiated := func(cfg *config.Config) bool {
+		ts, _, _ := newTestServer(t, cfg)
+		dialer := websocket.Dialer{EnableCompression: true}
+		conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/state", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// The compressed stream must still decode.
+		var hello map[string]any
+		if err := conn.ReadJSON(&hello); err != nil || hello["type"] != "hello" {
+			t.Fatalf("failed to read hello: %v %v", hello, err)
+		}
+		return strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	}
+
+	if !negotiated(&config.Config{}) {
+		t.Fatal("expected permessage-deflate to be negotiated by default")
+	}
+	if negotiated(&config.Config{WSCompression: new(bool)}) {
+		t.Fatal("expected no compression when ws_compression is false")
+	}
+}
+
+func TestIntegration_CloneJob(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "echo", Command: "sh", Args: []string{"-c", "echo hi > out.txt"}, DeniedHosts: []string{"blocked.example"}}},
+	})
+
+	orig := submitJob(t, ts, "echo", "http://example.com/video")
+	if j := waitForJob(t, db, orig); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/api/jobs/%d/clone", ts.URL, orig), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var out struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID == 0 || out.ID == orig {
+		t.Fatalf("expected a new job id, got %d (original %d)", out.ID, orig)
+	}
+
+	clone := waitForJob(t, db, out.ID)
+	if clone.Status != store.StatusSuccess || clone.AppID != "echo" || clone.OriginalURL != "http://example.com/video" {
+		t.Fatalf("unexpected clone: %+v", clone)
+	}
+	if files, _ := store.ListJobFiles(db, orig); len(files) == 0 {
+		t.Fatal("original job lost its files")
+	}
+
+	resp, err = http.Post(ts.URL+"/api/jobs/9999/clone", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing job, got %d", resp.StatusCode)
+	}
+
+	// A job from before its host was denied can't be cloned past the policy.
+	blocked, _ := store.InsertJob(db, "echo", "Echo", "http://blocked.example/video", time.Now())
+	resp, err = http.Post(fmt.Sprintf("%s/api/jobs/%d/clone", ts.URL, blocked), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a denied host, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_QueueStatus(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "slow", Command: "sh", Args: []string{"-c", "sleep 0.5"}}},
+	})
+
+	getStatus := func() jobs.QueueStatus {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/api/queue")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var st jobs.QueueStatus
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	id := submitJob(t, ts, "slow", "http://example.com/")
+	submitJob(t, ts, "slow", "http://example.com/2")
+	time.Sleep(100 * time.Millisecond)
+	if st := getStatus(); st.Idle || st.Running != 1 || st.Queued != 1 {
+		t.Fatalf("expected one running and one queued, got %+v", st)
+	}
+	waitForJob(t, db, id)
+	waitForJob(t, db, id+1)
+	if st := getStatus(); !st.Idle || st.Running != 0 || st.Queued != 0 {
+		t.Fatalf("expected idle, got %+v", st)
+	}
+}
+
+func TestIntegration_PauseResume(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		AdminToken: "s3cret",
+		Apps:       []config.AppConfig{{ID: "slow", Command: "sh", Args: []string{"-c", "sleep 0.3; echo ok > out.txt"}}},
+	})
+	admin := func(action string) jobs.QueueStatus {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/"+action, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", action, resp.StatusCode)
+		}
+		var st jobs.QueueStatus
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	first := submitJob(t, ts, "slow", "http://example.com/1")
+	time.Sleep(100 * time.Millisecond)
+	if st := admin("pause"); !st.Paused {
+		t.Fatalf("expected paused status, got %+v", st)
+	}
+	second := submitJob(t, ts, "slow", "http://example.com/2")
+
+	// The in-flight job finishes normally...
+	if j := waitForJob(t, db, first); j.Status != store.StatusSuccess {
+		t.Fatalf("expected running job to finish, got %s", j.Status)
+	}
+	// ...but the queued one doesn't start.
+	time.Sleep(300 * time.Millisecond)
+	if j, _ := store.GetJob(db, second); j.Status != store.StatusQueued {
+		t.Fatalf("expected job to stay queued while paused, got %s", j.Status)
+	}
+	if st := admin("pause"); st.Queued != 1 || st.Running != 0 || st.Idle {
+		t.Fatalf("expected one queued job while paused, got %+v", st)
+	}
+
+	if st := admin("resume"); st.Paused {
+		t.Fatalf("expected resumed status, got %+v", st)
+	}
+	if j := waitForJob(t, db, second); j.Status != store.StatusSuccess {
+		t.Fatalf("expected job to run after resume, got %s", j.Status)
+	}
+}
+
+func TestIntegration_AppHostPolicy(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:           "yt",
+			Command:      "true",
+			AllowedHosts: []string{"*.youtube.com"},
+			DeniedHosts:  []string{"music.youtube.com"},
+		}},
+	})
+
+	post := func(urls string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {"yt"}, "urls": {urls}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := post("https://example.com/watch")
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "not in allowed_hosts") {
+		t.Fatalf("expected allowlist rejection, got %d: %s", resp.StatusCode, body)
+	}
+	resp, body = post("https://music.youtube.com/watch")
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "denied") {
+		t.Fatalf("expected denylist rejection, got %d: %s", resp.StatusCode, body)
+	}
+
+	resp, body = post("https://www.youtube.com/watch?v=1\nhttps://example.com/other")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var result struct {
+		IDs    []int64  `json:"ids"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.IDs) != 1 || len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "https://example.com/other") {
+		t.Fatalf("expected one job and one per-URL error, got %s", body)
+	}
+	if j, err := store.GetJob(db, result.IDs[0]); err != nil || j.URL != "https://www.youtube.com/watch?v=1" {
+		t.Fatalf("unexpected job: %+v, %v", j, err)
+	}
+}
+
+func TestIntegration_JobRouteTrailingSlashes(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "echo", Command: "sh", Args: []string{"-c", "echo hi > out.txt"}}},
+	})
+	id := submitJob(t, ts, "echo", "http://example.com/")
+	waitForJob(t, db, id)
+
+	do := func(method, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := do("GET", fmt.Sprintf("/api/jobs/%d/", id)); code != 200 || !strings.Contains(body, `"id":`) {
+		t.Fatalf("GET with trailing slash: %d %s", code, body)
+	}
+	if code, body := do("GET", "/api/jobs/"); code != 200 || !strings.HasPrefix(body, "[") {
+		t.Fatalf("GET /api/jobs/: %d %s", code, body)
+	}
+	if code, body := do("POST", fmt.Sprintf("/api/jobs/%d/retry/", id)); code != http.StatusNoContent {
+		t.Fatalf("POST retry with trailing slash: %d %s", code, body)
+	}
+	waitForJob(t, db, id)
+
+	for _, path := range []string{
+		fmt.Sprintf("/api/jobs/%d//retry", id),
+		fmt.Sprintf("/api/jobs//%d", id),
+		fmt.Sprintf("/api/jobs/%d/retry/extra", id),
+	} {
+		if code, body := do("POST", path); code != 400 || !strings.Contains(body, "malformed path") {
+			t.Errorf("POST %s: expected 400 malformed path, got %d %s", path, code, body)
+		}
+	}
+}
+
+func TestIntegration_JobRoutes(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "echo", Command: "sh", Args: []string{"-c", "echo hi > out.txt"}}},
+	})
+	id := submitJob(t, ts, "echo", "http://example.com/")
+	waitForJob(t, db, id)
+	files, err := store.ListJobFiles(db, id)
+	if err != nil || len(files) == 0 {
+		t.Fatalf("expected job files, got %v (%v)", files, err)
+	}
+
+	job := fmt.Sprintf("/api/jobs/%d", id)
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/jobs", 200},
+		{"PUT", "/api/jobs", 405},
+		{"GET", job, 200},
+		{"GET", "/api/jobs/abc", 400},
+		{"GET", "/api/jobs/9999", 404},
+		{"DELETE", job, 405},
+		{"GET", job + "/zip", 200},
+		{"GET", job + "/logs", 200},
+		{"GET", fmt.Sprintf("%s/files/%d", job, files[0].ID), 200},
+		{"GET", job + "/files/abc", 400},
+		{"GET", job + "/files/9999", 404},
+		{"DELETE", fmt.Sprintf("%s/files/%d", job, files[0].ID), 405},
+		{"GET", job + "/files/1/extra", 400},
+		{"GET", job + "/bogus/extra", 400},
+		{"GET", job + "/retry", 405},
+		{"POST", job + "/cancel", 400}, // not running
+		{"POST", "/api/jobs/9999/refetch", 404},
+		{"POST", "/api/jobs/9999/clone", 404},
+		{"POST", job + "/bogus", 404},
+		{"POST", job + "/archive", 204},
+		{"DELETE", job + "/files", 204},
+		{"POST", job + "/cleanup", 204},
+		{"GET", "/api/queue", 200},
+		{"POST", "/api/queue", 405},
+		{"POST", "/api/admin/vacuum", 403}, // no admin_token configured
+		{"GET", "/", 200},
+		{"GET", "/nope", 404},
+		{"GET", fmt.Sprintf("/job/%d", id), 200},
+	}
+	for _, tc := range tests {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestIntegration_JSONErrors(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "echo", Command: "true"}},
+	})
+
+	resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {"nope"}, "urls": {"http://example.com/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON content type, got %q", ct)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error body is not JSON: %v", err)
+	}
+	if !strings.Contains(body.Error, `unknown app_id="nope"`) {
+		t.Fatalf("unexpected error message: %q", body.Error)
+	}
+
+	for path, want := range map[string]int{"/thumbnails/abc.png": 400, "/thumbnails/9999.png": 404} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want || resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("GET %s: expected a JSON %d, got %d %q", path, want, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestIntegration_AllowHeaderAndOptions(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+
+	do := func(method, path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("DELETE", "/api/jobs")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", resp.StatusCode)
+	}
+	if allow := resp.Header.Get("Allow"); !strings.Contains(allow, "GET") || !strings.Contains(allow, "POST") || strings.Contains(allow, "DELETE") {
+		t.Fatalf("unexpected Allow header on 405: %q", allow)
+	}
+
+	resp = do("OPTIONS", "/api/jobs")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 for OPTIONS, got %d", resp.StatusCode)
+	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, HEAD, POST, OPTIONS" {
+		t.Fatalf("unexpected Allow header for OPTIONS: %q", allow)
+	}
+	if allow := do("OPTIONS", "/api/jobs/1/files").Header.Get("Allow"); allow != "DELETE, OPTIONS" {
+		t.Fatalf("unexpected Allow header for OPTIONS on files: %q", allow)
+	}
+	if resp := do("OPTIONS", "/api/nope"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for OPTIONS on unknown path, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_NotFoundPages(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+
+	get := func(path string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	for _, path := range []string{"/nope", "/static/js/missing.js", "/static/", "/static/js"} {
+		resp, body := get(path)
+		if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "Nothing here") {
+			t.Errorf("GET %s: expected branded 404, got %d: %.80s", path, resp.StatusCode, body)
+		}
+	}
+
+	resp, body := get("/api/nope")
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Content-Type") != "application/json" || !strings.Contains(body, `"error"`) {
+		t.Errorf("GET /api/nope: expected JSON 404, got %d %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+
+	// Embedded assets resolve from the embed root, including the static/ prefix.
+	if resp, _ := get("/static/css/bundle.css"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected embedded stylesheet to be served, got %d", resp.StatusCode)
+	}
+	// SPA deep links fall back to the index.
+	if resp, body := get("/job/42/logs"); resp.StatusCode != http.StatusOK || !strings.Contains(body, `id="app"`) {
+		t.Errorf("expected index for deep link, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_GzipResponses(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{})
+	for i := 0; i < 50; i++ {
+		store.InsertJob(db, "test", "Test", fmt.Sprintf("http://example.com/%d", i), time.Now())
+	}
+
+	get := func(path, encoding string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		// Setting the header ourselves stops the transport from transparently
+		// decompressing, so we see what went over the wire.
+		req.Header.Set("Accept-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get("/api/jobs", "gzip, deflate")
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzipped jobs list, got Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []store.Job
+	if err := json.NewDecoder(zr).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 50 {
+		t.Fatalf("expected 50 jobs, got %d", len(list))
+	}
+
+	if resp := get("/api/jobs", "identity"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("compressed a response for a client that didn't ask for it")
+	}
+	if resp := get("/api/queue", "gzip"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("compressed a tiny response")
+	}
+}
+
+func TestIntegration_StaticETag(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+
+	resp, err := http.Get(ts.URL + "/static/css/bundle.css?v=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", resp.StatusCode, etag)
+	}
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "max-age=31536000") {
+		t.Fatalf("expected long max-age for versioned asset, got %q", cc)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/static/css/bundle.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching If-None-Match, got %d", resp.StatusCode)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Fatalf("expected unversioned asset to revalidate, got %q", cc)
+	}
+}
+
+func TestIntegration_MetadataConcurrencyLimit(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{
+		MetadataConcurrency: 2,
+		Apps:                []config.AppConfig{{ID: "noop", Command: "true"}},
+	})
+
+	var inFlight, peak, served atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		fmt.Fprint(w, "<html><head><title>Page</title></head></html>")
+		served.Add(1)
+	}))
+	defer page.Close()
+
+	const total = 20
+	var urls []string
+	for i := 0; i < total; i++ {
+		urls = append(urls, fmt.Sprintf("%s/page?n=%d", page.URL, i))
+	}
+	resp, err := http.PostForm(ts.URL+"/api/jobs", url.Values{"app_id": {"noop"}, "urls": {strings.Join(urls, "\n")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("submit failed with status %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for served.Load() < total {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d metadata fetches ran", served.Load(), total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Fatalf("expected at most 2 concurrent metadata fetches, saw %d", p)
+	}
+}
+
+func TestIntegration_RawJobFiles(t *testing.T) {
+	cfg := &config.Config{AdminToken: "s3cret"}
+	ts, db, _ := newTestServer(t, cfg)
+	jobID, _ := store.InsertJob(db, "test", "Test", "http://example.com/", time.Now())
+	inside := filepath.Join(cfg.DownloadsDir, fmt.Sprint(jobID), "video.mp4")
+	outside := filepath.Join(cfg.DownloadsDir, "elsewhere.mp4")
+	for _, p := range []string{inside, outside} {
+		if err := store.InsertJobFile(db, jobID, p, 42, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/jobs/%d/files/raw", ts.URL, jobID), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := get(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", resp.StatusCode)
+	}
+
+	resp := get("s3cret")
+	defer resp.Body.Close()
+	var out struct {
+		Files []struct {
+			Path string `json:"path"`
+			Safe bool   `json:"safe"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	safe := map[string]bool{}
+	for _, f := range out.Files {
+		safe[f.Path] = f.Safe
+	}
+	if len(out.Files) != 2 || !safe[inside] || safe[outside] {
+		t.Fatalf("expected %s safe and %s flagged, got %+v", inside, outside, out.Files)
+	}
+
+	// The regular snapshot still hides the out-of-bounds row.
+	snap, err := http.Get(fmt.Sprintf("%s/api/jobs/%d", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Body.Close()
+	var j store.Job
+	json.NewDecoder(snap.Body).Decode(&j)
+	if len(j.Files) != 1 {
+		t.Fatalf("expected snapshot to list only the in-bounds file, got %+v", j.Files)
+	}
+}
+
+func TestIntegration_MissingFilesOnDisk(t *testing.T) {
+	cfg := &config.Config{}
+	ts, db, _ := newTestServer(t, cfg)
+	jobID, _ := store.InsertJob(db, "test", "Test", "http://example.com/", time.Now())
+	jobDir := filepath.Join(cfg.DownloadsDir, fmt.Sprint(jobID))
+	os.MkdirAll(jobDir, 0o755)
+	for _, name := range []string{"keep.txt", "gone.txt", "later.txt"} {
+		p := filepath.Join(jobDir, name)
+		os.WriteFile(p, []byte(name), 0o644)
+		store.InsertJobFile(db, jobID, p, int64(len(name)), time.Now())
+	}
+	fileID := func(name string) int64 {
+		files, _ := store.ListJobFiles(db, jobID)
+		for _, f := range files {
+			if filepath.Base(f.Path) == name {
+				return f.ID
+			}
+		}
+		return 0
+	}
+	gone := fileID("gone.txt")
+	os.Remove(filepath.Join(jobDir, "gone.txt"))
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/files/%d", ts.URL, jobID, gone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 for a file deleted on disk, got %d", resp.StatusCode)
+	}
+	if fileID("gone.txt") != 0 {
+		t.Fatal("expected the stale row to be pruned")
+	}
+
+	// The zip skips missing files and says so.
+	os.Remove(filepath.Join(jobDir, "later.txt"))
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/%d/zip", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Missing-Files") != "1" {
+		t.Fatalf("expected 200 with X-Missing-Files: 1, got %d %q", resp.StatusCode, resp.Header.Get("X-Missing-Files"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "keep.txt,MISSING.txt" {
+		t.Fatalf("unexpected zip entries %v", names)
+	}
+
+	os.Remove(filepath.Join(jobDir, "keep.txt"))
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/%d/zip", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 once every file is gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_DuplicateFiles(t *testing.T) {
+	noFetch := false
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:            "scraper",
+			Command:       "sh",
+			Args:          []string{"-c", "echo same > a.png; echo same > b.png; echo other > c.png"},
+			FetchMetadata: &noFetch,
+		}},
+	})
+	jobID := submitJob(t, ts, "scraper", "http://example.com/")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	hashes := map[string]string{}
+	files, _ := store.ListJobFiles(db, jobID)
+	for _, f := range files {
+		hashes[filepath.Base(f.Path)] = f.SHA256
+	}
+	if hashes["a.png"] == "" || hashes["a.png"] != hashes["b.png"] || hashes["a.png"] == hashes["c.png"] {
+		t.Fatalf("expected a.png and b.png to share a hash distinct from c.png, got %v", hashes)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/dupes", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Groups []struct {
+			SHA256 string   `json:"sha256"`
+			Files  []string `json:"files"`
+		} `json:"groups"`
+		WastedBytes int64 `json:"wasted_bytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Groups) != 1 || out.Groups[0].SHA256 != hashes["a.png"] || len(out.Groups[0].Files) != 2 || out.WastedBytes != int64(len("same\n")) {
+		t.Fatalf("unexpected dupes report: %+v", out)
+	}
+}
+
+func TestIntegration_Checksums(t *testing.T) {
+	noFetch := false
+	cfg := &config.Config{
+		Apps: []config.AppConfig{{
+			ID:            "multi",
+			Command:       "sh",
+			Args:          []string{"-c", "mkdir sub; echo one > one.txt; echo two > sub/two.txt"},
+			FetchMetadata: &noFetch,
+		}},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+	jobID := submitJob(t, ts, "multi", "http://example.com/")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/checksums", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	want := map[string]string{"one.txt": "one\n", "sub/two.txt": "two\n"}
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %q", len(want), body)
+	}
+	for _, line := range lines {
+		sum, name, ok := strings.Cut(line, "  ")
+		content, known := want[name]
+		if !ok || !known {
+			t.Fatalf("unexpected line %q", line)
+		}
+		if expected := fmt.Sprintf("%x", sha256.Sum256([]byte(content))); sum != expected {
+			t.Fatalf("%s: got %s, want %s", name, sum, expected)
+		}
+	}
+
+	if _, err := exec.LookPath("sha256sum"); err == nil {
+		check := exec.Command("sha256sum", "-c", "-")
+		check.Dir = filepath.Join(cfg.DownloadsDir, fmt.Sprint(jobID))
+		check.Stdin = bytes.NewReader(body)
+		if out, err := check.CombinedOutput(); err != nil {
+			t.Fatalf("sha256sum -c rejected the output: %v\n%s", err, out)
+		}
+	}
+}
+
+func TestIntegration_DiskUsage(t *testing.T) {
+	cfg := &config.Config{}
+	ts, _, _ := newTestServer(t, cfg)
+	sizes := map[string]int{"1/a.bin": 1000, "1/sub/b.bin": 2500, "thumbnails/1.png": 300}
+	var want int64
+	for name, n := range sizes {
+		p := filepath.Join(cfg.DownloadsDir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, bytes.Repeat([]byte("x"), n), 0o644)
+		want += int64(n)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		UsedBytes      int64   `json:"used_bytes"`
+		TotalBytes     *uint64 `json:"total_bytes"`
+		AvailableBytes *uint64 `json:"available_bytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	// The test database lives outside downloads_dir, so only our files count;
+	// allow a little slack for anything the manager writes there on its own.
+	if out.UsedBytes < want || out.UsedBytes > want+4096 {
+		t.Fatalf("expected used_bytes close to %d, got %d", want, out.UsedBytes)
+	}
+	if out.TotalBytes == nil || out.AvailableBytes == nil || *out.AvailableBytes > *out.TotalBytes {
+		t.Fatalf("expected filesystem figures from statfs, got total=%v available=%v", out.TotalBytes, out.AvailableBytes)
+	}
+}
+
+func TestIntegration_TerminalRows(t *testing.T) {
+	noFetch := false
+	ts, db, _ := newTestServer(t, &config.Config{
+		TerminalRows:    10,
+		ScrollbackLines: 50,
+		Apps: []config.AppConfig{{
+			ID:            "sized",
+			Command:       "sh",
+			Args:          []string{"-c", `stty size; i=0; while [ $i -lt 30 ]; do echo "line $i"; i=$((i+1)); done; echo x > out.txt`},
+			FetchMetadata: &noFetch,
+		}},
+	})
+	jobID := submitJob(t, ts, "sized", "http://example.com/")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/logs?format=ansi", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	// The command sees the configured screen, and output that scrolled past it
+	// is kept as scrollback.
+	if !strings.Contains(string(body), "\n10 100\n") || !strings.Contains(string(body), "\nline 0\n") {
+		t.Fatalf("unexpected log: %q", body)
+	}
+}
+
+func TestIntegration_JobLogLines(t *testing.T) {
+	noFetch := false
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:            "chatty",
+			Command:       "sh",
+			Args:          []string{"-c", `printf 'hello\n\033[32mgreen\033[0m\n'; echo x > out.txt`},
+			FetchMetadata: &noFetch,
+		}},
+	})
+	jobID := submitJob(t, ts, "chatty", "http://example.com/")
+	if j := waitForJob(t, db, jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected success, got %s", j.Status)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/logs?format=lines", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		JobID int64          `json:"job_id"`
+		Lines map[int]string `json:"lines"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	// Line 0 is the "$ command" header and line 1 is blank, then the output.
+	if _, blank := out.Lines[1]; out.JobID != jobID || !strings.Contains(out.Lines[0], "$ sh -c") || blank ||
+		!strings.Contains(out.Lines[2], ">hello<") || !strings.Contains(out.Lines[3], "green") {
+		t.Fatalf("unexpected line map: %+v", out)
+	}
+	for idx, html := range out.Lines {
+		if !strings.HasPrefix(html, fmt.Sprintf(`<div data-line="%d">`, idx)) {
+			t.Fatalf("line %d is not in delta form: %q", idx, html)
+		}
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/%d/logs?format=ansi", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content type for ansi: %q", ct)
+	}
+	if !strings.Contains(string(body), "\nhello\n\x1b[0;32mgreen\x1b[0m\n") || strings.Contains(string(body), "<") {
+		t.Fatalf("expected colored text without markup: %q", body)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/%d/logs?format=nope", ts.URL, jobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_AppPreview(t *testing.T) {
+	noFetch := false
+	cfg := &config.Config{
+		AdminToken: "s3cret",
+		Apps: []config.AppConfig{{
+			ID:                 "probe",
+			Command:            "sh",
+			Args:               []string{"-c", `echo "$2" > "$1/out.txt"`, "sh", "%o", "%u"},
+			StripTrailingSlash: true,
+			FetchMetadata:      &noFetch,
+			AllowedHosts:       []string{"example.com"},
+		}},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+
+	preview := func(appID, u string) (*http.Response, appPreview) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/apps/"+appID+"/preview?url="+url.QueryEscape(u), nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var p appPreview
+		_ = json.NewDecoder(resp.Body).Decode(&p)
+		return resp, p
+	}
+
+	resp, p := preview("probe", "http://example.com/page/")
+	if resp.StatusCode != http.StatusOK || len(p.Warnings) != 0 {
+		t.Fatalf("unexpected preview: %d %+v", resp.StatusCode, p)
+	}
+	if p.Args[3] != p.JobDir || p.Args[4] != "http://example.com/page" {
+		t.Fatalf("expected %%o and the slash-stripped %%u to be substituted: %q", p.Args)
+	}
+
+	// The preview is what the worker then runs.
+	jobID := submitJob(t, ts, "probe", "http://example.com/page/")
+	j := waitForJob(t, db, jobID)
+	args := make([]string, len(p.Args))
+	for i, a := range p.Args {
+		args[i] = strings.ReplaceAll(a, "<id>", fmt.Sprint(jobID))
+	}
+	if want := jobs.ShellJoin(append([]string{p.Command}, args...)); j.CommandLine != want {
+		t.Fatalf("executed %q, preview said %q", j.CommandLine, want)
+	}
+
+	if _, p := preview("probe", "http://other.example/"); len(p.Warnings) != 1 || !strings.Contains(p.Warnings[0], "allowed_hosts") {
+		t.Fatalf("expected a warning for a host the app rejects: %+v", p)
+	}
+	if resp, _ := preview("probe", "not a url"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad url, got %d", resp.StatusCode)
+	}
+	if resp, _ := preview("nope", "http://example.com/"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown app, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_WaitForJob(t *testing.T) {
+	ts, _, mgr := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "quick", Command: "sh", Args: []string{"-c", "sleep 0.5; echo done > out.txt"}, FetchMetadata: new(bool)},
+			{ID: "slow", Command: "sleep", Args: []string{"10"}, FetchMetadata: new(bool)},
+		},
+	})
+
+	wait := func(id int64, query string) (int, store.Job, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/wait%s", ts.URL, id, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var j store.Job
+		json.NewDecoder(resp.Body).Decode(&j)
+		return resp.StatusCode, j, time.Since(start)
+	}
+
+	// The job finishes while the request waits.
+	id := submitJob(t, ts, "quick", "http://example.com/quick")
+	code, j, took := wait(id, "?timeout=10s")
+	if code != http.StatusOK || j.Status != store.StatusSuccess || len(j.Files) != 1 {
+		t.Fatalf("expected the finished job with its file, got %d: %s %+v", code, j.Status, j.Files)
+	}
+	if took > 3*time.Second {
+		t.Fatalf("wait returned %s after the job finished", took)
+	}
+	// Already finished: no waiting at all.
+	if code, _, took := wait(id, ""); code != http.StatusOK || took > time.Second {
+		t.Fatalf("expected an immediate 200 for a finished job, got %d after %s", code, took)
+	}
+
+	slow := submitJob(t, ts, "slow", "http://example.com/slow")
+	t.Cleanup(func() { _ = mgr.CancelJob(slow) })
+	code, j, took = wait(slow, "?timeout=300ms")
+	if code != http.StatusGatewayTimeout || j.Status.Finished() || took < 300*time.Millisecond {
+		t.Fatalf("expected 504 with the unfinished job after the timeout, got %d: %s after %s", code, j.Status, took)
+	}
+
+	if code, _, _ := wait(slow, "?timeout=soon"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad timeout, got %d", code)
+	}
+	if code, _, _ := wait(9999, "?timeout=1"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing job, got %d", code)
+	}
+}
+
+func TestIntegration_CancelWithCleanup(t *testing.T) {
+	cfg := &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "partial", Command: "sh", Args: []string{"-c", "echo part > video.part; sleep 10"}, FetchMetadata: new(bool)},
+		},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+
+	cancel := func(id int64, query string) {
+		t.Helper()
+		resp, err := http.Post(fmt.Sprintf("%s/api/jobs/%d/cancel%s", ts.URL, id, query), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("cancel%s: expected 204, got %d", query, resp.StatusCode)
+		}
+	}
+	started := func(id int64) string {
+		t.Helper()
+		jobDir := filepath.Join(cfg.DownloadsDir, fmt.Sprint(id))
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if n, _ := store.CountJobFiles(db, id); n == 1 {
+				return jobDir
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d never recorded its partial file", id)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// By default the partial output is kept.
+	kept := submitJob(t, ts, "partial", "http://example.com/kept")
+	keptDir := started(kept)
+	cancel(kept, "")
+	waitForJob(t, db, kept)
+	if _, err := os.Stat(filepath.Join(keptDir, "video.part")); err != nil {
+		t.Fatalf("expected the partial file to be kept: %v", err)
+	}
+
+	cleaned := submitJob(t, ts, "partial", "http://example.com/cleaned")
+	cleanedDir := started(cleaned)
+	cancel(cleaned, "?cleanup=true")
+	// The response waits for the cleanup, so no polling here.
+	j, err := store.GetJob(db, cleaned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != store.StatusCancelled {
+		t.Fatalf("expected the job to stay cancelled, got %s", j.Status)
+	}
+	if _, err := os.Stat(cleanedDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the job directory to be removed, got %v", err)
+	}
+	if n, _ := store.CountJobFiles(db, cleaned); n != 0 {
+		t.Fatalf("expected no recorded files after cleanup, got %d", n)
+	}
+}
+
+func TestIntegration_HeadDownloads(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "one", Command: "sh", Args: []string{"-c", "echo hello > clip.mp4"}, FetchMetadata: new(bool)},
+		},
+	})
+	id := submitJob(t, ts, "one", "http://example.com/clip")
+	waitForJob(t, db, id)
+	files, _ := store.ListJobFiles(db, id)
+	if len(files) != 1 {
+		t.Fatalf("expected one file, got %d", len(files))
+	}
+
+	head := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Head(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || len(body) != 0 {
+			t.Fatalf("HEAD %s: expected 200 without a body, got %d with %d bytes", path, resp.StatusCode, len(body))
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Disposition"), "attachment;") {
+			t.Fatalf("HEAD %s: expected an attachment, got %q", path, resp.Header.Get("Content-Disposition"))
+		}
+		return resp
+	}
+
+	resp := head(fmt.Sprintf("/api/jobs/%d/files/%d", id, files[0].ID))
+	if resp.ContentLength != files[0].SizeBytes || resp.Header.Get("Content-Type") != "video/mp4" {
+		t.Fatalf("expected the file's length and type, got %d %q", resp.ContentLength, resp.Header.Get("Content-Type"))
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "clip.mp4") {
+		t.Fatalf("expected the file name, got %q", cd)
+	}
+	if ct := head(fmt.Sprintf("/api/jobs/%d/zip", id)).Header.Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("expected application/zip, got %q", ct)
+	}
+	if ct := head(fmt.Sprintf("/api/jobs/zip?ids=%d", id)).Header.Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("expected application/zip for a bulk zip, got %q", ct)
+	}
+	if ct := head(fmt.Sprintf("/api/jobs/tar.gz?ids=%d", id)).Header.Get("Content-Type"); ct != "application/gzip" {
+		t.Fatalf("expected application/gzip, got %q", ct)
+	}
+
+	// HEAD reports files removed from disk but leaves their rows for GET to prune.
+	gone := filepath.Join(filepath.Dir(files[0].Path), "gone.mp4")
+	if err := store.InsertJobFile(db, id, gone, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{fmt.Sprintf("/api/jobs/%d/zip", id), fmt.Sprintf("/api/jobs/zip?ids=%d", id)} {
+		if n := head(path).Header.Get("X-Missing-Files"); n != "1" {
+			t.Fatalf("HEAD %s: expected one missing file, got %q", path, n)
+		}
+	}
+	if n, _ := store.CountJobFiles(db, id); n != 2 {
+		t.Fatalf("expected HEAD to keep the missing file's row, got %d rows", n)
+	}
+}
+
+func TestIntegration_SymlinkEscape(t *testing.T) {
+	cfg := &config.Config{
+		Apps: []config.AppConfig{
+			// The command links to a file outside its job dir, next to a real file.
+			{ID: "link", Command: "sh", Args: []string{"-c", "echo ok > real.txt; ln -s ../../secret.txt leak.txt"}, FetchMetadata: new(bool)},
+		},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+	secret := filepath.Join(filepath.Dir(cfg.DownloadsDir), "secret.txt")
+	if err := os.WriteFile(secret, []byte("sensitive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	id := submitJob(t, ts, "link", "http://example.com/link")
+	waitForJob(t, db, id)
+
+	files, _ := store.ListJobFiles(db, id)
+	var leak store.JobFile
+	for _, f := range files {
+		if filepath.Base(f.Path) == "leak.txt" {
+			leak = f
+		}
+	}
+	if leak.ID == 0 {
+		t.Fatalf("expected the symlink to be recorded, got %+v", files)
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/files/%d", ts.URL, id, leak.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || strings.Contains(string(body), "sensitive") {
+		t.Fatalf("expected 400 for a symlink out of the job dir, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Archives leave it out too.
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/%d/zip", ts.URL, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "real.txt" {
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		t.Fatalf("expected only real.txt in the zip, got %v", names)
+	}
+
+	// And so do checksums, which would otherwise hash the file it points to.
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/%d/checksums", ts.URL, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], "  real.txt") {
+		t.Fatalf("expected only real.txt in the checksums, got %q", body)
+	}
+}
+
+func TestIntegration_RetryFailed(t *testing.T) {
+	cfg := &config.Config{
+		Apps: []config.AppConfig{
+			// Fails until the "network" (a file next to downloads_dir) is back.
+			{ID: "net", Command: "sh", Args: []string{"-c", "test -e ../../online && echo ok > out.txt"}, FetchMetadata: new(bool)},
+			{ID: "other", Command: "false", FetchMetadata: new(bool)},
+		},
+	}
+	ts, db, _ := newTestServer(t, cfg)
+	var failed []int64
+	for _, u := range []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"} {
+		failed = append(failed, submitJob(t, ts, "net", u))
+	}
+	other := submitJob(t, ts, "other", "http://example.com/other")
+	for _, id := range append(failed, other) {
+		if j := waitForJob(t, db, id); j.Status != store.StatusFailed {
+			t.Fatalf("expected job %d to fail, got %s", id, j.Status)
+		}
+	}
+
+	retry := func(query string) (int, []int64) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/api/jobs/retry-failed"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res struct{ IDs []int64 }
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res.IDs
+	}
+	if code, _ := retry("?app_id=nope"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown app, got %d", code)
+	}
+
+	if err := os.WriteFile(filepath.Join(filepath.Dir(cfg.DownloadsDir), "online"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, ids := retry("?app_id=net&since=1h")
+	if code != http.StatusOK || !slices.Equal(ids, failed) {
+		t.Fatalf("expected %v to be retried, got %d: %v", failed, code, ids)
+	}
+	for _, id := range failed {
+		if j := waitForJob(t, db, id); j.Status != store.StatusSuccess {
+			t.Fatalf("expected retried job %d to succeed, got %s", id, j.Status)
+		}
+	}
+	if j, _ := store.GetJob(db, other); j.Status != store.StatusFailed {
+		t.Fatalf("expected the other app's job to be left alone, got %s", j.Status)
+	}
+}
+
+func TestIntegration_Activity(t *testing.T) {
+	cfg := &config.Config{}
+	ts, db, _ := newTestServer(t, cfg)
+
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+	a, _ := store.InsertJob(db, "app", "A", "http://example.com/a", at(0))
+	store.UpdateJobStatusRunning(db, a, at(1))
+	store.InsertJobFile(db, a, filepath.Join(cfg.DownloadsDir, fmt.Sprint(a), "a.mp4"), 42, at(2))
+	store.MarkJobSuccess(db, a, at(3), "")
+	b, _ := store.InsertJob(db, "app", "B", "http://example.com/b", at(4))
+	store.UpdateJobStatusRunning(db, b, at(5))
+	store.MarkJobFailed(db, b, at(6), store.FailureExitNonzero, "exit status 1", "")
+
+	type event struct {
+		Type  string
+		At    time.Time
+		JobID int64 `json:"job_id"`
+		Path  string
+	}
+	feed := func(query string) ([]event, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/api/activity" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /api/activity%s: %d", query, resp.StatusCode)
+		}
+		var res struct {
+			Events     []event
+			NextCursor string `json:"next_cursor"`
+		}
+		json.NewDecoder(resp.Body).Decode(&res)
+		return res.Events, res.NextCursor
+	}
+	describe := func(events []event) []string {
+		var out []string
+		for _, e := range events {
+			out = append(out, fmt.Sprintf("%d:%s@%d", e.JobID, e.Type, int(e.At.Sub(t0).Minutes())))
+		}
+		return out
+	}
+
+	events, next := feed("")
+	want := []string{
+		fmt.Sprintf("%d:job_failed@6", b), fmt.Sprintf("%d:job_started@5", b), fmt.Sprintf("%d:job_submitted@4", b),
+		fmt.Sprintf("%d:job_succeeded@3", a), fmt.Sprintf("%d:file_added@2", a), fmt.Sprintf("%d:job_started@1", a), fmt.Sprintf("%d:job_submitted@0", a),
+	}
+	if got := describe(events); !slices.Equal(got, want) || next != "" {
+		t.Fatalf("expected %v, got %v (next_cursor %q)", want, got, next)
+	}
+	if events[4].Path != "/a.mp4" {
+		t.Fatalf("expected the file path relative to its job, got %q", events[4].Path)
+	}
+
+	// since is exclusive: the file added at minute 2 is left out.
+	events, _ = feed("?since=" + url.QueryEscape(at(2).Format(time.RFC3339)))
+	if got := describe(events); !slices.Equal(got, want[:4]) {
+		t.Fatalf("expected %v since minute 2, got %v", want[:4], got)
+	}
+	events, _ = feed(fmt.Sprintf("?since=%d", at(4).Unix()))
+	if got := describe(events); !slices.Equal(got, want[:2]) {
+		t.Fatalf("expected %v since minute 4 (unix), got %v", want[:2], got)
+	}
+
+	// Paging back through the history.
+	events, next = feed("?limit=3")
+	if got := describe(events); !slices.Equal(got, want[:3]) || next == "" {
+		t.Fatalf("expected the first page %v with next_cursor, got %v (%q)", want[:3], got, next)
+	}
+	events, _ = feed("?limit=3&cursor=" + url.QueryEscape(next))
+	if got := describe(events); !slices.Equal(got, want[3:6]) {
+		t.Fatalf("expected the second page %v, got %v", want[3:6], got)
+	}
+
+	// Events sharing a timestamp are neither lost nor repeated across pages.
+	c, _ := store.InsertJob(db, "app", "C", "http://example.com/c", at(10))
+	store.UpdateJobStatusRunning(db, c, at(10))
+	store.InsertJobFile(db, c, filepath.Join(cfg.DownloadsDir, fmt.Sprint(c), "c1.mp4"), 1, at(10))
+	store.InsertJobFile(db, c, filepath.Join(cfg.DownloadsDir, fmt.Sprint(c), "c2.mp4"), 1, at(10))
+	store.MarkJobSuccess(db, c, at(10), "")
+	d, _ := store.InsertJob(db, "app", "D", "http://example.com/d", at(10))
+	var paged []string
+	for query := "?limit=2"; ; {
+		events, next := feed(query)
+		paged = append(paged, describe(events)...)
+		if next == "" {
+			break
+		}
+		query = "?limit=2&cursor=" + url.QueryEscape(next)
+	}
+	tied := []string{
+		fmt.Sprintf("%d:job_succeeded@10", c), fmt.Sprintf("%d:file_added@10", c), fmt.Sprintf("%d:file_added@10", c),
+		fmt.Sprintf("%d:job_started@10", c), fmt.Sprintf("%d:job_submitted@10", d), fmt.Sprintf("%d:job_submitted@10", c),
+	}
+	if want := append(tied, want...); !slices.Equal(paged, want) {
+		t.Fatalf("expected %v paging two at a time, got %v", want, paged)
+	}
+	resp, _ := http.Get(ts.URL + "/api/activity?cursor=nope")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad cursor, got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/activity?since=yesterday")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad since, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_BulkArchive(t *testing.T) {
+	ts, db, _ := newTestServer(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "one", Command: "sh", Args: []string{"-c", "echo a > a.txt"}, FetchMetadata: new(bool)},
+			{ID: "two", Command: "sh", Args: []string{"-c", "mkdir sub && echo b > sub/b.txt"}, FetchMetadata: new(bool)},
+		},
+	})
+	first := submitJob(t, ts, "one", "http://example.com/first")
+	second := submitJob(t, ts, "two", "http://example.com/second")
+	waitForJob(t, db, first)
+	waitForJob(t, db, second)
+	store.SetJobCustomTitle(db, first, "Theme Part 1")
+	store.SetJobCustomTitle(db, second, "Theme Part 2")
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	want := "theme-part-1/a.txt theme-part-2/sub/b.txt"
+
+	resp := get(fmt.Sprintf("/api/jobs/zip?ids=%d,%d", first, second))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "low-tide-2-jobs-") || !strings.Contains(cd, ".zip") {
+		t.Fatalf("expected the archive to be named by job count, got %q", cd)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != want {
+		t.Fatalf("expected zip entries %q, got %q", want, names)
+	}
+
+	resp = get(fmt.Sprintf("/api/jobs/tar.gz?ids=%d,%d", first, second))
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("expected a gzip response, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	names = nil
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+	if strings.Join(names, " ") != want {
+		t.Fatalf("expected tar entries %q, got %q", want, names)
+	}
+
+	for path, code := range map[string]int{
+		"/api/jobs/zip":         http.StatusBadRequest,
+		"/api/jobs/zip?ids=1,x": http.StatusBadRequest,
+		fmt.Sprintf("/api/jobs/zip?ids=%d,9999", first): http.StatusNotFound,
+	} {
+		if resp := get(path); resp.StatusCode != code {
+			t.Errorf("GET %s: expected %d, got %d", path, code, resp.StatusCode)
+		}
+	}
+}
+
+func TestIntegration_WebAppManifest(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+
+	resp, err := http.Get(ts.URL + "/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/manifest+json" {
+		t.Fatalf("expected a manifest, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var manifest struct {
+		Name     string `json:"name"`
+		StartURL string `json:"start_url"`
+		Display  string `json:"display"`
+		Icons    []struct {
+			Src  string `json:"src"`
+			Type string `json:"type"`
+		} `json:"icons"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		t.Fatalf("manifest is not valid JSON: %v", err)
+	}
+	if manifest.Name == "" || manifest.StartURL != "/" || manifest.Display != "standalone" || len(manifest.Icons) == 0 {
+		t.Fatalf("incomplete manifest: %+v", manifest)
+	}
+
+	for path, ct := range map[string]string{
+		manifest.Icons[0].Src: manifest.Icons[0].Type,
+		"/sw.js":              "text/javascript; charset=utf-8",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ct {
+			t.Errorf("GET %s: expected 200 %q, got %d %q", path, ct, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `<link rel="manifest" href="/manifest.json">`) || !strings.Contains(string(body), "/sw.js") {
+		t.Fatal("expected the index to link the manifest and register the service worker")
+	}
+}
+
+func TestIntegration_Version(t *testing.T) {
+	ts, _, _ := newTestServer(t, &config.Config{})
+
+	get := func() map[string]string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/api/version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var v map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if v := get(); v["version"] != "dev" || v["go_version"] != runtime.Version() {
+		t.Fatalf("expected a dev build on %s, got %v", runtime.Version(), v)
+	}
+
+	// What -ldflags "-X main.version=... -X main.commit=..." would set.
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "v1.2.3", "abc1234", "2024-01-02T15:04:05Z"
+	v := get()
+	if v["version"] != "v1.2.3" || v["commit"] != "abc1234" || v["build_date"] != "2024-01-02T15:04:05Z" {
+		t.Fatalf("expected the build-time values, got %v", v)
+	}
+}
@@ -8,7 +8,7 @@ const (
 	CR        = byte(13)
 	NUL       = byte(0)
 	ESC       = byte(27)
-	TAB       = byte(96)
+	TAB       = byte(9)
 	BACKSPACE = byte(8)
 )
 
@@ -19,8 +19,11 @@ var (
 	NewLine = string([]byte{LF})
 
 	// Sequential ANSI Sequence Matcher
-	// This matches most common CSI (Control Sequence Introducer) sequences
-	Re_ANSI = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+	// This matches a whole CSI (Control Sequence Introducer) sequence: parameter
+	// bytes (digits, ";" and private markers like "?"), intermediate bytes, then
+	// the final byte. Matching the full grammar keeps sequences we don't handle,
+	// such as mode set/reset "\x1b[?2004h", from leaking into the output.
+	Re_ANSI = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]`)
 
 	// Terminal control sequences
 	ANSI_Reset = []byte("\x1b[0m")
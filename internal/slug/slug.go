@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+package slug
+
+import (
+	"path"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Parameterize creates a URL-safe version of the string, similar to Rails parameterize.
+// e.g. "This is my Happy String" -> "this-is-my-happy-string"
+// With transliterate, the result is reduced to ASCII ("Café" -> "cafe"); characters
+// without an ASCII equivalent (e.g. CJK) are dropped, falling back if nothing is left.
+func Parameterize(s string, fallback string, transliterate bool) string {
+	if transliterate {
+		s = toASCII(s)
+	}
+	f := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	parts := strings.FieldsFunc(strings.ToLower(s), f)
+	res := strings.Join(parts, "-")
+	if res == "" {
+		return fallback
+	}
+	return res
+}
+
+// reservedNames are device names Windows refuses as file names, with or without
+// an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Filename makes an ASCII file name that saves on Windows and macOS as well as
+// Linux: diacritics are stripped, characters those systems reject (and control
+// characters) become "_", leading/trailing dots and spaces are trimmed, and
+// device names like "CON" are prefixed. The extension is kept; the fallback is
+// used when nothing of the stem is left.
+// e.g. "Q&A: why?.mp4" -> "Q&A_ why_.mp4"
+func Filename(name string, fallback string) string {
+	// A leading dot marks a hidden file rather than an extension.
+	ext := path.Ext(strings.TrimLeft(name, ". "))
+	stem := cleanFilename(strings.TrimSuffix(name, ext))
+	if ext = cleanFilename(ext); ext != "" {
+		ext = "." + ext
+	}
+	if stem == "" {
+		return fallback + ext
+	}
+	if base, _, _ := strings.Cut(stem, "."); reservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		stem = "_" + stem
+	}
+	return stem + ext
+}
+
+func cleanFilename(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, toASCII(s))
+	return strings.Trim(s, " .")
+}
+
+// asciiReplacements covers common letters that don't decompose into ASCII + marks.
+var asciiReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "TH", 'ł': "l", 'Ł': "L",
+	'ı': "i",
+}
+
+// toASCII strips diacritics and transliterates what it can, dropping other non-ASCII runes.
+func toASCII(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(t, s)
+	if err != nil {
+		stripped = s
+	}
+	var b strings.Builder
+	for _, r := range stripped {
+		switch {
+		case r < unicode.MaxASCII:
+			b.WriteRune(r)
+		case asciiReplacements[r] != "":
+			b.WriteString(asciiReplacements[r])
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package slug
+
+import "testing"
+
+func TestParameterize(t *testing.T) {
+	tests := []struct {
+		in            string
+		transliterate bool
+		expected      string
+	}{
+		{"This is my Happy String", true, "this-is-my-happy-string"},
+		{"Café Crème Brûlée", true, "cafe-creme-brulee"},
+		{"Straße & Smørrebrød", true, "strasse-smorrebrod"},
+		{"日本語のタイトル", true, "job-5"},
+		{"日本語 Title 2024", true, "title-2024"},
+		{"Café Crème", false, "café-crème"},
+		{"日本語のタイトル", false, "日本語のタイトル"},
+		{"!!!", false, "job-5"},
+	}
+	for _, tt := range tests {
+		if got := Parameterize(tt.in, "job-5", tt.transliterate); got != tt.expected {
+			t.Errorf("Parameterize(%q, transliterate=%v) = %q; want %q", tt.in, tt.transliterate, got, tt.expected)
+		}
+	}
+}
+
+func TestFilename(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"video.mp4", "video.mp4"},
+		{"Q&A: why?.mp4", "Q&A_ why_.mp4"},
+		{`a<b>c"d|e*f\g.txt`, "a_b_c_d_e_f_g.txt"},
+		{"Café Crème.mp3", "Cafe Creme.mp3"},
+		{"日本語.mp4", "download.mp4"},
+		{"tab\there.txt", "tab_here.txt"},
+		{"trailing dot. ", "trailing dot"},
+		{"..hidden", "hidden"},
+		{"CON.txt", "_CON.txt"},
+		{"com1", "_com1"},
+		{"console.txt", "console.txt"},
+		{"", "download"},
+	}
+	for _, tt := range tests {
+		if got := Filename(tt.in, "download"); got != tt.expected {
+			t.Errorf("Filename(%q) = %q; want %q", tt.in, got, tt.expected)
+		}
+	}
+}
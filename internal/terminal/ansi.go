@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+package terminal
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"low-tide/internal/chars"
+)
+
+// RenderANSI returns the screen as text with SGR sequences, for viewing in a
+// real terminal (e.g. `less -R`). Trailing blank lines are left out.
+func (t *Terminal) RenderANSI() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last := -1
+	for i := t.maxLines - 1; i >= 0; i-- {
+		if len(t.lines[i]) > 0 {
+			last = i
+			break
+		}
+	}
+	var buf bytes.Buffer
+	for i := 0; i <= last; i++ {
+		activeStyle := chars.ANSI_Reset
+		for _, cell := range t.lines[i] {
+			if !bytes.Equal(cell.Style, activeStyle) {
+				buf.Write(cell.Style)
+				activeStyle = cell.Style
+			}
+			if cell.Char == 0 {
+				buf.WriteByte(' ')
+			} else {
+				buf.WriteByte(cell.Char)
+			}
+		}
+		if !bytes.Equal(activeStyle, chars.ANSI_Reset) {
+			buf.Write(chars.ANSI_Reset)
+		}
+		buf.WriteByte(chars.LF)
+	}
+	return buf.String()
+}
+
+var reHTMLTag = regexp.MustCompile(`<span class="([^"]*)">|</span>|<[^>]*>`)
+
+// HTMLToANSI converts HTML produced by RenderHTML (as stored for finished jobs)
+// back into the text RenderANSI would have returned for the same screen.
+func HTMLToANSI(rendered string) string {
+	lines := ParseLines(rendered)
+	last := -1
+	for idx := range lines {
+		last = max(last, idx)
+	}
+	var buf strings.Builder
+	for i := 0; i <= last; i++ {
+		if m := reLineDiv.FindStringSubmatch(lines[i]); m != nil {
+			writeANSILine(&buf, m[2])
+		}
+		buf.WriteByte(chars.LF)
+	}
+	return buf.String()
+}
+
+// writeANSILine writes one line body, turning terminal-to-html's spans back into
+// the SGR sequences they were rendered from. Like RenderANSI, it only resets
+// when unstyled text follows styled text.
+func writeANSILine(buf *strings.Builder, body string) {
+	// styled is set while the output is in a non-default style; inSpan while
+	// the text being read is inside a styled span.
+	styled, inSpan := false, false
+	text := func(s string) {
+		if s == "" {
+			return
+		}
+		if styled && !inSpan {
+			buf.Write(chars.ANSI_Reset)
+			styled = false
+		}
+		buf.WriteString(html.UnescapeString(s))
+	}
+	pos := 0
+	for _, m := range reHTMLTag.FindAllStringSubmatchIndex(body, -1) {
+		text(body[pos:m[0]])
+		pos = m[1]
+		if m[2] < 0 {
+			// A closing span, or markup (like a link) we only keep the text of.
+			if body[m[0]:m[1]] == "</span>" {
+				inSpan = false
+			}
+			continue
+		}
+		var s sgrState
+		for _, class := range strings.Fields(body[m[2]:m[3]]) {
+			if params := classParams(class); params != nil {
+				s.apply(params)
+			}
+		}
+		inSpan = s != (sgrState{})
+		if inSpan {
+			buf.Write(s.sequence())
+			styled = true
+		}
+	}
+	text(body[pos:])
+	if styled {
+		buf.Write(chars.ANSI_Reset)
+	}
+}
+
+// classParams maps one of terminal-to-html's term-* classes to the SGR
+// parameters that produce it. Unknown classes map to none.
+func classParams(class string) []int {
+	for _, c := range []struct {
+		prefix string
+		params []int
+	}{
+		{"term-fgx", []int{38, 5}},
+		{"term-bgx", []int{48, 5}},
+		{"term-fgi", nil},
+		{"term-bgi", nil},
+		{"term-fg", nil},
+		{"term-bg", nil},
+	} {
+		rest, ok := strings.CutPrefix(class, c.prefix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(rest)
+		if err != nil || n == 0 {
+			return nil
+		}
+		return append(append([]int{}, c.params...), n)
+	}
+	return nil
+}
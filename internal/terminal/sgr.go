@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+package terminal
+
+import (
+	"strconv"
+
+	"low-tide/internal/chars"
+)
+
+// color is a foreground or background color. A zero value is the default color.
+type color struct {
+	// code is the basic SGR code (30-37/90-97 for fg, 40-47/100-107 for bg),
+	// used when indexed is false.
+	code    int
+	indexed bool
+	// index is the xterm-256 palette entry, used when indexed is true.
+	index int
+}
+
+// sgrState is the graphic rendition in effect. SGR sequences are applied to it
+// incrementally, the way a terminal does, so a cell's style doesn't depend on
+// which earlier sequences happened to land on the same line.
+type sgrState struct {
+	bold, faint, italic, underline, blink, strike bool
+	fg, bg                                        color
+}
+
+// apply updates the state with the parameters of one SGR ("m") sequence.
+func (s *sgrState) apply(params []int) {
+	if len(params) == 0 {
+		*s = sgrState{}
+		return
+	}
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch {
+		case p == 0:
+			*s = sgrState{}
+		case p == 1:
+			s.bold, s.faint = true, false
+		case p == 2:
+			s.faint, s.bold = true, false
+		case p == 3:
+			s.italic = true
+		case p == 4:
+			s.underline = true
+		case p == 5 || p == 6:
+			s.blink = true
+		case p == 9:
+			s.strike = true
+		case p == 21 || p == 22:
+			s.bold, s.faint = false, false
+		case p == 23:
+			s.italic = false
+		case p == 24:
+			s.underline = false
+		case p == 25:
+			s.blink = false
+		case p == 29:
+			s.strike = false
+		case p >= 30 && p <= 37, p >= 90 && p <= 97:
+			s.fg = color{code: p}
+		case p == 39:
+			s.fg = color{}
+		case p >= 40 && p <= 47, p >= 100 && p <= 107:
+			s.bg = color{code: p}
+		case p == 49:
+			s.bg = color{}
+		case p == 38 || p == 48:
+			c, n, ok := extendedColor(params[i+1:])
+			i += n
+			if !ok {
+				continue
+			}
+			if p == 38 {
+				s.fg = c
+			} else {
+				s.bg = c
+			}
+		}
+	}
+}
+
+// extendedColor parses the arguments following 38 or 48: "5;n" for a palette
+// index or "2;r;g;b" for truecolor. It returns how many params it consumed.
+// Truecolor is mapped to the nearest palette entry, since that's the finest
+// color terminal-to-html can render.
+func extendedColor(args []int) (color, int, bool) {
+	if len(args) == 0 {
+		return color{}, 0, false
+	}
+	switch args[0] {
+	case 5:
+		if len(args) < 2 {
+			return color{}, len(args), false
+		}
+		if args[1] < 0 || args[1] > 255 {
+			return color{}, 2, false
+		}
+		return color{indexed: true, index: args[1]}, 2, true
+	case 2:
+		if len(args) < 4 {
+			return color{}, len(args), false
+		}
+		return color{indexed: true, index: rgbToIndex(args[1], args[2], args[3])}, 4, true
+	}
+	return color{}, 1, false
+}
+
+// cubeLevels are the channel values of the xterm 6x6x6 color cube.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// rgbToIndex returns the xterm-256 palette entry closest to r, g, b, choosing
+// between the color cube and the grayscale ramp.
+func rgbToIndex(r, g, b int) int {
+	r, g, b = clampByte(r), clampByte(g), clampByte(b)
+	ri, gi, bi := cubeIndex(r), cubeIndex(g), cubeIndex(b)
+	cube := 16 + 36*ri + 6*gi + bi
+	cubeDist := dist(r, g, b, cubeLevels[ri], cubeLevels[gi], cubeLevels[bi])
+
+	gray := (r + g + b) / 3
+	grayStep := max(0, min((gray-3)/10, 23))
+	level := 8 + 10*grayStep
+	if dist(r, g, b, level, level, level) < cubeDist {
+		return 232 + grayStep
+	}
+	return cube
+}
+
+func cubeIndex(v int) int {
+	best := 0
+	for i, l := range cubeLevels {
+		if abs(v-l) < abs(v-cubeLevels[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func dist(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
+
+func clampByte(v int) int {
+	return max(0, min(v, 255))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// sequence returns a self-contained SGR sequence for the state: it starts with a
+// reset, so it renders the same no matter what style preceded it.
+func (s sgrState) sequence() []byte {
+	if s == (sgrState{}) {
+		return chars.ANSI_Reset
+	}
+	seq := []byte("\x1b[0")
+	flag := func(on bool, code string) {
+		if on {
+			seq = append(seq, ';')
+			seq = append(seq, code...)
+		}
+	}
+	flag(s.bold, "1")
+	flag(s.faint, "2")
+	flag(s.italic, "3")
+	flag(s.underline, "4")
+	flag(s.blink, "5")
+	flag(s.strike, "9")
+	seq = s.fg.append(seq, "38")
+	seq = s.bg.append(seq, "48")
+	return append(seq, 'm')
+}
+
+// append adds the color's parameters to seq; extended is "38" or "48".
+func (c color) append(seq []byte, extended string) []byte {
+	switch {
+	case c.indexed:
+		seq = append(seq, ';')
+		seq = append(seq, extended...)
+		seq = append(seq, ";5;"...)
+		return strconv.AppendInt(seq, int64(c.index), 10)
+	case c.code != 0:
+		seq = append(seq, ';')
+		return strconv.AppendInt(seq, int64(c.code), 10)
+	}
+	return seq
+}
@@ -8,6 +8,7 @@ import (
 	"low-tide/internal/chars"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -25,38 +26,87 @@ type Terminal struct {
 	currentStyle []byte
 	dirty        map[int]bool
 	lastRendered map[int]string
+	// scrolled counts lines scrolled off the top since the last delta. dirty and
+	// lastRendered move with the lines, so a delta is a scroll plus the lines that
+	// actually changed rather than every line on screen.
+	scrolled int
+	// rendered caches each line's HTML body until the line changes ("" means
+	// stale). htmlCache memoizes ansi.Render by raw line content, so repeated
+	// lines (blank ones especially) aren't converted again.
+	rendered  []string
+	htmlCache map[string]string
 	// pending holds an incomplete ANSI escape sequence that was split across
 	// reads/writes (common when streaming from a PTY). Without buffering, we'd
 	// drop the ESC byte and then render the remaining bytes literally (e.g.
 	// "[38;5;237m").
 	pending []byte
+
+	// rows is the height of the emulated screen: lines top through top+rows-1.
+	// Lines above top have scrolled off and are kept as scrollback that cursor
+	// movement can't reach.
+	rows, top int
+	// savedX/savedY hold the position stored by ESC [ s (or ESC 7); savedY is
+	// relative to the top of the screen.
+	savedX, savedY int
+	// sgr is the graphic rendition currentStyle was built from.
+	sgr sgrState
 }
 
-var reCSI = regexp.MustCompile(`^(\d*)(?:;(\d*))?([a-zA-Z])`)
+// Width is the column count commands are told the terminal has (the pty size);
+// tab stops are clamped to it.
+const Width = 100
+
+// tabWidth is the distance between tab stops.
+const tabWidth = 8
+
+// reCSI splits a CSI payload into its private marker (e.g. "?"), its
+// ;-separated parameters and the final command letter.
+var reCSI = regexp.MustCompile(`^([?<=>]?)([\d;]*)([a-zA-Z])`)
 
+// New returns a terminal whose screen is maxLines tall, with no scrollback.
 func New(maxLines int) *Terminal {
+	return NewWithScrollback(maxLines, maxLines)
+}
+
+// NewWithScrollback returns a terminal with a screen of rows lines, the region
+// cursor addressing works in, keeping up to maxLines lines in total.
+func NewWithScrollback(rows, maxLines int) *Terminal {
 	t := &Terminal{
 		maxLines:     maxLines,
+		rows:         min(rows, maxLines),
 		dirty:        make(map[int]bool),
 		lastRendered: make(map[int]string),
+		htmlCache:    make(map[string]string),
 	}
 	t.resetBuffer()
 	return t
 }
 
+// Rows returns the height of the emulated screen.
+func (t *Terminal) Rows() int {
+	return t.rows
+}
+
 func (t *Terminal) resetBuffer() {
 	t.lines = make([][]Cell, t.maxLines)
+	t.rendered = make([]string, t.maxLines)
 	for i := range t.lines {
 		t.lines[i] = []Cell{}
 		t.dirty[i] = true
 	}
+	t.top = 0
 	t.cursorY = 0
 	t.cursorX = 0
+	t.savedX, t.savedY = 0, 0
+	t.sgr = sgrState{}
 	t.currentStyle = chars.ANSI_Reset
 	t.pending = nil
 	t.lastRendered = make(map[int]string)
+	t.scrolled = 0
 }
 
+// Write feeds output into the terminal. It copies what it keeps, so callers may
+// reuse data as soon as it returns.
 func (t *Terminal) Write(data []byte) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -96,7 +146,21 @@ func (t *Terminal) Write(data []byte) {
 				}
 				return
 			}
-			// Not a CSI sequence we understand; drop ESC.
+			switch data[i+1] {
+			case 'c': // RIS: full reset
+				t.resetBuffer()
+				i += 2
+				continue
+			case '7': // DECSC: save cursor
+				t.saveCursor()
+				i += 2
+				continue
+			case '8': // DECRC: restore cursor
+				t.restoreCursor()
+				i += 2
+				continue
+			}
+			// Not a sequence we understand; drop ESC.
 			i++
 			continue
 		}
@@ -112,8 +176,7 @@ func (t *Terminal) Write(data []byte) {
 				t.cursorX--
 			}
 		case chars.TAB:
-			// Tabs: move to next multiple of 8
-			t.cursorX = (t.cursorX/8 + 1) * 8
+			t.tab()
 		default:
 			if b >= 32 {
 				t.writeCell(b)
@@ -130,23 +193,42 @@ func (t *Terminal) handleCSI(fullSeq []byte) {
 		return
 	}
 
-	p1, _ := strconv.Atoi(match[1])
-	p2, _ := strconv.Atoi(match[2])
+	// Private sequences (DECTCEM cursor show/hide "?25l", alternate screen, ...)
+	// only change modes we don't model; swallow them rather than print them.
+	if match[1] != "" {
+		return
+	}
+
+	var params []int
+	if match[2] != "" {
+		for _, p := range strings.Split(match[2], ";") {
+			n, _ := strconv.Atoi(p)
+			params = append(params, n)
+		}
+	}
+	param := func(i int) int {
+		if i < len(params) {
+			return params[i]
+		}
+		return 0
+	}
+	p1, p2 := param(0), param(1)
 	cmd := match[3]
 
 	switch cmd {
-	case "m": // We capture the entire sequence to apply to future characters
-		t.currentStyle = append([]byte{}, fullSeq...)
+	case "m": // Fold into the current rendition; cells get a normalized sequence
+		t.sgr.apply(params)
+		t.currentStyle = t.sgr.sequence()
 	case "A": // Up
 		if p1 == 0 {
 			p1 = 1
 		}
 		t.cursorY -= p1
-	case "B": // Down
+	case "B": // Down, stopping at the bottom of the screen
 		if p1 == 0 {
 			p1 = 1
 		}
-		t.cursorY += p1
+		t.cursorY = min(t.cursorY+p1, t.top+t.rows-1)
 	case "C": // Right
 		if p1 == 0 {
 			p1 = 1
@@ -160,56 +242,144 @@ func (t *Terminal) handleCSI(fullSeq []byte) {
 		if t.cursorX < 0 {
 			t.cursorX = 0
 		}
-	case "H", "f": // Home / Position
+	case "H", "f": // Home / Position, relative to the top of the screen
 		if p1 > 0 {
-			t.cursorY = p1 - 1
+			t.cursorY = t.top + min(p1, t.rows) - 1
 		} else {
-			t.cursorY = 0
+			t.cursorY = t.top
 		}
 		if p2 > 0 {
 			t.cursorX = p2 - 1
 		} else {
 			t.cursorX = 0
 		}
-	case "J": // Clear Screen
-		if p1 == 2 {
-			t.resetBuffer()
+	case "J": // Clear Screen; scrollback is left alone
+		switch p1 {
+		case 0: // From the cursor to the end of the screen
+			t.clearLine(t.cursorY, t.cursorX)
+			t.clearRows(t.cursorY+1, t.top+t.rows)
+		case 2:
+			t.clearRows(t.top, t.top+t.rows)
 		}
+	case "s": // Save cursor position
+		t.saveCursor()
+	case "u": // Restore cursor position
+		t.restoreCursor()
 	case "K": // Clear Line
 		if p1 == 0 { // Clear from cursor to end of line
 			if t.cursorY >= 0 && t.cursorY < len(t.lines) {
 				if t.cursorX < len(t.lines[t.cursorY]) {
 					t.lines[t.cursorY] = t.lines[t.cursorY][:t.cursorX]
-					t.dirty[t.cursorY] = true
+					t.touch(t.cursorY)
 				}
 			}
 		} else if p1 == 2 { // Clear entire line
 			if t.cursorY >= 0 && t.cursorY < len(t.lines) {
 				t.lines[t.cursorY] = []Cell{}
-				t.dirty[t.cursorY] = true
+				t.touch(t.cursorY)
 			}
 		}
 	}
 	t.ensureCursorY()
 }
 
+// ensureCursorY keeps the cursor on the screen. Moving past the bottom scrolls
+// the screen down over the buffer, and once the buffer is full, drops the oldest
+// lines off the top of it.
 func (t *Terminal) ensureCursorY() {
-	if t.cursorY < 0 {
-		t.cursorY = 0
+	if t.cursorY < t.top {
+		t.cursorY = t.top
+	}
+	if bottom := t.top + t.rows - 1; t.cursorY > bottom {
+		t.top += t.cursorY - bottom
+	}
+	if over := t.top + t.rows - t.maxLines; over > 0 {
+		t.scroll(over)
+		t.top -= over
+		t.cursorY -= over
 	}
-	if t.cursorY >= t.maxLines {
-		// Scroll
-		diff := t.cursorY - (t.maxLines - 1)
-		copy(t.lines, t.lines[diff:])
-		for j := t.maxLines - diff; j < t.maxLines; j++ {
-			t.lines[j] = []Cell{}
+}
+
+// clearLine blanks line idx from column x on.
+func (t *Terminal) clearLine(idx, x int) {
+	if x == 0 {
+		t.lines[idx] = []Cell{}
+	} else if x < len(t.lines[idx]) {
+		t.lines[idx] = t.lines[idx][:x]
+	}
+	t.touch(idx)
+}
+
+// clearRows blanks lines from up to (not including) to.
+func (t *Terminal) clearRows(from, to int) {
+	for idx := from; idx < min(to, t.maxLines); idx++ {
+		t.clearLine(idx, 0)
+	}
+}
+
+// scroll moves everything up by n lines, taking the render cache and the delta
+// bookkeeping along so only the blank lines it opens up at the bottom are dirty.
+func (t *Terminal) scroll(n int) {
+	n = min(n, t.maxLines)
+	copy(t.lines, t.lines[n:])
+	copy(t.rendered, t.rendered[n:])
+	dirty := make(map[int]bool, len(t.dirty))
+	lastRendered := make(map[int]string, len(t.lastRendered))
+	for idx := range t.dirty {
+		if idx >= n {
+			dirty[idx-n] = true
 		}
-		t.cursorY = t.maxLines - 1
-		// When we scroll, every line effectively changes its content/index
-		for j := 0; j < t.maxLines; j++ {
-			t.dirty[j] = true
+	}
+	for idx, body := range t.lastRendered {
+		if idx >= n {
+			lastRendered[idx-n] = body
+		}
+	}
+	t.dirty, t.lastRendered = dirty, lastRendered
+	for j := t.maxLines - n; j < t.maxLines; j++ {
+		t.lines[j] = []Cell{}
+		delete(t.lastRendered, j)
+		t.touch(j)
+	}
+	t.scrolled += n
+}
+
+func (t *Terminal) saveCursor() {
+	t.savedX, t.savedY = t.cursorX, t.cursorY-t.top
+}
+
+// restoreCursor returns to the saved position. Scrolling since the save isn't
+// accounted for, matching what terminals do.
+func (t *Terminal) restoreCursor() {
+	t.cursorX, t.cursorY = t.savedX, t.top+t.savedY
+	t.ensureCursorY()
+}
+
+// touch marks a line as changed, for both the delta publisher and the render cache.
+func (t *Terminal) touch(idx int) {
+	t.dirty[idx] = true
+	t.rendered[idx] = ""
+}
+
+// tab moves to the next tab stop, clamped to the last column, and blanks the
+// cells it skips so nothing stale is left showing between the columns.
+func (t *Terminal) tab() {
+	next := min((t.cursorX/tabWidth+1)*tabWidth, Width-1)
+	if next <= t.cursorX {
+		return
+	}
+	line := t.lines[t.cursorY]
+	for x := t.cursorX; x < next; x++ {
+		cell := Cell{Char: ' ', Style: t.currentStyle}
+		if x < len(line) {
+			line[x] = cell
+		} else {
+			line = append(line, cell)
 		}
 	}
+	t.lines[t.cursorY] = line
+	t.touch(t.cursorY)
+	t.cursorX = next
 }
 
 func (t *Terminal) writeCell(b byte) {
@@ -230,29 +400,45 @@ func (t *Terminal) writeCell(b byte) {
 		line = append(line, newCell)
 	}
 	t.lines[t.cursorY] = line
-	t.dirty[t.cursorY] = true
+	t.touch(t.cursorY)
 	t.cursorX++
 }
 
-func (t *Terminal) GetDeltaHTML() map[int]string {
+// GetDeltaHTML returns what changed since the previous call: scroll is how many
+// lines to drop from the top first (shifting the rest up), then lines holds the
+// rendered lines that differ from what was last sent, keyed by their new index.
+func (t *Terminal) GetDeltaHTML() (scroll int, lines map[int]string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	delta := make(map[int]string)
+	scroll, t.scrolled = t.scrolled, 0
+	lines = make(map[int]string)
 	for idx, isDirty := range t.dirty {
 		if isDirty {
-			renderedLine := t.renderLine(idx)
-			if renderedLine != t.lastRendered[idx] { // Only include in delta if changed
-				delta[idx] = renderedLine
-				t.lastRendered[idx] = renderedLine
+			body := t.renderBody(idx)
+			if prev, sent := t.lastRendered[idx]; !sent || body != prev { // Only include in delta if changed
+				lines[idx] = wrapLine(idx, body)
+				t.lastRendered[idx] = body
 			}
 			delete(t.dirty, idx)
 		}
 	}
-	return delta
+	return scroll, lines
 }
 
 func (t *Terminal) renderLine(idx int) string {
+	return wrapLine(idx, t.renderBody(idx))
+}
+
+func wrapLine(idx int, body string) string {
+	return fmt.Sprintf(`<div data-line="%d">%s</div>`, idx, body)
+}
+
+// renderBody returns the HTML for a line's content, without its wrapping div.
+func (t *Terminal) renderBody(idx int) string {
+	if body := t.rendered[idx]; body != "" {
+		return body
+	}
 	var buf bytes.Buffer
 	var activeStyle []byte
 	for _, cell := range t.lines[idx] {
@@ -268,7 +454,16 @@ func (t *Terminal) renderLine(idx int) string {
 	}
 	// Always append reset to ensure line doesn't bleed into others in terminal-to-html
 	buf.Write(chars.ANSI_Reset)
-	return fmt.Sprintf(`<div data-line="%d">%s</div>`, idx, ansi.Render(buf.Bytes()))
+	body, ok := t.htmlCache[string(buf.Bytes())]
+	if !ok {
+		body = ansi.Render(buf.Bytes())
+		if len(t.htmlCache) >= 2*t.maxLines {
+			clear(t.htmlCache)
+		}
+		t.htmlCache[buf.String()] = body
+	}
+	t.rendered[idx] = body
+	return body
 }
 
 func (t *Terminal) RenderHTML() string {
@@ -282,6 +477,41 @@ func (t *Terminal) RenderHTML() string {
 	return buf.String()
 }
 
+// Lines returns the rendered non-blank lines keyed by index, in the same form as
+// GetDeltaHTML, so a client can build its view from a full set of lines the same
+// way it applies deltas.
+func (t *Terminal) Lines() map[int]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := make(map[int]string)
+	for i := 0; i < t.maxLines; i++ {
+		if len(t.lines[i]) > 0 {
+			lines[i] = t.renderLine(i)
+		}
+	}
+	return lines
+}
+
+var reLineDiv = regexp.MustCompile(`<div data-line="(\d+)">(.*?)</div>`)
+
+// ParseLines splits HTML produced by RenderHTML (as stored for finished jobs)
+// back into the per-line map returned by Lines. Blank lines are left out.
+func ParseLines(html string) map[int]string {
+	lines := make(map[int]string)
+	for _, m := range reLineDiv.FindAllStringSubmatch(html, -1) {
+		if m[2] == "" {
+			continue
+		}
+		idx, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		lines[idx] = m[0]
+	}
+	return lines
+}
+
 func (t *Terminal) Clear() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -0,0 +1,303 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRenderCacheInvalidation(t *testing.T) {
+	term := New(3)
+	term.Write([]byte("one\r\ntwo"))
+	if html := term.RenderHTML(); !strings.Contains(html, "one") || !strings.Contains(html, "two") {
+		t.Fatalf("unexpected render: %q", html)
+	}
+
+	// Overwriting a line has to show up in the next render.
+	term.Write([]byte("\rTWO"))
+	if html := term.RenderHTML(); !strings.Contains(html, "TWO") || strings.Contains(html, "two") {
+		t.Fatalf("stale line after overwrite: %q", html)
+	}
+
+	// Scrolling moves content to other indexes.
+	term.Write([]byte("\r\nthree\r\nfour"))
+	html := term.RenderHTML()
+	if strings.Contains(html, "one") {
+		t.Fatalf("scrolled-off line still rendered: %q", html)
+	}
+	for i, want := range []string{"TWO", "three", "four"} {
+		if !strings.Contains(html, fmt.Sprintf(`<div data-line="%d">%s`, i, want)) {
+			t.Fatalf("expected %q on line %d: %q", want, i, html)
+		}
+	}
+
+	// The live delta sees the same content as the full render.
+	_, delta := term.GetDeltaHTML()
+	if !strings.Contains(delta[2], "four") {
+		t.Fatalf("unexpected delta: %v", delta)
+	}
+}
+
+func BenchmarkRenderHTML(b *testing.B) {
+	term := New(500)
+	for i := 0; i < 600; i++ {
+		fmt.Fprintf(writerFunc(term.Write), "\x1b[32m[download]\x1b[0m %3d.0%% of 1.21GiB at 12.3MiB/s\r\n", i%100)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A new line between renders, like a live job being polled.
+		term.Write([]byte("tick\r\n"))
+		_ = term.RenderHTML()
+	}
+}
+
+type writerFunc func([]byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
+
+func TestParseLinesMatchesLines(t *testing.T) {
+	term := New(10)
+	term.Write([]byte("first\r\n\r\n\x1b[31mred\x1b[0m text\r\n"))
+
+	live := term.Lines()
+	if len(live) != 2 || !strings.Contains(live[0], "first") || !strings.Contains(live[2], "red") {
+		t.Fatalf("unexpected live lines: %q", live)
+	}
+	stored := ParseLines(term.RenderHTML())
+	if fmt.Sprint(stored) != fmt.Sprint(live) {
+		t.Fatalf("stored render parsed to %q, want %q", stored, live)
+	}
+}
+
+func TestTabsAndBackticks(t *testing.T) {
+	term := New(3)
+	term.Write([]byte("a\tb\r\n`code`"))
+	html := term.RenderHTML()
+	if !strings.Contains(html, `<div data-line="0">a       b</div>`) {
+		t.Fatalf("expected b on the next tab stop: %q", html)
+	}
+	if !strings.Contains(html, "`code`") {
+		t.Fatalf("expected backticks to render literally: %q", html)
+	}
+}
+
+func TestTabStops(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"at column 0", "\tX", "        X"},
+		{"mid-line", "abc\tX", "abc     X"},
+		{"blanks what it skips", "abcdefghij\r\tX", "        Xj"},
+		{"clamped near the right edge", strings.Repeat("a", Width-3) + "\tZ", strings.Repeat("a", Width-3) + "  Z"},
+		{"stays put on the last column", strings.Repeat("a", Width-1) + "\t\tZ", strings.Repeat("a", Width-1) + "Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := New(2)
+			term.Write([]byte(tt.input))
+			if got := term.RenderHTML(); !strings.Contains(got, `<div data-line="0">`+tt.want+`</div>`) {
+				t.Fatalf("got %q, want line %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveRestoreCursorAndReset(t *testing.T) {
+	term := New(3)
+	// A progress UI: save the spot, print, come back and overwrite it.
+	term.Write([]byte("progress: \x1b[s10%\x1b[u42%\r\nnext"))
+	html := term.RenderHTML()
+	if !strings.Contains(html, `<div data-line="0">progress: 42%</div>`) || !strings.Contains(html, `<div data-line="1">next</div>`) {
+		t.Fatalf("CSI s/u round-trip failed: %q", html)
+	}
+
+	term.Write([]byte("\r\nab\x1b7cd\x1b8XY"))
+	if html := term.RenderHTML(); !strings.Contains(html, `<div data-line="2">abXY</div>`) {
+		t.Fatalf("ESC 7/8 round-trip failed: %q", html)
+	}
+
+	term.Write([]byte("\x1bcfresh"))
+	html = term.RenderHTML()
+	if !strings.Contains(html, `<div data-line="0">fresh</div>`) || strings.Contains(html, "progress") || strings.Contains(html, "[c") {
+		t.Fatalf("ESC c should reset the screen: %q", html)
+	}
+}
+
+func TestCSIPrivateAndMultiParam(t *testing.T) {
+	term := New(3)
+	term.Write([]byte("\x1b[?25lhidden cursor\x1b[?25h\r\n\x1b[1;31;42mbold red on green\x1b[0m\r\n\x1b[3;5Hat"))
+	html := term.RenderHTML()
+	if !strings.Contains(html, `<div data-line="0">hidden cursor</div>`) {
+		t.Fatalf("expected cursor show/hide to be swallowed: %q", html)
+	}
+	if !strings.Contains(html, `<span class="term-fg31 term-bg42 term-fg1">bold red on green</span>`) {
+		t.Fatalf("expected three-parameter SGR to apply: %q", html)
+	}
+	if !strings.Contains(html, `<div data-line="2">    at</div>`) {
+		t.Fatalf("expected CUP to row 3, column 5: %q", html)
+	}
+}
+
+func TestExtendedColors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"256-color after a reset", "\x1b[0m\x1b[38;5;208mY", `<span class="term-fgx208">Y</span>`},
+		{"truecolor foreground", "\x1b[38;2;255;135;0mX", `<span class="term-fgx208">X</span>`},
+		{"truecolor background", "\x1b[48;2;1;2;3mX", `<span class="term-bgx16">X</span>`},
+		{"truecolor gray", "\x1b[38;2;128;128;128mX", `<span class="term-fgx244">X</span>`},
+		{"bold and color from separate sequences", "\x1b[1m\x1b[38;5;33mX", `<span class="term-fgx33 term-fg1">X</span>`},
+		{"default fg keeps bg", "\x1b[31;44m\x1b[39mX", `<span class="term-bg44">X</span>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := New(2)
+			term.Write([]byte(tt.input))
+			if got := term.RenderHTML(); !strings.Contains(got, `<div data-line="0">`+tt.want+`</div>`) {
+				t.Fatalf("got %q, want line %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStyleCarriesAcrossLines(t *testing.T) {
+	term := New(3)
+	term.Write([]byte("\x1b[1m\x1b[38;2;0;135;255mone\r\ntwo\x1b[0m"))
+	html := term.RenderHTML()
+	for i, text := range []string{"one", "two"} {
+		want := fmt.Sprintf(`<div data-line="%d"><span class="term-fgx33 term-fg1">%s</span></div>`, i, text)
+		if !strings.Contains(html, want) {
+			t.Fatalf("line %d lost its style: %q", i, html)
+		}
+	}
+}
+
+func TestPrivateModesLeaveNoTrace(t *testing.T) {
+	term := New(3)
+	// Bracketed paste and mouse tracking, with one pair split across writes.
+	term.Write([]byte("\x1b[?2004hprompt\x1b[?2004l\r\n\x1b[?1000;1006h"))
+	term.Write([]byte("click\x1b[?10"))
+	term.Write([]byte("00;1006l\r\n"))
+	// Device attribute queries and cursor style changes aren't letters-only.
+	term.Write([]byte("\x1b[>0c\x1b[2 qdone"))
+	html := term.RenderHTML()
+	for i, want := range []string{"prompt", "click", "done"} {
+		if !strings.Contains(html, fmt.Sprintf(`<div data-line="%d">%s</div>`, i, want)) {
+			t.Fatalf("expected clean %q on line %d: %q", want, i, html)
+		}
+	}
+}
+
+func TestScrollDeltaMatchesRender(t *testing.T) {
+	term := New(4)
+	// client mirrors what the browser does with job_log events.
+	var client []string
+	apply := func() (int, map[int]string) {
+		scroll, lines := term.GetDeltaHTML()
+		client = client[min(scroll, len(client)):]
+		for i := range client {
+			client[i] = strings.Replace(client[i], fmt.Sprintf(`data-line="%d"`, i+scroll), fmt.Sprintf(`data-line="%d"`, i), 1)
+		}
+		for idx, html := range lines {
+			for len(client) <= idx {
+				client = append(client, "")
+			}
+			client[idx] = html
+		}
+		return scroll, lines
+	}
+
+	apply()
+	term.Write([]byte("a\r\nb\r\nc\r\n"))
+	apply()
+	term.Write([]byte("d\r\ne\r\nf"))
+	scroll, lines := apply()
+	if scroll != 2 {
+		t.Fatalf("expected a scroll of 2, got %d", scroll)
+	}
+	// Lines that only moved aren't re-sent.
+	if _, ok := lines[0]; ok {
+		t.Fatalf("moved line was re-sent: %v", lines)
+	}
+	term.Write([]byte("\r\ng\x1b[1;1HC"))
+	apply()
+	if got, want := strings.Join(client, ""), term.RenderHTML(); got != want {
+		t.Fatalf("client view diverged:\n got %q\nwant %q", got, want)
+	}
+}
+
+// BenchmarkScrollingDelta reports how many bytes of deltas verbose output costs
+// once the screen is full and every new line scrolls it.
+func BenchmarkScrollingDelta(b *testing.B) {
+	term := New(500)
+	for i := 0; i < 600; i++ {
+		fmt.Fprintf(writerFunc(term.Write), "\x1b[32m[download]\x1b[0m %3d.0%% of 1.21GiB at 12.3MiB/s\r\n", i%100)
+	}
+	term.GetDeltaHTML()
+	var total int
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A handful of lines per publisher tick.
+		for j := 0; j < 5; j++ {
+			fmt.Fprintf(writerFunc(term.Write), "\x1b[32m[download]\x1b[0m %3d.0%% of 1.21GiB at 12.3MiB/s\r\n", (i+j)%100)
+		}
+		_, lines := term.GetDeltaHTML()
+		for _, line := range lines {
+			total += len(line)
+		}
+	}
+	b.ReportMetric(float64(total)/float64(b.N), "delta-bytes/op")
+}
+
+func TestRenderANSI(t *testing.T) {
+	term := New(5)
+	term.Write([]byte("plain & <text>\r\n\x1b[1;31mbold red\x1b[38;5;208m orange\x1b[0m done\r\n\x1b[48;2;0;0;255mblue bg\r\n"))
+	got := term.RenderANSI()
+	want := "plain & <text>\n" +
+		"\x1b[0;1;31mbold red\x1b[0;1;38;5;208m orange\x1b[0m done\n" +
+		"\x1b[0;48;5;21mblue bg\x1b[0m\n"
+	if got != want {
+		t.Fatalf("RenderANSI:\n got %q\nwant %q", got, want)
+	}
+	// Finished jobs only keep the HTML; converting it back gives the same text.
+	if back := HTMLToANSI(term.RenderHTML()); back != want {
+		t.Fatalf("HTMLToANSI:\n got %q\nwant %q", back, want)
+	}
+}
+
+func TestViewportAndScrollback(t *testing.T) {
+	term := NewWithScrollback(3, 6)
+	term.Write([]byte("one\r\ntwo\r\nthree\r\nfour\r\nfive"))
+	// The screen is now three..five; home and a full clear only reach it.
+	term.Write([]byte("\x1b[H\x1b[2JA\x1b[3;2HB\x1b[9;1HC\x1b[10AD"))
+	want := []string{"one", "two", "AD", "", "CB"}
+	html := term.RenderHTML()
+	for i, text := range want {
+		if !strings.Contains(html, fmt.Sprintf(`<div data-line="%d">%s</div>`, i, text)) {
+			t.Fatalf("expected %q on line %d: %q", text, i, html)
+		}
+	}
+
+	// Scrolling past the buffer drops the oldest scrollback, not screen lines.
+	term.Write([]byte("\x1b[3;1H\r\nsix\r\nseven"))
+	html = term.RenderHTML()
+	for i, text := range []string{"two", "AD", "", "CB", "six", "seven"} {
+		if !strings.Contains(html, fmt.Sprintf(`<div data-line="%d">%s</div>`, i, text)) {
+			t.Fatalf("after scrolling, expected %q on line %d: %q", text, i, html)
+		}
+	}
+	// Saved positions are screen positions, so after a scroll they point at
+	// whatever is on that row now.
+	term.Write([]byte("\x1b[1;1H\x1b7\r\n\r\n\r\nnew\x1b8X"))
+	if html := term.RenderHTML(); !strings.Contains(html, `<div data-line="3">Xix</div>`) {
+		t.Fatalf("expected restore to land on the top screen row: %q", html)
+	}
+}
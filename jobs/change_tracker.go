@@ -1,7 +1,11 @@
 // SPDX-License-Identifier: AGPL-3.0-only
 package jobs
 
-import "time"
+import (
+	"time"
+
+	"low-tide/store"
+)
 
 // jobChange tracks dirty state and last-sent payloads for job snapshots.
 type jobChange struct {
@@ -10,6 +14,11 @@ type jobChange struct {
 	seq      uint64
 }
 
+type dirtyJob struct {
+	jobID int64
+	seq   uint64
+}
+
 // filesPublisher emits job files snapshots at most every 100ms when marked dirty.
 // It uses a seq number to avoid clearing the dirty flag if new changes occurred
 // while we were rendering/sending the snapshot.
@@ -17,26 +26,48 @@ func (m *Manager) filesPublisher() {
 	t := time.NewTicker(100 * time.Millisecond)
 	defer t.Stop()
 
-	type workItem struct {
-		jobID int64
-		seq   uint64
+	for range t.C {
+		m.publishDirty()
 	}
+}
 
-	for range t.C {
-		m.jobChangesMu.Lock()
-		items := make([]workItem, 0, len(m.jobChanges))
-		for id, ch := range m.jobChanges {
-			if ch == nil || !ch.dirty {
-				continue
-			}
-			items = append(items, workItem{jobID: id, seq: ch.seq})
+// publishDirty broadcasts snapshots for every job marked dirty since the last tick.
+func (m *Manager) publishDirty() {
+	m.jobChangesMu.Lock()
+	items := make([]dirtyJob, 0, len(m.jobChanges))
+	for id, ch := range m.jobChanges {
+		if ch == nil || !ch.dirty {
+			continue
 		}
-		m.jobChangesMu.Unlock()
+		items = append(items, dirtyJob{jobID: id, seq: ch.seq})
+	}
+	m.jobChangesMu.Unlock()
+
+	if m.Cfg.BatchSnapshots && len(items) > 1 {
+		m.publishBatch(items)
+		return
+	}
+	for _, it := range items {
+		m.BroadcastJobSnapshot(it.jobID)
+		m.markClean(it.jobID, it.seq)
+	}
+}
 
-		for _, it := range items {
-			m.BroadcastJobSnapshot(it.jobID)
-			m.markClean(it.jobID, it.seq)
+// publishBatch sends all changed snapshots of a tick as one job_snapshots event.
+func (m *Manager) publishBatch(items []dirtyJob) {
+	var batch []*store.Job
+	for _, it := range items {
+		if j, changed := m.jobSnapshot(it.jobID); changed {
+			batch = append(batch, j)
 		}
+		m.markClean(it.jobID, it.seq)
+	}
+	switch len(batch) {
+	case 0:
+	case 1:
+		m.BroadcastState(JobSnapshotEvent{Type: "job_snapshot", Job: batch[0], At: time.Now()})
+	default:
+		m.BroadcastState(JobSnapshotsEvent{Type: "job_snapshots", Jobs: batch, At: time.Now()})
 	}
 }
 
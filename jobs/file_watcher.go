@@ -2,12 +2,17 @@
 package jobs
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"low-tide/config"
+	"low-tide/internal/logging"
 	"low-tide/store"
 )
 
@@ -26,13 +31,19 @@ func (m *Manager) watchLoop() {
 				m.handleFileEvent(ev.Name)
 			}
 			if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				if ev.Name == m.downloadsRoot {
+					// Recreated (and watched again) when the next job starts, so a
+					// directory removed on purpose isn't brought back behind the user.
+					logging.Warnf("downloads_dir %s was removed; it will be recreated when the next job starts", m.downloadsRoot)
+					continue
+				}
 				m.handleRemoveEvent(ev.Name)
 			}
 		case err, ok := <-m.Watcher.Errors:
 			if !ok {
 				return
 			}
-			log.Printf("fsnotify error: %v", err)
+			logging.Errorf("fsnotify error: %v", err)
 		}
 	}
 }
@@ -43,6 +54,10 @@ func (m *Manager) handleFileEvent(path string) {
 	if err != nil {
 		return
 	}
+
+	m.filesMu.Lock()
+	defer m.filesMu.Unlock()
+
 	info, err := os.Stat(absPath)
 	if err != nil {
 		// Can happen if file is deleted immediately after create
@@ -52,11 +67,13 @@ func (m *Manager) handleFileEvent(path string) {
 	jobID := m.CurrentJobID()
 
 	if info.IsDir() {
-		_ = addRecursiveWatch(m.Watcher, absPath)
+		if err := m.addWatch(absPath); err != nil {
+			m.watchFailed(jobID, absPath, err)
+		}
 		// If a job is running, scan this new directory immediately to close the race condition
 		// where files are created before the watch is fully active.
 		if jobID != 0 {
-			go m.scanSiblings(jobID, absPath)
+			m.queueScan(jobID, absPath)
 		}
 		return
 	}
@@ -70,16 +87,20 @@ func (m *Manager) handleFileEvent(path string) {
 	m.mu.Unlock()
 
 	// Only handle files that are within the current job's directory.
-	if cur == nil || !strings.HasPrefix(absPath, cur.jobDir) {
+	if cur == nil || !inDir(absPath, cur.jobDir) {
+		return
+	}
+	if m.overFileLimit(cur, 0) {
 		return
 	}
 
 	exists, _ := store.JobFileExists(m.DB, jobID, absPath)
 	if !exists {
-		log.Printf("job %d: found new file: %s", jobID, m.toRel(absPath))
+		logging.Debugf("job %d: found new file: %s", jobID, m.toRel(absPath))
+		m.claimFiles(jobID, absPath)
 		// New file found: scan the directory for any other siblings we might have missed
 		// (e.g. due to race conditions or missed events).
-		go m.scanSiblings(jobID, filepath.Dir(absPath))
+		m.queueScan(jobID, filepath.Dir(absPath))
 	}
 
 	// upsert file immediately
@@ -98,6 +119,8 @@ func (m *Manager) handleRemoveEvent(path string) {
 		return
 	}
 
+	m.filesMu.Lock()
+	defer m.filesMu.Unlock()
 	_ = store.DeleteJobFileByPath(m.DB, jobID, absPath)
 	m.markDirty(jobID)
 }
@@ -121,6 +144,55 @@ func (m *Manager) toRel(abs string) string {
 	return rel
 }
 
+// ensureDownloadsRoot recreates downloads_dir if it was removed (or unmounted)
+// while running, and watches it again: the watch went away with the directory.
+// It runs before every job.
+func (m *Manager) ensureDownloadsRoot() error {
+	m.rootMu.Lock()
+	defer m.rootMu.Unlock()
+	if info, err := os.Stat(m.downloadsRoot); err == nil && info.IsDir() {
+		return nil
+	}
+	logging.Warnf("downloads_dir %s is gone; recreating it", m.downloadsRoot)
+	if err := m.mkdir(m.downloadsRoot); err != nil {
+		logging.Errorf("cannot recreate downloads_dir %s: %v", m.downloadsRoot, err)
+		return fmt.Errorf("downloads_dir %s is missing and could not be recreated (%v); check that it is mounted and writable", m.downloadsRoot, err)
+	}
+	if m.Cfg.WatchMode != config.WatchModePoll {
+		if err := m.addWatch(m.downloadsRoot); err != nil {
+			logging.Warnf("cannot watch recreated downloads_dir %s: %v", m.downloadsRoot, err)
+		}
+	}
+	return nil
+}
+
+// inDir reports whether path is dir or inside it. A plain prefix check would
+// hand job 12's files to job 1.
+func inDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// claimFiles drops other jobs' records of path (or of the files under it)
+// before jobID records them. Each job writes into its own directory, so this
+// only happens with a shared output dir (an app writing to an absolute path,
+// say): the file belongs to the job whose directory contains it, and is
+// counted once. Callers hold filesMu.
+func (m *Manager) claimFiles(jobID int64, path string) {
+	others, err := store.ForeignJobFiles(m.DB, jobID, path)
+	if err != nil {
+		logging.Errorf("job %d: checking who owns %s: %v", jobID, m.toRel(path), err)
+		return
+	}
+	for _, f := range others {
+		logging.Warnf("job %d: %s was also recorded for job %d; it belongs to job %d, whose directory contains it", jobID, m.toRel(f.Path), f.JobID, jobID)
+		if err := store.DeleteJobFileByPath(m.DB, f.JobID, f.Path); err != nil {
+			logging.Errorf("job %d: dropping %s: %v", f.JobID, m.toRel(f.Path), err)
+			continue
+		}
+		m.markDirty(f.JobID)
+	}
+}
+
 func addRecursiveWatch(w *fsnotify.Watcher, root string) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -135,6 +207,45 @@ func addRecursiveWatch(w *fsnotify.Watcher, root string) error {
 	})
 }
 
+// watchFailed reports a directory that couldn't be watched and, if it belongs to
+// the running job, falls back to polling that job's directory so its files are
+// still recorded.
+func (m *Manager) watchFailed(jobID int64, dir string, err error) {
+	if errors.Is(err, syscall.ENOSPC) {
+		logging.Warnf("cannot watch %s: inotify watch limit reached; raise it with `sysctl fs.inotify.max_user_watches=524288` (falling back to polling)", m.toRel(dir))
+	} else {
+		logging.Warnf("cannot watch %s: %v (falling back to polling)", m.toRel(dir), err)
+	}
+
+	m.mu.Lock()
+	cur := m.current
+	m.mu.Unlock()
+	if cur == nil || cur.jobID != jobID || !inDir(dir, cur.jobDir) {
+		return
+	}
+	m.startPolling(cur)
+}
+
+// startPolling resyncs the job directory every pollInterval until the job ends.
+func (m *Manager) startPolling(cur *runningJob) {
+	cur.pollOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(m.pollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-cur.done:
+					return
+				case <-ticker.C:
+					if err := m.resyncJobFiles(cur.jobID, cur.jobDir); err != nil {
+						logging.Errorf("job %d: polling resync: %v", cur.jobID, err)
+					}
+				}
+			}
+		}()
+	})
+}
+
 func removeRecursiveWatch(w *fsnotify.Watcher, root string) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -147,6 +258,30 @@ func removeRecursiveWatch(w *fsnotify.Watcher, root string) error {
 	})
 }
 
+// queueScan schedules scanSiblings for dir unless a scan of it is already pending.
+// Callers may hold filesMu, so this never blocks.
+func (m *Manager) queueScan(jobID int64, dir string) {
+	m.pendingScansMu.Lock()
+	if m.pendingScans[dir] {
+		m.pendingScansMu.Unlock()
+		return
+	}
+	m.pendingScans[dir] = true
+	m.pendingScansMu.Unlock()
+
+	go func() {
+		m.scanSem <- struct{}{}
+		defer func() { <-m.scanSem }()
+
+		// Clear before scanning so events arriving mid-scan queue another pass.
+		m.pendingScansMu.Lock()
+		delete(m.pendingScans, dir)
+		m.pendingScansMu.Unlock()
+
+		m.scanSiblings(jobID, dir)
+	}()
+}
+
 func (m *Manager) scanSiblings(jobID int64, dir string) {
 	m.mu.Lock()
 	cur := m.current
@@ -157,25 +292,40 @@ func (m *Manager) scanSiblings(jobID int64, dir string) {
 	}
 
 	// Only scan if within current job's directory
-	if !strings.HasPrefix(dir, cur.jobDir) {
+	if !inDir(dir, cur.jobDir) {
 		return
 	}
 
+	m.filesMu.Lock()
+	defer m.filesMu.Unlock()
+
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return
 	}
 
+	var files []store.JobFile
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
-		fullPath := filepath.Join(dir, e.Name())
 		info, err := e.Info()
 		if err != nil {
 			continue
 		}
-		_ = store.InsertJobFile(m.DB, jobID, fullPath, info.Size(), info.ModTime())
+		files = append(files, store.JobFile{Path: filepath.Join(dir, e.Name()), SizeBytes: info.Size(), CreatedAt: info.ModTime()})
+	}
+	if m.overFileLimit(cur, 0) || cur.maxFiles > 0 && m.overFileLimit(cur, len(files)) {
+		return
+	}
+	m.claimFiles(jobID, dir)
+	if err := store.InsertJobFiles(m.DB, jobID, files); err != nil {
+		logging.Errorf("job %d: recording files in %s: %v", jobID, m.toRel(dir), err)
+	}
+	if cur.maxFiles > 0 {
+		if n, err := store.CountJobFiles(m.DB, jobID); err == nil {
+			m.overFileLimit(cur, n)
+		}
 	}
 	m.markDirty(jobID)
 }
@@ -0,0 +1,212 @@
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"low-tide/config"
+	"low-tide/store"
+)
+
+// startTestManager runs a real Manager (watcher, worker) against a temp dir.
+func startTestManager(t *testing.T, cfg *config.Config) (*Manager, *sql.DB) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db")+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := store.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	cfg.DownloadsDir = filepath.Join(dir, "downloads")
+	m, err := NewManager(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Watcher.Close() })
+	return m, db
+}
+
+// waitForFile polls the job's file records until one ends with suffix.
+func waitForFile(t *testing.T, db *sql.DB, jobID int64, suffix string, timeout time.Duration) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		files, _ := store.ListJobFiles(db, jobID)
+		for _, f := range files {
+			if strings.HasSuffix(f.Path, suffix) {
+				return true
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestWatchFailureFallsBackToPolling(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:      "slow",
+			Command: "sh",
+			// The sibling scan for "sub" runs right away; the file only appears after it.
+			Args: []string{"-c", "mkdir sub && sleep 0.5 && echo hi > sub/late.txt && sleep 10"},
+		}},
+	})
+	m.pollInterval = 50 * time.Millisecond
+	m.addWatch = func(root string) error {
+		if filepath.Base(root) == "sub" {
+			return syscall.ENOSPC
+		}
+		return addRecursiveWatch(m.Watcher, root)
+	}
+
+	jobID, err := store.InsertJob(db, "slow", "Slow", "http://example.com/", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Queue <- jobID
+	t.Cleanup(func() { m.CancelJob(jobID) })
+
+	if !waitForFile(t, db, jobID, "sub/late.txt", 5*time.Second) {
+		t.Fatal("file in an unwatchable directory was not recorded while the job was running")
+	}
+	if j, _ := store.GetJob(db, jobID); j.Status != store.StatusRunning {
+		t.Fatalf("expected the file to be picked up before the job finished, status is %s", j.Status)
+	}
+}
+
+func TestPollModeRecordsFilesWithoutEvents(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		WatchMode:    config.WatchModePoll,
+		PollInterval: 50 * time.Millisecond,
+		Apps: []config.AppConfig{{
+			ID:      "slow",
+			Command: "sh",
+			Args:    []string{"-c", "sleep 0.2 && echo hi > polled.txt && sleep 10"},
+		}},
+	})
+	if list := m.Watcher.WatchList(); len(list) != 0 {
+		t.Fatalf("expected no fsnotify watches in poll mode, got %v", list)
+	}
+
+	jobID, err := store.InsertJob(db, "slow", "Slow", "http://example.com/", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Queue <- jobID
+	t.Cleanup(func() { m.CancelJob(jobID) })
+
+	if !waitForFile(t, db, jobID, "/polled.txt", 5*time.Second) {
+		t.Fatal("file was not recorded in poll mode")
+	}
+	if j, _ := store.GetJob(db, jobID); j.Status != store.StatusRunning {
+		t.Fatalf("expected the file to be picked up while running, status is %s", j.Status)
+	}
+}
+
+func TestDownloadsRootRemovedWhileRunning(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:      "slow",
+			Command: "sh",
+			// The file shows up after the initial resync, so only the watch sees it.
+			Args: []string{"-c", "sleep 0.3 && echo hi > late.txt && sleep 10"},
+		}},
+	})
+	if err := os.RemoveAll(m.downloadsRoot); err != nil {
+		t.Fatal(err)
+	}
+	// fsnotify drops the watch once it has seen the directory go; only then is
+	// the job below the one that has to bring it back.
+	deadline := time.Now().Add(5 * time.Second)
+	for slices.Contains(m.Watcher.WatchList(), m.downloadsRoot) {
+		if time.Now().After(deadline) {
+			t.Fatal("the watcher did not see downloads_dir being removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	jobID, _ := store.InsertJob(db, "slow", "Slow", "http://example.com/", time.Now())
+	m.Queue <- jobID
+	t.Cleanup(func() { _ = m.CancelJob(jobID) })
+	if !waitForFile(t, db, jobID, "late.txt", 5*time.Second) {
+		t.Fatal("file in a job dir under the recreated downloads_dir was not picked up")
+	}
+}
+
+func TestDownloadsRootUnrecoverable(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		WatchMode: config.WatchModePoll,
+		Apps:      []config.AppConfig{{ID: "echo", Command: "sh", Args: []string{"-c", "echo x > out.txt"}}},
+	})
+	// A file where the directory was can't be replaced by one.
+	os.RemoveAll(m.downloadsRoot)
+	if err := os.WriteFile(m.downloadsRoot, []byte("not a dir"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jobID, _ := store.InsertJob(db, "echo", "Echo", "http://example.com/", time.Now())
+	m.Queue <- jobID
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, _ := store.GetJob(db, jobID)
+		if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			if j.Status != store.StatusFailed || j.ErrorMessage == nil || !strings.Contains(*j.ErrorMessage, "downloads_dir") {
+				t.Fatalf("expected a failure naming downloads_dir, got %s: %v", j.Status, j.ErrorMessage)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestFileAttributedToContainingJobDir(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:      "shared",
+			Command: "sh",
+			// Job 2 also writes into ../20, which a prefix check would call its own.
+			Args: []string{"-c", `echo a > mine.txt; d="../$(basename "$PWD")0"; mkdir "$d"; echo b > "$d/stray.txt"; sleep 0.5`},
+		}},
+	})
+	other, _ := store.InsertJob(db, "shared", "Other", "http://example.com/other", time.Now())
+	jobID, _ := store.InsertJob(db, "shared", "Shared", "http://example.com/shared", time.Now())
+	// A misconfigured earlier job already claimed the file job 2 is about to write.
+	mine := filepath.Join(m.downloadsRoot, fmt.Sprint(jobID), "mine.txt")
+	if err := store.InsertJobFile(db, other, mine, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Queue <- jobID
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, _ := store.GetJob(db, jobID)
+		if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	files, _ := store.ListJobFiles(db, jobID)
+	if len(files) != 1 || files[0].Path != mine {
+		t.Fatalf("expected job %d to own only %s, got %+v", jobID, mine, files)
+	}
+	if stale, _ := store.ListJobFiles(db, other); len(stale) != 0 {
+		t.Fatalf("expected the other job's claim to be dropped, got %+v", stale)
+	}
+}
@@ -3,39 +3,71 @@ package jobs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"low-tide/internal/logging"
 	"low-tide/internal/terminal"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"low-tide/config"
 	"low-tide/internal/chars"
+	"low-tide/internal/slug"
 	"low-tide/store"
 )
 
+// newTerminal returns a job terminal sized by terminal_rows and scrollback_lines.
+func (m *Manager) newTerminal() *terminal.Terminal {
+	rows, scrollback := m.Cfg.TerminalRows, m.Cfg.ScrollbackLines
+	if rows <= 0 {
+		rows = config.DefaultTerminalRows
+	}
+	if scrollback <= 0 {
+		scrollback = config.DefaultScrollbackLines
+	}
+	return terminal.NewWithScrollback(rows, scrollback)
+}
+
 func (m *Manager) runJob(jobID int64) {
 	j, err := store.GetJob(m.DB, jobID)
 	if err != nil {
-		log.Printf("worker: GetJob(%d) error: %v", jobID, err)
+		logging.Errorf("worker: GetJob(%d) error: %v", jobID, err)
 		return
 	}
-	log.Printf("worker: running job %d (status: %s)", jobID, j.Status)
+	logging.Infof("worker: running job %d (status: %s)", jobID, j.Status)
 
 	// Check if the job was cancelled while in the queue
 	if j.Status == store.StatusCancelled {
-		log.Printf("worker: job %d was cancelled while queued, skipping execution", jobID)
+		logging.Infof("worker: job %d was cancelled while queued, skipping execution", jobID)
+		return
+	}
+
+	if err := m.ensureDownloadsRoot(); err != nil {
+		_ = store.MarkJobFailed(m.DB, jobID, time.Now(), store.FailureInternal, err.Error(), j.Logs)
+		m.BroadcastJobSnapshot(jobID)
+		m.broadcastJobDone(jobID)
 		return
 	}
 
+	// The job dir is created here, before anything runs, so apps can always
+	// write into their cwd (or %o) without it being pre-created.
 	jobDir := filepath.Join(m.downloadsRoot, fmt.Sprintf("%d", jobID))
-	if err := os.MkdirAll(jobDir, 0o755); err != nil {
-		log.Printf("worker: failed to create job dir: %v", err)
+	if err := m.mkdir(jobDir); err != nil {
+		logging.Errorf("worker: failed to create job dir for job %d: %v", jobID, err)
+		_ = store.MarkJobFailed(m.DB, jobID, time.Now(), store.FailureInternal, fmt.Sprintf("failed to create job directory: %v", err), j.Logs)
+		m.BroadcastJobSnapshot(jobID)
+		m.broadcastJobDone(jobID)
 		return
 	}
 
@@ -43,7 +75,9 @@ func (m *Manager) runJob(jobID int64) {
 		jobID:     jobID,
 		startedAt: time.Now(),
 		jobDir:    jobDir,
-		term:      terminal.New(500),
+		term:      m.newTerminal(),
+		done:      make(chan struct{}),
+		maxFiles:  m.Cfg.MaxFilesFor(m.Cfg.GetApp(j.AppID)),
 	}
 	m.mu.Lock()
 	m.current = ctx
@@ -54,41 +88,55 @@ func (m *Manager) runJob(jobID int64) {
 	m.BroadcastJobSnapshot(jobID)
 
 	var failureMsg string
+	var failureKind store.FailureKind
 	success := true
 
 	// Initial resync (should be empty, but good for consistency)
 	if err := m.resyncJobFiles(jobID, ctx.jobDir); err != nil {
-		log.Printf("worker: initial resync job %d error: %v", jobID, err)
+		logging.Errorf("worker: initial resync job %d error: %v", jobID, err)
+	}
+	if m.Cfg.WatchMode == config.WatchModePoll {
+		m.startPolling(ctx)
 	}
 
+	// Run against the URL as submitted; j.URL may have been updated to the
+	// post-redirect URL learned while fetching metadata.
+	runURL := j.OriginalURL
+	if runURL == "" {
+		runURL = j.URL
+	}
 	appCfg := m.Cfg.GetApp(j.AppID)
 	if appCfg == nil {
-		log.Printf("worker: job %d failed, unknown app %s", jobID, j.AppID)
+		// The app was removed from the config while the job was queued.
+		logging.Warnf("worker: job %d failed, unknown app %s", jobID, j.AppID)
 		failureMsg = "unknown app: " + j.AppID
+		failureKind = store.FailureUnknownApp
 		success = false
-		m.BroadcastJobSnapshot(jobID)
-		m.clearCurrent(jobID, ctx)
-		return
-	}
-
-	if j.URL != "" {
-		err := m.runSingleURL(ctx, appCfg, j.URL)
+	} else if runURL != "" {
+		err := m.runSingleURL(ctx, appCfg, runURL)
 		if err != nil {
 			success = false
 			failureMsg = err.Error()
+			failureKind = failureKindOf(err)
 		}
 	}
 
 	// Final resync with filesystem
 	if err := m.resyncJobFiles(jobID, ctx.jobDir); err != nil {
-		log.Printf("worker: resync job %d error: %v", jobID, err)
+		logging.Errorf("worker: resync job %d error: %v", jobID, err)
+	}
+
+	if m.overFileLimit(ctx, 0) {
+		success = false
+		failureMsg = ctx.fileLimitMessage()
+		failureKind = store.FailureTooManyFiles
 	}
 
 	// check to see if any output files were created
 	if success && failureMsg == "" {
 		files, err := store.ListJobFiles(m.DB, jobID)
 		if err != nil {
-			log.Printf("worker: list files error: %v", err)
+			logging.Errorf("worker: list files error: %v", err)
 		} else {
 			hasContent := false
 			for _, f := range files {
@@ -100,10 +148,23 @@ func (m *Manager) runJob(jobID int64) {
 			if !hasContent {
 				success = false
 				failureMsg = "no output files found (or all empty)"
+				failureKind = store.FailureNoOutput
+				if len(ctx.outsidePaths) > 0 {
+					failureMsg += fmt.Sprintf("; the command was pointed outside the job directory (%s), use %%o instead", strings.Join(ctx.outsidePaths, ", "))
+				}
+				if ctx.urlUnused {
+					failureMsg += "; the app's args have no %u, so the command was never given the URL (add \"%u\" or set append_url: true)"
+				}
 			}
 		}
 	}
 
+	if success && appCfg.RenameToTitle {
+		if err := m.renameOutputToTitle(jobID, ctx.jobDir); err != nil {
+			logging.Errorf("worker: job %d rename to title failed: %v", jobID, err)
+		}
+	}
+
 	finished := time.Now()
 	duration := finished.Sub(ctx.startedAt).Round(time.Second)
 
@@ -122,10 +183,11 @@ func (m *Manager) runJob(jobID int64) {
 	} else {
 		summaryLine := chars.NewLine + fmt.Sprintf("\x1b[1;31m❌ --- Job finished: Failed (%s) (ran for %v) ---\x1b[0m", failureMsg, duration) + chars.NewLine
 		m.appendAndBroadcastLog(ctx, []byte(summaryLine))
-		_ = store.MarkJobFailed(m.DB, jobID, finished, failureMsg, ctx.term.RenderHTML())
+		_ = store.MarkJobFailed(m.DB, jobID, finished, failureKind, failureMsg, ctx.term.RenderHTML())
 	}
 
 	m.BroadcastJobSnapshot(jobID)
+	m.broadcastJobDone(jobID)
 	// Remove watches for the job directory as the job is finished.
 	// This helps in keeping the watcher clean and avoids leaking file descriptors.
 	if ctx.jobDir != "" {
@@ -134,47 +196,149 @@ func (m *Manager) runJob(jobID int64) {
 	m.clearCurrent(jobID, ctx)
 }
 
-func (m *Manager) runSingleURL(rj *runningJob, app *config.AppConfig, url string) error {
+// errMaxRuntime is returned by runSingleURL for a command killed by max_job_runtime.
+var errMaxRuntime = errors.New("exceeded global max runtime")
+
+// failureKindOf categorizes an error from runSingleURL. The cases runJob
+// detects itself (file limit, no output, unknown app) don't come through here.
+func failureKindOf(err error) store.FailureKind {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, errMaxRuntime):
+		return store.FailureTimeout
+	case errors.As(err, &exitErr):
+		return store.FailureExitNonzero
+	}
+	return store.FailureInternal
+}
+
+// ptyDrainTimeout bounds how long a finished command's remaining output is read.
+var ptyDrainTimeout = 2 * time.Second
+
+// ExpandArgs returns the args app's command is run with for url: %u is the URL,
+// %o the job directory the outputs belong in and %n the app's concurrency.
+func ExpandArgs(app *config.AppConfig, url, jobDir string) []string {
 	if app.StripTrailingSlash && strings.HasSuffix(url, "/") {
 		url = strings.TrimSuffix(url, "/")
 	}
-
-	args := make([]string, 0, len(app.Args))
+	args := make([]string, 0, len(app.Args)+1)
+	expand := strings.NewReplacer("%u", url, "%o", jobDir, "%n", strconv.Itoa(app.Concurrency))
 	for _, a := range app.Args {
-		args = append(args, strings.ReplaceAll(a, "%u", url))
+		args = append(args, expand.Replace(a))
+	}
+	if app.ShouldAppendURL() {
+		args = append(args, url)
+	}
+	return args
+}
+
+func (m *Manager) runSingleURL(rj *runningJob, app *config.AppConfig, url string) error {
+	args := ExpandArgs(app, url, rj.jobDir)
+	if rj.urlUnused = !app.TakesURL(); rj.urlUnused {
+		logging.Warnf("worker: job %d: app %s has no %%u in args, so the command isn't given the URL", rj.jobID, app.ID)
+	}
+	if rj.outsidePaths = pathsOutside(args, m.downloadsRoot); len(rj.outsidePaths) > 0 {
+		logging.Warnf("worker: job %d args point outside downloads_dir (%s); files written there won't be tracked", rj.jobID, strings.Join(rj.outsidePaths, ", "))
+	}
+
+	workDir := rj.jobDir
+	if app.ScratchDir {
+		scratch, err := os.MkdirTemp("", fmt.Sprintf("lowtide-job-%d-", rj.jobID))
+		if err != nil {
+			return fmt.Errorf("failed to create scratch dir: %w", err)
+		}
+		defer os.RemoveAll(scratch)
+		workDir = scratch
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	m.mu.Lock()
 	rj.cancel = cancel
+	m.mu.Unlock()
+	if m.Cfg.MaxJobRuntime > 0 {
+		// The global cap is a safety net for apps that hang; it kills the
+		// process the same way a cancel does but is reported as a failure.
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, m.Cfg.MaxJobRuntime)
+		defer stop()
+	}
 
 	cmd := exec.CommandContext(ctx, app.Command, args...)
+	// pty.Start puts the command in its own session, so its pid is also its
+	// process group id. Kill the whole group so helpers like ffmpeg don't outlive
+	// a cancelled job and end up as orphans (or zombies when we run as PID 1).
+	// With a kill grace the group gets SIGTERM first; exec SIGKILLs the command
+	// once WaitDelay passes, and the sweep after Wait takes care of the rest.
+	killGrace := m.Cfg.KillGraceFor(app)
+	m.mu.Lock()
+	rj.killGrace = killGrace
+	m.mu.Unlock()
+	cmd.Cancel = func() error { return signalProcessGroup(cmd, syscall.SIGKILL) }
+	if killGrace > 0 {
+		cmd.Cancel = func() error { return signalProcessGroup(cmd, syscall.SIGTERM) }
+		cmd.WaitDelay = killGrace
+	}
 	cmd.Env = os.Environ()
-	cmd.Dir = rj.jobDir
-	// Tell apps we are a terminal
-	cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+	cmd.Dir = workDir
+	// Tell apps we are a terminal; the app's env goes on top (and wins).
+	addedEnv := map[string]string{"TERM": "xterm-256color"}
+	maps.Copy(addedEnv, app.Env)
+	for _, name := range slices.Sorted(maps.Keys(addedEnv)) {
+		cmd.Env = append(cmd.Env, name+"="+addedEnv[name])
+	}
 	rj.cmd = cmd
 
 	f, err := pty.Start(cmd)
 	if err != nil {
 		return err
 	}
+	m.mu.Lock()
 	rj.pty = f
+	m.mu.Unlock()
 	defer f.Close()
 
 	// Set terminal size
-	_ = pty.Setsize(f, &pty.Winsize{Rows: 24, Cols: 100})
+	_ = pty.Setsize(f, &pty.Winsize{Rows: uint16(rj.term.Rows()), Cols: terminal.Width})
 
 	pid := cmd.Process.Pid
 	_ = store.UpdateJobPID(m.DB, rj.jobID, pid)
 
-	cmdLine := fmt.Sprintf("%s %s", app.Command, strings.Join(args, " "))
-	firstLine := "$ " + cmdLine + chars.NewLine + chars.CRLF
-	m.appendAndBroadcastLog(rj, []byte(firstLine))
+	cmdLine := ShellJoin(append([]string{app.Command}, args...))
+	_ = store.UpdateJobCommandLine(m.DB, rj.jobID, cmdLine)
+	runEnv := store.RunEnv{Command: cmd.Path, Args: args, Dir: workDir, Env: make(map[string]string, len(addedEnv)), Rows: rj.term.Rows(), Cols: terminal.Width}
+	for name, v := range addedEnv {
+		runEnv.Env[name] = logging.RedactEnv(name, v)
+	}
+	_ = store.UpdateJobRunEnv(m.DB, rj.jobID, runEnv)
+	if app.ShouldEchoCommand() {
+		firstLine := "$ " + cmdLine + chars.NewLine + chars.CRLF
+		m.appendAndBroadcastLog(rj, []byte(firstLine))
+	}
 
-	go m.streamRaw(ctx, rj.jobID, f, rj)
+	// Keep logging what the command prints while it cleans up after SIGTERM.
+	streamCtx := ctx
+	if killGrace > 0 {
+		streamCtx = context.WithoutCancel(ctx)
+	}
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		m.streamRaw(streamCtx, rj.jobID, f, rj)
+	}()
 
+	// Wait is the only place the process is reaped; CancelJob never waits on it.
 	err = cmd.Wait()
+	// Sweep up anything the command left running in its group. Linux doesn't
+	// reuse a pid while it is still a live group id, so this can't hit strangers.
+	_ = signalProcessGroup(cmd, syscall.SIGKILL)
+	// Output can still be buffered in the pty after a quick command exits; read
+	// it before the pty is closed. Something outside the group holding the pty
+	// open would keep it from reaching EOF, hence the bound.
+	select {
+	case <-streamed:
+	case <-time.After(ptyDrainTimeout):
+	}
 	exitCode := -1
 	if cmd.ProcessState != nil {
 		exitCode = cmd.ProcessState.ExitCode()
@@ -187,6 +351,12 @@ func (m *Manager) runSingleURL(rj *runningJob, app *config.AppConfig, url string
 	}
 	m.mu.Unlock()
 
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w (%s)", errMaxRuntime, m.Cfg.MaxJobRuntime)
+	}
+	if m.overFileLimit(rj, 0) {
+		return errors.New(rj.fileLimitMessage())
+	}
 	if ctx.Err() != nil {
 		return fmt.Errorf("cancelled")
 	}
@@ -197,6 +367,54 @@ func (m *Manager) runSingleURL(rj *runningJob, app *config.AppConfig, url string
 	return nil
 }
 
+// outputFlags are the flags whose value names where an app writes its files.
+var outputFlags = map[string]bool{"-o": true, "-P": true, "--output": true, "--paths": true}
+
+// pathsOutside returns the absolute paths given to outputFlags (as "-o /path",
+// "--output=/path" or yt-dlp's "--paths temp:/path") that aren't under root.
+// Apps that write there produce files the watcher never sees, so this is used
+// to explain jobs that seem to produce nothing. Other absolute args, like a
+// config file or cookie jar, are inputs and don't count.
+func pathsOutside(args []string, root string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		var v string
+		if flag, val, ok := strings.Cut(args[i], "="); ok && outputFlags[flag] {
+			v = val
+		} else if outputFlags[args[i]] && i+1 < len(args) {
+			i++
+			v = args[i]
+		} else {
+			continue
+		}
+		if _, p, ok := strings.Cut(v, ":"); ok && !filepath.IsAbs(v) {
+			v = p
+		}
+		if !filepath.IsAbs(v) {
+			continue
+		}
+		if rel, err := filepath.Rel(root, v); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ShellJoin renders argv as a command line that can be pasted into a POSIX shell.
+func ShellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		if a != "" && strings.IndexFunc(a, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+		}) == -1 {
+			quoted[i] = a
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
 func (m *Manager) streamRaw(ctx context.Context, jobID int64, r io.Reader, rj *runningJob) {
 	buf := make([]byte, 32*1024)
 	for {
@@ -206,9 +424,8 @@ func (m *Manager) streamRaw(ctx context.Context, jobID int64, r io.Reader, rj *r
 		default:
 			n, err := r.Read(buf)
 			if n > 0 {
-				data := make([]byte, n)
-				copy(data, buf[:n])
-				m.appendAndBroadcastLog(rj, data)
+				// term.Write copies synchronously, so buf can be reused right away.
+				m.appendAndBroadcastLog(rj, buf[:n])
 			}
 			if err != nil {
 				return
@@ -222,6 +439,16 @@ func (m *Manager) appendAndBroadcastLog(rj *runningJob, data []byte) {
 }
 
 func (m *Manager) resyncJobFiles(jobID int64, dir string) error {
+	m.filesMu.Lock()
+	defer m.filesMu.Unlock()
+
+	m.mu.Lock()
+	rj := m.current
+	m.mu.Unlock()
+	if rj != nil && rj.jobID != jobID {
+		rj = nil
+	}
+
 	existing, err := store.ListJobFiles(m.DB, jobID)
 	if err != nil {
 		return err
@@ -232,51 +459,183 @@ func (m *Manager) resyncJobFiles(jobID int64, dir string) error {
 	}
 
 	seen := make(map[string]struct{})
+	var files []store.JobFile
 	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
+		if path != dir {
+			m.applyFileMode(path, info)
+		}
 		if info.IsDir() {
 			return nil
 		}
+		if rj != nil && rj.maxFiles > 0 && len(files) == rj.maxFiles {
+			// Don't record the rest of a runaway job's files.
+			m.overFileLimit(rj, len(files)+1)
+			return filepath.SkipAll
+		}
 		seen[path] = struct{}{}
-		return store.InsertJobFile(m.DB, jobID, path, info.Size(), info.ModTime())
+		f := store.JobFile{Path: path, SizeBytes: info.Size(), CreatedAt: info.ModTime()}
+		f.SHA256 = m.contentHash(existingMap[path], f)
+		files = append(files, f)
+		return nil
 	})
 	if err != nil {
 		return err
 	}
 
+	var removed []string
 	for p := range existingMap {
 		if _, ok := seen[p]; !ok {
-			_ = store.DeleteJobFileByPath(m.DB, jobID, p)
+			removed = append(removed, p)
 		}
 	}
+	m.claimFiles(jobID, dir)
+	if err := store.SyncJobFiles(m.DB, jobID, files, removed); err != nil {
+		return err
+	}
 
 	m.markDirty(jobID)
 	return nil
 }
 
-func (m *Manager) CancelJob(jobID int64) error {
+// overFileLimit reports whether rj has gone over its max_files, given count
+// files found now (0 just checks). The first time, the job is stopped like a
+// cancel, but reported as a failure.
+func (m *Manager) overFileLimit(rj *runningJob, count int) bool {
 	m.mu.Lock()
-	isRunning := m.current != nil && m.current.jobID == jobID
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	if rj.tooManyFiles {
+		return true
+	}
+	if rj.maxFiles <= 0 || count <= rj.maxFiles {
+		return false
+	}
+	rj.tooManyFiles = true
+	logging.Warnf("worker: job %d created more than %d files (max_files), stopping it", rj.jobID, rj.maxFiles)
+	if rj.cancel != nil {
+		rj.cancel()
+	}
+	return true
+}
+
+func (rj *runningJob) fileLimitMessage() string {
+	return fmt.Sprintf("file count limit exceeded (max_files: %d)", rj.maxFiles)
+}
+
+// maxHashBytes is the largest file resyncJobFiles hashes; bigger files would
+// hold filesMu for too long and are left unhashed.
+const maxHashBytes = 64 << 20
+
+// contentHash returns the sha256 of f, reusing the stored hash when the file's
+// size and mtime haven't changed since it was recorded.
+func (m *Manager) contentHash(prev store.JobFile, f store.JobFile) string {
+	if prev.SHA256 != "" && prev.SizeBytes == f.SizeBytes && prev.CreatedAt.Equal(f.CreatedAt) {
+		return prev.SHA256
+	}
+	if f.SizeBytes > maxHashBytes {
+		return ""
+	}
+	sum, err := FileSHA256(f.Path)
+	if err != nil {
+		logging.Warnf("worker: failed to hash %s: %v", m.toRel(f.Path), err)
+		return ""
+	}
+	return sum
+}
+
+// FileSHA256 streams the file at path through sha256 and returns the hex digest.
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// renameOutputToTitle renames the largest file of a job to "{title-slug}{ext}",
+// picking a free name if a sibling already uses it.
+func (m *Manager) renameOutputToTitle(jobID int64, jobDir string) error {
+	j, err := store.GetJob(m.DB, jobID)
+	if err != nil {
+		return err
+	}
+
+	m.filesMu.Lock()
+	defer m.filesMu.Unlock()
+
+	files, err := store.ListJobFiles(m.DB, jobID)
+	if err != nil {
+		return err
+	}
+	var primary *store.JobFile
+	for i, f := range files {
+		if primary == nil || f.SizeBytes > primary.SizeBytes {
+			primary = &files[i]
+		}
+	}
+	if primary == nil {
+		return nil
+	}
+
+	ext := filepath.Ext(primary.Path)
+	base := slug.Parameterize(j.Title, fmt.Sprintf("job-%d", jobID), m.Cfg.TransliterateFilenames)
+	dir := filepath.Dir(primary.Path)
+	if !inDir(dir, jobDir) {
+		return fmt.Errorf("primary file %s is outside the job directory", primary.Path)
+	}
 
-	if isRunning {
-		m.mu.Lock()
-		defer m.mu.Unlock()
-		if m.current.cancel != nil {
-			m.current.cancel() // Cancel the running job
+	target := filepath.Join(dir, base+ext)
+	if target == primary.Path {
+		return nil
+	}
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
 		}
-		if m.current.pty != nil {
-			// Send SIGTERM to the process group if possible, or just the process
-			_ = m.current.pty.Close()
+		target = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, n, ext))
+	}
+
+	if err := os.Rename(primary.Path, target); err != nil {
+		return err
+	}
+	if err := store.RenameJobFile(m.DB, jobID, primary.Path, target, primary.SizeBytes, primary.CreatedAt); err != nil {
+		// Keep disk and DB consistent: undo the rename.
+		_ = os.Rename(target, primary.Path)
+		return err
+	}
+	logging.Infof("worker: job %d renamed %s to %s", jobID, m.toRel(primary.Path), m.toRel(target))
+	m.markDirty(jobID)
+	return nil
+}
+
+func (m *Manager) CancelJob(jobID int64) error {
+	// Check and cancel under one lock, so a job that finishes in between (or
+	// the next job the worker picks up) isn't mistaken for this one.
+	m.mu.Lock()
+	if rj := m.current; rj != nil && rj.jobID == jobID {
+		// Cancelling the context makes exec kill the process group (see
+		// runSingleURL); the worker's cmd.Wait reaps it. Closing the pty unblocks
+		// streamRaw if something still holds the terminal open.
+		if rj.cancel != nil {
+			logging.Infof("CancelJob %d: cancelling running job", jobID)
+			rj.cancel()
 		}
-		if m.current.cmd != nil && m.current.cmd.Process != nil {
-			log.Printf("CancelJob %d: killing process %d", jobID, m.current.cmd.Process.Pid)
-			_ = m.current.cmd.Process.Kill()
+		// Not with a kill grace though: the SIGHUP from the closing pty would cut
+		// the command's cleanup short.
+		if rj.pty != nil && rj.killGrace == 0 {
+			_ = rj.pty.Close()
 		}
+		m.mu.Unlock()
 		return nil
 	}
+	m.mu.Unlock()
 
 	j, err := store.GetJob(m.DB, jobID)
 	if err != nil {
@@ -290,7 +649,7 @@ func (m *Manager) CancelJob(jobID int64) error {
 	finished := time.Now()
 	_ = store.MarkJobCancelled(m.DB, jobID, finished, "[SYSTEM] Job cancelled while queued.")
 	m.BroadcastJobSnapshot(jobID)
-	log.Printf("CancelJob %d: cancelled queued job", jobID)
+	logging.Infof("CancelJob %d: cancelled queued job", jobID)
 
 	return nil
 }
@@ -0,0 +1,690 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"low-tide/config"
+	"low-tide/internal/terminal"
+	"low-tide/store"
+)
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		argv     []string
+		expected string
+	}{
+		{[]string{"yt-dlp", "-f", "bv*+ba/b", "https://youtu.be/x"}, `yt-dlp -f 'bv*+ba/b' https://youtu.be/x`},
+		{[]string{"curl", "https://ex.com/?a=1&b=2"}, `curl 'https://ex.com/?a=1&b=2'`},
+		{[]string{"sh", "-c", "echo it's"}, `sh -c 'echo it'\''s'`},
+		{[]string{"echo", ""}, `echo ''`},
+	}
+	for _, tt := range tests {
+		if got := ShellJoin(tt.argv); got != tt.expected {
+			t.Errorf("ShellJoin(%q) = %s; want %s", tt.argv, got, tt.expected)
+		}
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	app := &config.AppConfig{
+		Args:        []string{"-N", "%n", "--max-connection-per-server=%n", "-P", "%o", "%u"},
+		Concurrency: 4,
+	}
+	want := []string{"-N", "4", "--max-connection-per-server=4", "-P", "/dl/7", "http://example.com/v"}
+	if got := ExpandArgs(app, "http://example.com/v", "/dl/7"); !slices.Equal(got, want) {
+		t.Fatalf("ExpandArgs = %q; want %q", got, want)
+	}
+}
+
+// newResyncManager returns a Manager with just enough wiring for resyncJobFiles.
+func newResyncManager(tb testing.TB) (*Manager, int64) {
+	tb.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(tb.TempDir(), "test.db")+"?_fk=1")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := store.Init(db); err != nil {
+		tb.Fatal(err)
+	}
+	jobID, err := store.InsertJob(db, "test", "Test", "http://example.com/", time.Now())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	m := &Manager{DB: db, Cfg: &config.Config{}, jobChanges: make(map[int64]*jobChange)}
+	return m, jobID
+}
+
+func TestRecoverJobsRequeuesOldestFirst(t *testing.T) {
+	m, current := newResyncManager(t)
+	m.Queue = make(chan int64, 8)
+	now := time.Now()
+	// Row order differs from submission order.
+	newest, _ := store.InsertJob(m.DB, "test", "Newest", "http://example.com/newest", now.Add(time.Hour))
+	oldest, _ := store.InsertJob(m.DB, "test", "Oldest", "http://example.com/oldest", now.Add(-2*time.Hour))
+	older, _ := store.InsertJob(m.DB, "test", "Older", "http://example.com/older", now.Add(-time.Hour))
+
+	m.RecoverJobs()
+
+	want := []int64{oldest, older, current, newest}
+	for i, id := range want {
+		select {
+		case got := <-m.Queue:
+			if got != id {
+				t.Fatalf("position %d: expected job %d, got %d (want order %v)", i, id, got, want)
+			}
+		default:
+			t.Fatalf("expected %d jobs re-queued, got %d", len(want), i)
+		}
+	}
+}
+
+func TestResyncJobFiles(t *testing.T) {
+	m, jobID := newResyncManager(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644)
+	}
+	if err := m.resyncJobFiles(jobID, dir); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := store.ListJobFiles(m.DB, jobID)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+
+	// Grown and removed files are reflected on the next resync.
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a much longer body"), 0o644)
+	os.Remove(filepath.Join(dir, "sub/b.txt"))
+	if err := m.resyncJobFiles(jobID, dir); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = store.ListJobFiles(m.DB, jobID)
+	if len(files) != 1 || files[0].Path != filepath.Join(dir, "a.txt") || files[0].SizeBytes != int64(len("a much longer body")) {
+		t.Fatalf("unexpected files after resync: %+v", files)
+	}
+}
+
+func BenchmarkResyncJobFiles(b *testing.B) {
+	m, jobID := newResyncManager(b)
+	dir := b.TempDir()
+	for i := 0; i < 5000; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%02d", i%50))
+		os.MkdirAll(sub, 0o755)
+		os.WriteFile(filepath.Join(sub, fmt.Sprintf("f%04d", i)), nil, 0o644)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.resyncJobFiles(jobID, dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMaxJobRuntime(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		MaxJobRuntime: 300 * time.Millisecond,
+		Apps:          []config.AppConfig{{ID: "forever", Command: "sleep", Args: []string{"60"}}},
+	})
+	jobID, err := store.InsertJob(db, "forever", "Forever", "http://example.com/", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Queue <- jobID
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		j, err := store.GetJob(db, jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == store.StatusQueued || j.Status == store.StatusRunning {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if j.Status != store.StatusFailed || j.ErrorMessage == nil || !strings.Contains(*j.ErrorMessage, "exceeded global max runtime") {
+			t.Fatalf("expected failure for exceeding max runtime, got %s (%v)", j.Status, j.ErrorMessage)
+		}
+		return
+	}
+	t.Fatal("job was not stopped by max_job_runtime")
+}
+
+// procsMatching returns pids whose argv contains arg, and our own zombie children.
+func procsMatching(t *testing.T, arg string) (matches, zombies []string) {
+	t.Helper()
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		t.Skip("needs /proc")
+	}
+	self := fmt.Sprintf("PPid:\t%d\n", os.Getpid())
+	for _, e := range entries {
+		cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		for _, a := range strings.Split(string(cmdline), "\x00") {
+			if a == arg {
+				matches = append(matches, e.Name())
+			}
+		}
+		status, _ := os.ReadFile(filepath.Join("/proc", e.Name(), "status"))
+		if strings.Contains(string(status), "State:\tZ") && strings.Contains(string(status), self) {
+			zombies = append(zombies, e.Name())
+		}
+	}
+	return matches, zombies
+}
+
+func TestCancelReapsProcessGroup(t *testing.T) {
+	// The background sleep is a grandchild, like ffmpeg under yt-dlp, and ignores
+	// the SIGHUP sent when the pty closes.
+	const marker = "31.4159"
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "tree", Command: "sh", Args: []string{"-c", "trap '' HUP; sleep " + marker + " & sleep " + marker}}},
+	})
+
+	for i := 0; i < 5; i++ {
+		jobID, err := store.InsertJob(db, "tree", "Tree", "http://example.com/", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Queue <- jobID
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if j, _ := store.GetJob(db, jobID); j != nil && j.PID != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d never started", jobID)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err := m.CancelJob(jobID); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if j, _ := store.GetJob(db, jobID); j.Status == store.StatusCancelled {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d was not cancelled", jobID)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		left, zombies := procsMatching(t, marker)
+		if len(left) == 0 && len(zombies) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("processes left behind: %v, zombie children: %v", left, zombies)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStreamRawReusesBuffer(t *testing.T) {
+	m := &Manager{}
+	rj := &runningJob{term: terminal.New(500)}
+	// One byte per read overwrites the shared buffer constantly and splits the
+	// escape sequence, which the terminal has to hold on to between reads.
+	out := "first line\r\n\x1b[31mred\x1b[0m line\r\n"
+	m.streamRaw(context.Background(), 1, iotest.OneByteReader(strings.NewReader(out)), rj)
+
+	html := rj.term.RenderHTML()
+	for _, want := range []string{"first line", "red", " line"} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected %q in rendered output: %q", want, html)
+		}
+	}
+}
+
+func BenchmarkStreamRaw(b *testing.B) {
+	var chunk bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&chunk, "\r[download] \x1b[0;94m%5.1f%%\x1b[0m of 1.21GiB at 12.3MiB/s ETA 01:%02d", float64(i)/2, i%60)
+	}
+	fmt.Fprint(&chunk, "\r\n")
+	data := chunk.Bytes()
+	m := &Manager{}
+	rj := &runningJob{term: terminal.New(500)}
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.streamRaw(context.Background(), 1, bytes.NewReader(data), rj)
+	}
+}
+
+func TestScratchDirKeepsCacheOutOfJobFiles(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:         "scratch",
+			Command:    "sh",
+			Args:       []string{"-c", `echo cache > cache.ytdl && pwd > "$1/cwd.txt"`, "sh", "%o"},
+			ScratchDir: true,
+		}},
+	})
+	jobID, err := store.InsertJob(db, "scratch", "Scratch", "http://example.com/", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Queue <- jobID
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, _ := store.GetJob(db, jobID)
+		if j.Status == store.StatusSuccess {
+			break
+		}
+		if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			t.Fatalf("job ended as %s: %v", j.Status, j.ErrorMessage)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	files, _ := store.ListJobFiles(db, jobID)
+	if len(files) != 1 || filepath.Base(files[0].Path) != "cwd.txt" {
+		t.Fatalf("expected only cwd.txt as a job file, got %+v", files)
+	}
+	cwd, _ := os.ReadFile(files[0].Path)
+	scratch := strings.TrimSpace(string(cwd))
+	if strings.HasPrefix(scratch, m.downloadsRoot) {
+		t.Fatalf("command ran inside downloads_dir: %s", scratch)
+	}
+	if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+		t.Fatalf("expected scratch dir %s to be removed, got %v", scratch, err)
+	}
+}
+
+func TestRunJobCreatesJobDirFirst(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{
+			ID:      "check",
+			Command: "sh",
+			Args:    []string{"-c", `[ -d "$1" ] && [ "$(pwd)" = "$1" ] && echo ok > ok.txt`, "sh", "%o"},
+		}},
+	})
+	waitForStatus := func(jobID int64) *store.Job {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			j, _ := store.GetJob(db, jobID)
+			if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+				return j
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d did not finish", jobID)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	jobID, _ := store.InsertJob(db, "check", "Check", "http://example.com/", time.Now())
+	if _, err := os.Stat(filepath.Join(m.downloadsRoot, fmt.Sprint(jobID))); !os.IsNotExist(err) {
+		t.Fatalf("job dir should not exist before the job runs, got %v", err)
+	}
+	m.Queue <- jobID
+	if j := waitForStatus(jobID); j.Status != store.StatusSuccess {
+		t.Fatalf("expected the command to start inside its job dir, got %s: %v", j.Status, j.ErrorMessage)
+	}
+
+	// A job whose dir can't be created fails instead of staying queued forever.
+	blocked, _ := store.InsertJob(db, "check", "Check", "http://example.com/", time.Now())
+	os.WriteFile(filepath.Join(m.downloadsRoot, fmt.Sprint(blocked)), []byte("in the way"), 0o644)
+	m.Queue <- blocked
+	j := waitForStatus(blocked)
+	if j.Status != store.StatusFailed || j.ErrorMessage == nil || !strings.Contains(*j.ErrorMessage, "job directory") {
+		t.Fatalf("expected failure creating the job dir, got %s: %v", j.Status, j.ErrorMessage)
+	}
+}
+
+func TestOutputsOutsideDownloadsAreExplained(t *testing.T) {
+	outside := t.TempDir()
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "abs", Command: "sh", Args: []string{"-c", `echo x > "$2/out.txt"`, "sh", "-o", outside}}},
+	})
+	jobID, _ := store.InsertJob(db, "abs", "Abs", "http://example.com/", time.Now())
+	m.Queue <- jobID
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, _ := store.GetJob(db, jobID)
+		if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			if j.Status != store.StatusFailed || j.ErrorMessage == nil || !strings.Contains(*j.ErrorMessage, outside) {
+				t.Fatalf("expected failure naming %s, got %s: %v", outside, j.Status, j.ErrorMessage)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "out.txt")); err != nil {
+		t.Fatalf("expected the app to have written outside downloads_dir: %v", err)
+	}
+}
+
+func TestPathsOutside(t *testing.T) {
+	root := "/data/downloads"
+	args := []string{
+		"-P", "/data/downloads/7", "--output=/tmp/x", "-o", "/data/downloads-old/a", "-o", "relative/path",
+		"--paths", "temp:/scratch", "--paths=home:/data/downloads/7", "--cookies", "/etc/cookies.txt", "%u", "/data",
+	}
+	want := []string{"/tmp/x", "/data/downloads-old/a", "/scratch"}
+	if got := pathsOutside(args, root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("pathsOutside = %v; want %v", got, want)
+	}
+}
+
+func TestCancelRacingJobEnd(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "quick", Command: "sh", Args: []string{"-c", "echo ok > out.txt"}, FetchMetadata: new(bool)}},
+	})
+	first, _ := store.InsertJob(db, "quick", "Quick", "http://example.com/1", time.Now())
+	next, _ := store.InsertJob(db, "quick", "Quick", "http://example.com/2", time.Now())
+	m.Queue <- first
+	m.Queue <- next
+
+	// Cancelling the first job while it ends must never touch the next one.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_ = m.CancelJob(first)
+		if j, _ := store.GetJob(db, next); j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			if j.Status != store.StatusSuccess {
+				t.Fatalf("expected the next job to succeed, got %s", j.Status)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("jobs did not finish")
+		}
+	}
+}
+
+func TestKillGraceLetsCommandCleanUp(t *testing.T) {
+	const grace = 5 * time.Second
+	m, db := startTestManager(t, &config.Config{
+		KillGrace: grace,
+		Apps: []config.AppConfig{{
+			ID:      "graceful",
+			Command: "sh",
+			Args:    []string{"-c", `trap 'echo cleaned > cleaned.txt; exit 0' TERM; while :; do sleep 0.1; done`},
+		}},
+	})
+	jobID, _ := store.InsertJob(db, "graceful", "Graceful", "http://example.com/", time.Now())
+	m.Queue <- jobID
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if j, _ := store.GetJob(db, jobID); j != nil && j.PID != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give sh a moment to install its trap.
+	time.Sleep(200 * time.Millisecond)
+
+	cancelled := time.Now()
+	if err := m.CancelJob(jobID); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		j, _ := store.GetJob(db, jobID)
+		if j.Status == store.StatusCancelled {
+			if j.ExitCode == nil || *j.ExitCode != 0 {
+				t.Fatalf("expected the command to exit cleanly on SIGTERM, got exit code %v", j.ExitCode)
+			}
+			break
+		}
+		if time.Since(cancelled) > grace {
+			t.Fatal("job was not cancelled within the grace period")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(m.downloadsRoot, fmt.Sprint(jobID), "cleaned.txt")); err != nil {
+		t.Fatalf("expected the TERM trap to run: %v", err)
+	}
+}
+
+func TestMissingURLPlaceholder(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "forgot", Command: "sh", Args: []string{"-c", `[ -z "$1" ] || echo "$1" > out.txt`, "sh"}},
+			{ID: "appends", Command: "sh", Args: []string{"-c", `[ -z "$1" ] || echo "$1" > out.txt`, "sh"}, AppendURL: true},
+		},
+	})
+	forgot, _ := store.InsertJob(db, "forgot", "Forgot", "http://example.com/a", time.Now())
+	appends, _ := store.InsertJob(db, "appends", "Appends", "http://example.com/b", time.Now())
+	m.Queue <- forgot
+	m.Queue <- appends
+
+	wait := func(jobID int64) *store.Job {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			j, _ := store.GetJob(db, jobID)
+			if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+				return j
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d did not finish", jobID)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	if j := wait(forgot); j.Status != store.StatusFailed || j.ErrorMessage == nil || !strings.Contains(*j.ErrorMessage, "%u") {
+		t.Fatalf("expected a failure explaining the missing %%u, got %s: %v", j.Status, j.ErrorMessage)
+	}
+	if j := wait(appends); j.Status != store.StatusSuccess {
+		t.Fatalf("expected append_url to pass the URL, got %s: %v", j.Status, j.ErrorMessage)
+	}
+	out, _ := os.ReadFile(filepath.Join(m.downloadsRoot, fmt.Sprint(appends), "out.txt"))
+	if string(out) != "http://example.com/b\n" {
+		t.Fatalf("expected the URL as the last arg, got %q", out)
+	}
+}
+
+func TestMaxFilesStopsJob(t *testing.T) {
+	for _, mode := range []string{config.WatchModeFsnotify, config.WatchModePoll} {
+		t.Run(mode, func(t *testing.T) {
+			m, db := startTestManager(t, &config.Config{
+				WatchMode:    mode,
+				PollInterval: 50 * time.Millisecond,
+				MaxFiles:     1000,
+				Apps: []config.AppConfig{{
+					ID:       "spray",
+					Command:  "sh",
+					Args:     []string{"-c", `i=0; while [ $i -lt 100000 ]; do echo x > f$i; i=$((i+1)); [ $((i % 10)) -eq 0 ] && sleep 0.01; done`},
+					MaxFiles: 20,
+				}},
+			})
+			jobID, _ := store.InsertJob(db, "spray", "Spray", "http://example.com/", time.Now())
+			m.Queue <- jobID
+
+			deadline := time.Now().Add(10 * time.Second)
+			var j *store.Job
+			for {
+				j, _ = store.GetJob(db, jobID)
+				if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+					break
+				}
+				if time.Now().After(deadline) {
+					_ = m.CancelJob(jobID)
+					t.Fatal("job was not stopped")
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			if j.Status != store.StatusFailed || j.ErrorMessage == nil || !strings.Contains(*j.ErrorMessage, "file count limit exceeded") {
+				t.Fatalf("expected a file count failure, got %s: %v", j.Status, j.ErrorMessage)
+			}
+			if n, _ := store.CountJobFiles(db, jobID); n > 20 {
+				t.Fatalf("expected at most 20 recorded files, got %d", n)
+			}
+		})
+	}
+}
+
+func TestRunEnvRecorded(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		TerminalRows: 30,
+		Apps: []config.AppConfig{{
+			ID:      "env",
+			Command: "sh",
+			Args:    []string{"-c", `echo "$QUALITY $API_TOKEN" > out.txt`},
+			Env:     map[string]string{"QUALITY": "best", "API_TOKEN": "hunter2"},
+		}},
+	})
+	jobID, _ := store.InsertJob(db, "env", "Env", "http://example.com/", time.Now())
+	m.Queue <- jobID
+
+	deadline := time.Now().Add(5 * time.Second)
+	var j *store.Job
+	for {
+		j, _ = store.GetJob(db, jobID)
+		if j.Status != store.StatusQueued && j.Status != store.StatusRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	jobDir := filepath.Join(m.downloadsRoot, fmt.Sprint(jobID))
+	out, _ := os.ReadFile(filepath.Join(jobDir, "out.txt"))
+	if string(out) != "best hunter2\n" {
+		t.Fatalf("expected the command to get the app's env, got %q", out)
+	}
+
+	env := j.RunEnv
+	if env == nil {
+		t.Fatal("expected run_env to be recorded")
+	}
+	if !filepath.IsAbs(env.Command) || filepath.Base(env.Command) != "sh" || len(env.Args) != 2 || env.Dir != jobDir {
+		t.Errorf("unexpected command context: %+v", env)
+	}
+	if env.Rows != 30 || env.Cols != terminal.Width {
+		t.Errorf("expected a 30x%d terminal, got %dx%d", terminal.Width, env.Rows, env.Cols)
+	}
+	want := map[string]string{"QUALITY": "best", "API_TOKEN": "REDACTED", "TERM": "xterm-256color"}
+	if !maps.Equal(env.Env, want) {
+		t.Errorf("expected env %v, got %v", want, env.Env)
+	}
+}
+
+func TestFailureKinds(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		MaxJobRuntime: 300 * time.Millisecond,
+		Apps: []config.AppConfig{
+			{ID: "exit", Command: "sh", Args: []string{"-c", "echo x > out.txt; exit 3"}},
+			{ID: "slow", Command: "sleep", Args: []string{"10"}},
+			{ID: "empty", Command: "true"},
+			{ID: "spray", Command: "sh", Args: []string{"-c", "echo x > a; echo x > b; echo x > c"}, MaxFiles: 2},
+			{ID: "missing", Command: "/nonexistent/command"},
+		},
+	})
+	tests := []struct {
+		appID string
+		want  store.FailureKind
+	}{
+		{"exit", store.FailureExitNonzero},
+		{"slow", store.FailureTimeout},
+		{"empty", store.FailureNoOutput},
+		{"spray", store.FailureTooManyFiles},
+		{"removed", store.FailureUnknownApp},
+		{"missing", store.FailureInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.appID, func(t *testing.T) {
+			jobID, _ := store.InsertJob(db, tt.appID, tt.appID, "http://example.com/", time.Now())
+			m.Queue <- jobID
+			deadline := time.Now().Add(5 * time.Second)
+			var j *store.Job
+			for {
+				j, _ = store.GetJob(db, jobID)
+				if j.Status.Finished() {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("job did not finish")
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			if j.Status != store.StatusFailed || j.FailureKind != tt.want {
+				t.Fatalf("expected a %s failure, got %s/%q: %v", tt.want, j.Status, j.FailureKind, j.ErrorMessage)
+			}
+		})
+	}
+
+	// Retrying clears it.
+	jobs, _ := store.ListJobsByStatus(db, store.StatusFailed)
+	if err := store.ResetJobForRetry(db, jobs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := store.GetJob(db, jobs[0].ID); j.FailureKind != "" {
+		t.Fatalf("expected retry to clear the failure kind, got %q", j.FailureKind)
+	}
+}
+
+func TestEchoCommand(t *testing.T) {
+	quiet := false
+	args := []string{"-c", "echo tool-output; echo x > out.txt"}
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "echoed", Command: "sh", Args: args},
+			{ID: "quiet", Command: "sh", Args: args, EchoCommand: &quiet},
+		},
+	})
+	echoed, _ := store.InsertJob(db, "echoed", "Echoed", "http://example.com/a", time.Now())
+	silent, _ := store.InsertJob(db, "quiet", "Quiet", "http://example.com/b", time.Now())
+	m.Queue <- echoed
+	m.Queue <- silent
+
+	wait := func(jobID int64) *store.Job {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			j, _ := store.GetJob(db, jobID)
+			if j.Status.Finished() {
+				return j
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d did not finish", jobID)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	if j := wait(echoed); !strings.Contains(j.Logs, "$ sh -c") {
+		t.Fatalf("expected the command to be echoed by default, got %q", j.Logs)
+	}
+	j := wait(silent)
+	if strings.Contains(j.Logs, "$ sh -c") || !strings.Contains(j.Logs, "tool-output") {
+		t.Fatalf("expected only the tool's output with echo_command: false, got %q", j.Logs)
+	}
+	if !strings.HasPrefix(j.CommandLine, "sh -c") {
+		t.Fatalf("expected the command line to still be recorded, got %q", j.CommandLine)
+	}
+}
@@ -4,6 +4,7 @@ package jobs
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"os"
 	"os/exec"
@@ -14,6 +15,7 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"low-tide/config"
 	"low-tide/internal/chars"
+	"low-tide/internal/logging"
 	"low-tide/internal/terminal"
 	"low-tide/store"
 )
@@ -27,14 +29,59 @@ type Manager struct {
 
 	mu      sync.Mutex
 	current *runningJob
+	// busy is set (under mu) from the moment the worker takes a job off the queue
+	// until it has finished with it, which covers the gap before current is set.
+	busy bool
+	// paused holds the worker off the queue until Resume; held is set while it
+	// waits with a job it already took off the queue. resumed signals (under mu)
+	// when paused is cleared.
+	paused  bool
+	held    bool
+	resumed *sync.Cond
+
+	// runMu is held by the worker for the duration of each job so maintenance
+	// (see WhileIdle) never overlaps with a running download.
+	runMu sync.Mutex
+
+	// filesMu serializes recording job files (watcher events, sibling scans,
+	// resyncs, renames) so a stale stat can't resurrect a moved/deleted path.
+	filesMu sync.Mutex
+
+	// scanSem bounds concurrent sibling scans; pendingScans drops duplicate scans
+	// of a directory that is already queued, which is common during event storms.
+	scanSem        chan struct{}
+	pendingScans   map[string]bool
+	pendingScansMu sync.Mutex
+
+	// metadataSem bounds concurrent metadata fetches, so pasting a large batch
+	// doesn't open a socket per URL at once.
+	metadataSem chan struct{}
+
+	// rootMu serializes recreating downloads_dir after it was removed.
+	rootMu sync.Mutex
+
+	// addWatch adds fsnotify watches for a new directory tree; swapped out in tests.
+	addWatch func(root string) error
+	// pollInterval is how often a job dir is rescanned when it can't be watched.
+	pollInterval time.Duration
 
 	stateSubs      map[chan []byte]struct{}
 	stateSubsMutex sync.Mutex
+	// stateSeq numbers broadcast events (guarded by stateSubsMutex). It starts at
+	// zero on every boot, so clients pair it with the server's boot time.
+	stateSeq uint64
 
 	jobChanges   map[int64]*jobChange
 	jobChangesMu sync.Mutex
 }
 
+// maxConcurrentScans caps sibling scans running at once. Scans also serialize on
+// filesMu, so this mostly limits goroutines queued up behind it.
+const maxConcurrentScans = 4
+
+// defaultPollInterval is used when a job directory has to be polled.
+const defaultPollInterval = 2 * time.Second
+
 type runningJob struct {
 	jobID     int64
 	term      *terminal.Terminal
@@ -43,9 +90,30 @@ type runningJob struct {
 	pty       *os.File
 	cmd       *exec.Cmd
 	cancel    context.CancelFunc
+	// killGrace is how long the command gets after SIGTERM when cancelled.
+	killGrace time.Duration
+	// outsidePaths are absolute output paths in the command's args that point
+	// outside downloads_dir; anything written there isn't tracked.
+	outsidePaths []string
+	// urlUnused is set when the app's args don't pass the URL to the command.
+	urlUnused bool
+	// maxFiles is the app's file count limit (0: none). tooManyFiles is set,
+	// under mu, once the job went over it and was stopped.
+	maxFiles     int
+	tooManyFiles bool
+
+	// done is closed when the job stops being current; pollOnce guards the
+	// fallback poller so it starts at most once per job.
+	done     chan struct{}
+	pollOnce sync.Once
 }
 
 func NewManager(db *sql.DB, cfg *config.Config) (*Manager, error) {
+	// Job dirs live under downloads_dir and the watcher covers that same tree, so an
+	// empty value would silently watch (and write into) the working directory.
+	if cfg.DownloadsDir == "" {
+		return nil, errors.New("downloads_dir is not set")
+	}
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -57,8 +125,11 @@ func NewManager(db *sql.DB, cfg *config.Config) (*Manager, error) {
 	if err := os.MkdirAll(downloadsRoot, 0o755); err != nil {
 		return nil, err
 	}
-	if err := addRecursiveWatch(w, downloadsRoot); err != nil {
-		return nil, err
+	polling := cfg.WatchMode == config.WatchModePoll
+	if !polling {
+		if err := addRecursiveWatch(w, downloadsRoot); err != nil {
+			return nil, err
+		}
 	}
 
 	m := &Manager{
@@ -69,10 +140,27 @@ func NewManager(db *sql.DB, cfg *config.Config) (*Manager, error) {
 		stateSubs:     make(map[chan []byte]struct{}), // used for websocket subscribers
 		jobChanges:    make(map[int64]*jobChange),     // used to keep track of dirty jobs
 		downloadsRoot: downloadsRoot,
+		scanSem:       make(chan struct{}, maxConcurrentScans),
+		pendingScans:  make(map[string]bool),
+		pollInterval:  defaultPollInterval,
+	}
+	metadataConcurrency := cfg.MetadataConcurrency
+	if metadataConcurrency <= 0 {
+		metadataConcurrency = config.DefaultMetadataConcurrency
+	}
+	m.metadataSem = make(chan struct{}, metadataConcurrency)
+	m.resumed = sync.NewCond(&m.mu)
+	m.addWatch = func(root string) error { return addRecursiveWatch(w, root) }
+	if cfg.PollInterval > 0 {
+		m.pollInterval = cfg.PollInterval
 	}
 
-	go m.watchLoop()
-	log.Printf("job manager started; downloads root: %s", downloadsRoot)
+	if polling {
+		logging.Infof("job manager: polling job dirs every %s instead of watching", m.pollInterval)
+	} else {
+		go m.watchLoop()
+	}
+	logging.Infof("job manager started; downloads root: %s", downloadsRoot)
 	go m.worker()
 	go m.filesPublisher()
 	go m.logPublisher()
@@ -85,8 +173,90 @@ func (m *Manager) worker() {
 		if jobID == 0 {
 			continue
 		}
+		m.waitWhilePaused()
+		m.setBusy(true)
+		m.runMu.Lock()
 		m.runJob(jobID)
+		m.runMu.Unlock()
+		m.setBusy(false)
+		if len(m.Queue) == 0 {
+			m.BroadcastState(QueueIdleEvent{Type: "queue_idle", At: time.Now()})
+		}
+	}
+}
+
+func (m *Manager) setBusy(busy bool) {
+	m.mu.Lock()
+	m.busy = busy
+	m.mu.Unlock()
+}
+
+// waitWhilePaused blocks the worker, holding its next job, until Resume.
+func (m *Manager) waitWhilePaused() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.paused {
+		m.held = true
+		m.resumed.Wait()
 	}
+	m.held = false
+}
+
+// Pause stops the worker from starting new jobs. A running job finishes normally
+// and new submissions keep queuing.
+func (m *Manager) Pause() {
+	m.mu.Lock()
+	m.paused = true
+	m.mu.Unlock()
+	logging.Infof("worker: paused")
+}
+
+// Resume lets the worker continue with the queue.
+func (m *Manager) Resume() {
+	m.mu.Lock()
+	m.paused = false
+	m.resumed.Broadcast()
+	m.mu.Unlock()
+	logging.Infof("worker: resumed")
+}
+
+// QueueStatus summarizes the worker's backlog for GET /api/queue.
+type QueueStatus struct {
+	Queued  int  `json:"queued"`
+	Running int  `json:"running"`
+	Idle    bool `json:"idle"`
+	Paused  bool `json:"paused"`
+}
+
+// QueueStatus reports how many jobs are waiting and whether one is running.
+// Queued counts entries in the queue channel, which may include jobs that were
+// cancelled while waiting; the worker skips those when it reaches them.
+func (m *Manager) QueueStatus() QueueStatus {
+	m.mu.Lock()
+	busy, held, paused := m.busy, m.held, m.paused
+	m.mu.Unlock()
+	st := QueueStatus{Queued: len(m.Queue), Paused: paused}
+	if held {
+		st.Queued++
+	}
+	if busy {
+		st.Running = 1
+	}
+	st.Idle = st.Queued == 0 && st.Running == 0
+	return st
+}
+
+// ErrBusy is returned by WhileIdle when a job is currently running.
+var ErrBusy = errors.New("a job is running")
+
+// WhileIdle runs fn while no job is running, holding off the worker until fn
+// returns. It fails fast with ErrBusy rather than waiting for the current job.
+func (m *Manager) WhileIdle(fn func() error) error {
+	if !m.runMu.TryLock() {
+		return ErrBusy
+	}
+	defer m.runMu.Unlock()
+	return fn()
 }
 
 // runs on startup
@@ -96,28 +266,79 @@ func (m *Manager) RecoverJobs() {
 		log.Fatalf("recovery: failed to list running jobs: %v", err)
 	} else {
 		for _, j := range running {
-			log.Printf("recovery: marking running job %d as cancelled", j.ID)
+			logging.Infof("recovery: marking running job %d as cancelled", j.ID)
 			finished := time.Now()
 			// We don't have the terminal state, so we just use the existing logs if any
 			_ = store.MarkJobCancelled(m.DB, j.ID, finished, j.Logs+chars.NewLine+"[SYSTEM] Job cancelled due to server restart.")
 		}
 	}
 
+	m.recoverMetadata()
+
 	queued, err := store.ListJobsByStatus(m.DB, store.StatusQueued)
 	if err != nil {
 		log.Fatalf("recovery: failed to list queued jobs: %v", err)
 	} else {
+		// Oldest first, so a restart doesn't reshuffle a batch.
 		for _, j := range queued {
-			log.Printf("recovery: re-queuing job %d", j.ID)
+			logging.Infof("recovery: re-queuing job %d", j.ID)
 			m.Queue <- j.ID
 		}
 	}
 }
 
+// recoverMetadata restarts metadata fetches that a restart interrupted. They run
+// in the background behind the usual metadata concurrency limit.
+func (m *Manager) recoverMetadata() {
+	pending, err := store.ListJobsPendingMetadata(m.DB)
+	if err != nil {
+		logging.Errorf("recovery: failed to list jobs pending metadata: %v", err)
+		return
+	}
+	for _, j := range pending {
+		if app := m.Cfg.GetApp(j.AppID); app == nil || !app.ShouldFetchMetadata() {
+			_ = store.MarkMetadataFetched(m.DB, j.ID)
+			continue
+		}
+		logging.Infof("recovery: resuming metadata fetch for job %d", j.ID)
+		go m.FetchAndSaveMetadata(j.ID, j.OriginalURL)
+	}
+}
+
+// mkdir creates dir with the configured dir_mode. The mode is applied with an
+// explicit chmod so the process umask can't strip group bits.
+func (m *Manager) mkdir(dir string) error {
+	if err := os.MkdirAll(dir, m.Cfg.DirMode.Or(0o755)); err != nil {
+		return err
+	}
+	if m.Cfg.DirMode != 0 {
+		return os.Chmod(dir, os.FileMode(m.Cfg.DirMode))
+	}
+	return nil
+}
+
+// applyFileMode sets file_mode (or dir_mode for directories) on a path created by
+// a job's command. It's a no-op when the relevant mode isn't configured.
+func (m *Manager) applyFileMode(path string, info os.FileInfo) {
+	mode := m.Cfg.FileMode
+	if info.IsDir() {
+		mode = m.Cfg.DirMode
+	}
+	if mode == 0 || info.Mode().Perm() == os.FileMode(mode) {
+		return
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		logging.Warnf("chmod %s: %v", m.toRel(path), err)
+	}
+}
+
 func (m *Manager) clearCurrent(jobID int64, ctx *runningJob) {
 	m.mu.Lock()
 	if m.current == ctx {
 		m.current = nil
 	}
 	m.mu.Unlock()
+	if ctx.done != nil {
+		close(ctx.done)
+	}
 }
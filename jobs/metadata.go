@@ -2,47 +2,106 @@
 package jobs
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
-	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	nethtml "golang.org/x/net/html"
+	"low-tide/config"
+	"low-tide/internal/logging"
 	"low-tide/store"
 )
 
 // FetchAndSaveMetadata attempts to fetch the page at url, parse the title/og:title and og:image,
-// download the image if found, and update the job in the DB.
+// download the image if found, and update the job in the DB. It waits for a free
+// metadata slot first, so callers can start it in a goroutine per job.
 func (m *Manager) FetchAndSaveMetadata(jobID int64, urlStr string) {
-	metadata, err := fetchMetadata(urlStr)
+	m.fetchAndSaveMetadata(jobID, urlStr, false)
+}
+
+// RefetchMetadata is like FetchAndSaveMetadata, but sends the cache validators from the
+// job's previous fetch and keeps its title/image if the page reports 304 Not Modified.
+func (m *Manager) RefetchMetadata(jobID int64, urlStr string) {
+	m.fetchAndSaveMetadata(jobID, urlStr, true)
+}
+
+func (m *Manager) fetchAndSaveMetadata(jobID int64, urlStr string, conditional bool) {
+	if m.metadataSem != nil {
+		m.metadataSem <- struct{}{}
+		defer func() { <-m.metadataSem }()
+	}
+	// Whatever the outcome, this attempt is over; only fetches interrupted by a
+	// restart are picked up again by RecoverJobs.
+	defer func() {
+		if err := store.MarkMetadataFetched(m.DB, jobID); err != nil {
+			logging.Errorf("metadata: failed to mark job %d as fetched: %v", jobID, err)
+		}
+	}()
+
+	var validators *store.MetadataValidators
+	if conditional {
+		v, err := store.GetMetadataValidators(m.DB, jobID)
+		if err != nil {
+			logging.Warnf("metadata: failed to load cache validators for job %d: %v", jobID, err)
+		}
+		validators = v
+	}
+
+	metadata, err := fetchMetadata(urlStr, m.Cfg.MetadataFullScan, validators)
+	if errors.Is(err, errNotModified) {
+		logging.Debugf("metadata: %s not modified, keeping existing metadata for job %d", logging.RedactURL(urlStr), jobID)
+		return
+	}
 	if err != nil {
-		log.Printf("metadata: failed to fetch metadata for job %d (%s): %v", jobID, urlStr, err)
+		logging.Warnf("metadata: failed to fetch metadata for job %d (%s): %v", jobID, logging.RedactURL(urlStr), logging.RedactError(err))
 		return
 	}
 
+	v := store.MetadataValidators{ETag: metadata.ETag, LastModified: metadata.LastModified}
+	if err := store.SaveMetadataValidators(m.DB, jobID, v); err != nil {
+		logging.Errorf("metadata: failed to save cache validators: %v", err)
+	}
+
+	if metadata.FinalURL != "" && metadata.FinalURL != urlStr {
+		logging.Debugf("metadata: job %d redirected to %s", jobID, logging.RedactURL(metadata.FinalURL))
+		if err := store.UpdateJobFinalURL(m.DB, jobID, metadata.FinalURL); err != nil {
+			logging.Errorf("metadata: failed to update final url db: %v", err)
+		}
+	}
+
 	if metadata.Title != "" {
-		log.Printf("metadata: found title for job %d: %q", jobID, metadata.Title)
+		logging.Debugf("metadata: found title for job %d: %q", jobID, metadata.Title)
 		if err := store.UpdateJobTitle(m.DB, jobID, metadata.Title); err != nil {
-			log.Printf("metadata: failed to update title db: %v", err)
+			logging.Errorf("metadata: failed to update title db: %v", err)
 		}
 	}
 
 	if metadata.ImageURL != "" {
 		imagePath, err := m.downloadAndSaveImage(jobID, metadata.ImageURL)
 		if err != nil {
-			log.Printf("metadata: failed to download image for job %d (%s): %v", jobID, metadata.ImageURL, err)
+			logging.Warnf("metadata: failed to download image for job %d (%s): %v", jobID, truncate(logging.RedactURL(metadata.ImageURL), 100), logging.RedactError(err))
 		} else if imagePath != "" {
-			log.Printf("metadata: saved image for job %d: %s", jobID, imagePath)
+			logging.Debugf("metadata: saved image for job %d: %s", jobID, imagePath)
 			if err := store.UpdateJobImagePath(m.DB, jobID, imagePath); err != nil {
-				log.Printf("metadata: failed to update image path db: %v", err)
+				logging.Errorf("metadata: failed to update image path db: %v", err)
 			}
 		}
 	}
@@ -53,47 +112,126 @@ func (m *Manager) FetchAndSaveMetadata(jobID int64, urlStr string) {
 type Metadata struct {
 	Title    string
 	ImageURL string
+	// FinalURL is the page URL after following redirects.
+	FinalURL string
+
+	// HTTP cache validators from the page response, used for conditional refetches.
+	ETag         string
+	LastModified string
 }
 
-// downloadAndSaveImage downloads an image from the given URL and saves it to the thumbnails directory
-func (m *Manager) downloadAndSaveImage(jobID int64, imageURL string) (string, error) {
-	thumbnailsDir := filepath.Join(m.downloadsRoot, "thumbnails")
-	if err := os.MkdirAll(thumbnailsDir, 0o755); err != nil {
-		return "", fmt.Errorf("failed to create thumbnails directory: %v", err)
+var errNotModified = errors.New("not modified")
+
+// minThumbnailBytes rejects responses too small to be a useful image
+// (empty bodies, tracking pixels, or error pages misreported as images).
+const minThumbnailBytes = 100
+
+var (
+	ErrNotImage      = errors.New("content is not a supported image")
+	ErrImageTooLarge = errors.New("image exceeds size limit")
+	ErrImageTooSmall = errors.New("image is suspiciously small")
+)
+
+// validateImage makes sure data really is an image of the given type, so error
+// pages served with an image content type never end up as thumbnails.
+func validateImage(data []byte, ext string) error {
+	switch ext {
+	case ".svg":
+		// SVG isn't decodable by the image package; sniff for an <svg> root instead.
+		head := bytes.ToLower(data[:min(len(data), 1024)])
+		if !bytes.Contains(head, []byte("<svg")) {
+			return ErrNotImage
+		}
+		return nil
+	case ".webp":
+		// No webp decoder in the standard library; check the RIFF container header.
+		if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+			return ErrNotImage
+		}
+		return nil
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotImage, err)
 	}
+	return nil
+}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+// MaxThumbnailBytes returns the configured thumbnail size cap.
+func (m *Manager) MaxThumbnailBytes() int64 {
+	if m.Cfg == nil || m.Cfg.MaxThumbnailBytes <= 0 {
+		return config.DefaultMaxThumbnailBytes
 	}
+	return m.Cfg.MaxThumbnailBytes
+}
 
-	resp, err := client.Get(imageURL)
+// readThumbnail reads at most the configured cap from r, failing instead of truncating.
+func (m *Manager) readThumbnail(r io.Reader) ([]byte, error) {
+	limit := m.MaxThumbnailBytes()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
 	if err != nil {
-		return "", fmt.Errorf("failed to download image: %v", err)
+		return nil, fmt.Errorf("failed to read image: %w", err)
 	}
-	defer resp.Body.Close()
+	if int64(len(data)) > limit {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
 
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("image download failed with status code %d", resp.StatusCode)
+// SaveUploadedThumbnail stores a user-provided image as the job's thumbnail,
+// replacing any previously downloaded one, and broadcasts the updated job.
+func (m *Manager) SaveUploadedThumbnail(jobID int64, r io.Reader) (string, error) {
+	data, err := m.readThumbnail(r)
+	if err != nil {
+		return "", err
 	}
 
-	ext := getImageExtension(resp.Header.Get("Content-Type"), imageURL)
+	ext := getImageExtension(http.DetectContentType(data), "")
 	if ext == "" {
-		return "", fmt.Errorf("unsupported image type")
+		return "", ErrNotImage
+	}
+	if err := validateImage(data, ext); err != nil {
+		return "", err
+	}
+
+	imagePath, err := m.writeThumbnail(jobID, ext, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if err := store.UpdateJobImagePath(m.DB, jobID, imagePath); err != nil {
+		return "", err
+	}
+	m.BroadcastJobSnapshot(jobID)
+	return imagePath, nil
+}
+
+// writeThumbnail saves size-checked image data as thumbnails/{jobID}{ext}, removing
+// stale thumbnails with other extensions so the thumbnail handler finds the right one.
+func (m *Manager) writeThumbnail(jobID int64, ext string, r io.Reader) (string, error) {
+	thumbnailsDir := filepath.Join(m.downloadsRoot, "thumbnails")
+	if err := m.mkdir(thumbnailsDir); err != nil {
+		return "", fmt.Errorf("failed to create thumbnails directory: %v", err)
 	}
 
 	fileName := fmt.Sprintf("%d%s", jobID, ext)
 	filePath := filepath.Join(thumbnailsDir, fileName)
 
-	file, err := os.Create(filePath)
+	stale, _ := filepath.Glob(filepath.Join(thumbnailsDir, fmt.Sprintf("%d.*", jobID)))
+	for _, p := range stale {
+		if p != filePath {
+			_ = os.Remove(p)
+		}
+	}
+
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, m.Cfg.FileMode.Or(0o644))
 	if err != nil {
 		return "", fmt.Errorf("failed to create image file: %v", err)
 	}
+	if m.Cfg.FileMode != 0 {
+		_ = file.Chmod(os.FileMode(m.Cfg.FileMode))
+	}
 	defer file.Close()
 
-	_, err = io.Copy(file, io.LimitReader(resp.Body, 5*1024*1024)) // Limit to 5MB
+	_, err = io.Copy(file, r)
 	if err != nil {
 		return "", fmt.Errorf("failed to save image data: %v", err)
 	}
@@ -102,16 +240,189 @@ func (m *Manager) downloadAndSaveImage(jobID int64, imageURL string) (string, er
 	return filepath.Join("thumbnails", fileName), nil
 }
 
-// fetchMetadata fetches both title and image metadata from a URL
-func fetchMetadata(urlStr string) (*Metadata, error) {
-	log.Printf("metadata: fetching metadata for %s", urlStr)
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+// downloadAndSaveImage downloads an image from the given URL and saves it to the thumbnails directory
+func (m *Manager) downloadAndSaveImage(jobID int64, imageURL string) (string, error) {
+	var ext string
+	var data []byte
+	var err error
+	if strings.HasPrefix(imageURL, "data:") {
+		ext, data, err = m.decodeImageDataURI(imageURL)
+	} else {
+		ext, data, err = m.fetchImage(imageURL)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < minThumbnailBytes {
+		return "", ErrImageTooSmall
+	}
+	if err := validateImage(data, ext); err != nil {
+		return "", err
+	}
+
+	return m.writeThumbnail(jobID, ext, bytes.NewReader(data))
+}
+
+// fetchImage downloads an image over HTTP, returning its extension and (size-capped) bytes.
+func (m *Manager) fetchImage(imageURL string) (string, []byte, error) {
+	req, err := http.NewRequest("GET", imageURL, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download image: %v", err)
+	}
+	guard := newStallGuard()
+	defer guard.stop()
+
+	resp, err := imageClient.Do(guard.attach(req))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download image: %w", guard.err(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", nil, fmt.Errorf("image download failed with status code %d", resp.StatusCode)
+	}
+
+	ext := getImageExtension(resp.Header.Get("Content-Type"), imageURL)
+	if ext == "" {
+		return "", nil, fmt.Errorf("unsupported image type")
+	}
+
+	data, err := m.readThumbnail(guard.wrap(resp.Body))
+	if err != nil {
+		return "", nil, err
+	}
+	return ext, data, nil
+}
+
+// decodeImageDataURI decodes an inline "data:image/...;base64," og:image.
+// Only base64-encoded image media types are accepted.
+func (m *Manager) decodeImageDataURI(uri string) (string, []byte, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return "", nil, fmt.Errorf("malformed data URI")
+	}
+
+	params := strings.Split(header, ";")
+	if params[len(params)-1] != "base64" {
+		return "", nil, fmt.Errorf("unsupported data URI encoding (only base64 is supported)")
+	}
+
+	ext := getImageExtension(strings.TrimSpace(params[0]), "")
+	if ext == "" {
+		return "", nil, fmt.Errorf("unsupported data URI media type %q", params[0])
+	}
+
+	// Check the decoded size up front so we never decode more than the cap.
+	if int64(base64.StdEncoding.DecodedLen(len(payload))) > m.MaxThumbnailBytes()+2 {
+		return "", nil, ErrImageTooLarge
+	}
+	data, err := m.readThumbnail(base64.NewDecoder(base64.StdEncoding, strings.NewReader(payload)))
+	if err != nil {
+		return "", nil, err
+	}
+	return ext, data, nil
+}
+
+// Metadata and image fetches share clients so large batches reuse connections.
+// The overall Timeout still applies; stallGuard additionally drops connections
+// that stop sending data.
+var (
+	metadataClient = newFetchClient(15*time.Second, 0)
+	imageClient    = newFetchClient(30*time.Second, maxImageConnsPerHost)
+)
+
+// maxImageConnsPerHost caps image connections to any one host. A batch from
+// one site points all its thumbnails at the same CDN, which may block us for
+// opening metadata_concurrency connections at once; the rest queue. Pages are
+// spread over more hosts and aren't capped.
+const maxImageConnsPerHost = 2
+
+// newFetchClient returns a client with the given timeout; maxConnsPerHost of 0
+// means no limit.
+func newFetchClient(timeout time.Duration, maxConnsPerHost int) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			MaxConnsPerHost: maxConnsPerHost,
 		},
 	}
+}
+
+// readStallTimeout is how long a fetch may go without receiving any bytes
+// (headers or body) before it is aborted.
+var readStallTimeout = 5 * time.Second
+
+var errReadStalled = errors.New("connection stalled")
+
+// stallGuard cancels a request when no progress is made for readStallTimeout.
+// The timer starts once the request has a connection, so time spent queued
+// behind MaxConnsPerHost doesn't count, and is reset on every read that
+// returns data, so a server trickling bytes can't hold the fetch for the
+// client's full Timeout.
+type stallGuard struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newStallGuard() *stallGuard {
+	g := &stallGuard{}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.timer = time.AfterFunc(readStallTimeout, func() {
+		g.stalled.Store(true)
+		g.cancel()
+	})
+	g.timer.Stop()
+	return g
+}
+
+// attach binds req to the guard so a stall aborts it, starting the timer when
+// the transport hands the request a connection.
+func (g *stallGuard) attach(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { g.timer.Reset(readStallTimeout) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(g.ctx, trace))
+}
 
+// wrap returns a reader that resets the stall timer as data arrives.
+func (g *stallGuard) wrap(r io.Reader) io.Reader {
+	return &stallReader{r: r, g: g}
+}
+
+// err replaces the context error from a stall-triggered cancel with errReadStalled.
+func (g *stallGuard) err(err error) error {
+	if err != nil && g.stalled.Load() {
+		return errReadStalled
+	}
+	return err
+}
+
+func (g *stallGuard) stop() {
+	g.timer.Stop()
+	g.cancel()
+}
+
+type stallReader struct {
+	r io.Reader
+	g *stallGuard
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.g.timer.Reset(readStallTimeout)
+	}
+	return n, s.g.err(err)
+}
+
+// fetchMetadata fetches both title and image metadata from a URL.
+// When validators are given the request is conditional and errNotModified is returned on a 304.
+func fetchMetadata(urlStr string, fullScan bool, validators *store.MetadataValidators) (*Metadata, error) {
+	logging.Debugf("metadata: fetching metadata for %s", logging.RedactURL(urlStr))
 	req, err := http.NewRequest("GET", urlStr, nil)
 	if err != nil {
 		return nil, err
@@ -119,22 +430,51 @@ func fetchMetadata(urlStr string) (*Metadata, error) {
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	if validators != nil {
+		if validators.ETag != "" {
+			req.Header.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			req.Header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
+
+	guard := newStallGuard()
+	defer guard.stop()
 
-	resp, err := client.Do(req)
+	resp, err := metadataClient.Do(guard.attach(req))
 	if err != nil {
-		return nil, err
+		return nil, guard.err(err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("status code %d", resp.StatusCode)
 	}
 
-	bodyReader := io.LimitReader(resp.Body, 1024*1024) // 1MB (youtube hides the title deep)
-	return parseHTMLMetadata(bodyReader, urlStr), nil
+	bodyReader := io.LimitReader(guard.wrap(resp.Body), 1024*1024) // 1MB (youtube hides the title deep)
+	// Relative image URLs are relative to the page we ended up on, not the one
+	// we asked for.
+	finalURL := resp.Request.URL.String()
+	metadata := parseHTMLMetadata(bodyReader, finalURL, fullScan)
+	if guard.stalled.Load() {
+		// The tokenizer treats the aborted read as EOF; don't keep a half-read page.
+		return nil, errReadStalled
+	}
+	metadata.FinalURL = finalURL
+	metadata.ETag = resp.Header.Get("ETag")
+	metadata.LastModified = resp.Header.Get("Last-Modified")
+	return metadata, nil
 }
 
-func parseHTMLMetadata(r io.Reader, baseURL string) *Metadata {
+// parseHTMLMetadata extracts the title and og:image from an HTML document.
+// By default it stops at </head>. With fullScan it reads the whole (limited) body,
+// keeping the first og:title/og:image and the last <title>, since SPAs often
+// emit their meaningful tags late or re-set the title.
+func parseHTMLMetadata(r io.Reader, baseURL string, fullScan bool) *Metadata {
 	z := nethtml.NewTokenizer(r)
 	var pageTitle string
 	var ogTitle string
@@ -171,9 +511,13 @@ func parseHTMLMetadata(r io.Reader, baseURL string) *Metadata {
 					}
 				}
 				if prop == "og:title" && content != "" {
-					ogTitle = content
+					if !fullScan || ogTitle == "" {
+						ogTitle = content
+					}
 				} else if prop == "og:image" && content != "" {
-					imageURL = content
+					if !fullScan || imageURL == "" {
+						imageURL = content
+					}
 				}
 			}
 
@@ -189,7 +533,7 @@ func parseHTMLMetadata(r io.Reader, baseURL string) *Metadata {
 			if t.Data == "title" {
 				inTitle = false
 			}
-			if t.Data == "head" {
+			if t.Data == "head" && !fullScan {
 				// If we leave <head>, return what we have
 				finalTitle := ogTitle
 				if finalTitle == "" {
@@ -239,8 +583,8 @@ func resolveImageURL(imageURL, baseURL string) string {
 		return ""
 	}
 
-	// If it's already an absolute URL, return as is
-	if strings.HasPrefix(imageURL, "http://") || strings.HasPrefix(imageURL, "https://") {
+	// If it's already an absolute URL (or an inline data URI), return as is
+	if strings.HasPrefix(imageURL, "http://") || strings.HasPrefix(imageURL, "https://") || strings.HasPrefix(imageURL, "data:") {
 		return imageURL
 	}
 
@@ -262,3 +606,11 @@ func resolveImageURL(imageURL, baseURL string) string {
 
 	return resolved.String()
 }
+
+// truncate shortens s for logging (inline data URIs can be megabytes long).
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
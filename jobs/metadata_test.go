@@ -1,8 +1,22 @@
 package jobs
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"low-tide/config"
+	"low-tide/store"
 )
 
 func TestParseHTMLMetadata(t *testing.T) {
@@ -10,6 +24,7 @@ func TestParseHTMLMetadata(t *testing.T) {
 		name     string
 		html     string
 		baseURL  string
+		fullScan bool
 		expected *Metadata
 	}{
 		{
@@ -71,11 +86,43 @@ func TestParseHTMLMetadata(t *testing.T) {
 				ImageURL: "",
 			},
 		},
+		{
+			name: "Full scan falls back to last title",
+			html: `<html><head>
+				<title>Loading...</title>
+			</head><body>
+				<title>Real Title</title>
+			</body></html>`,
+			baseURL:  "http://example.com",
+			fullScan: true,
+			expected: &Metadata{
+				Title:    "Real Title",
+				ImageURL: "",
+			},
+		},
+		{
+			name: "Full scan prefers first og tags found in body",
+			html: `<html><head>
+				<title>Head Title</title>
+			</head><body>
+				<meta property="og:title" content="First OG">
+				<meta property="og:image" content="/first.png">
+				<meta property="og:title" content="Second OG">
+				<meta property="og:image" content="/second.png">
+				<title>Body Title</title>
+			</body></html>`,
+			baseURL:  "http://example.com",
+			fullScan: true,
+			expected: &Metadata{
+				Title:    "First OG",
+				ImageURL: "http://example.com/first.png",
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := parseHTMLMetadata(strings.NewReader(tt.html), tt.baseURL)
+			got := parseHTMLMetadata(strings.NewReader(tt.html), tt.baseURL, tt.fullScan)
 			if got.Title != tt.expected.Title {
 				t.Errorf("expected Title %q, got %q", tt.expected.Title, got.Title)
 			}
@@ -132,3 +179,304 @@ func TestResolveImageURL(t *testing.T) {
 		}
 	}
 }
+
+func TestDownloadAndSaveImageSizeLimits(t *testing.T) {
+	root := t.TempDir()
+	m := &Manager{Cfg: &config.Config{MaxThumbnailBytes: 1024}, downloadsRoot: root}
+
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"oversized body is rejected", 4096, ErrImageTooLarge},
+		{"tiny body is rejected", 10, ErrImageTooSmall},
+		{"body within limits is saved", 512, nil},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := pngBytes(t)
+			if pad := tt.size - len(body); pad > 0 {
+				body = append(body, bytes.Repeat([]byte{0}, pad)...)
+			} else {
+				body = body[:tt.size]
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			jobID := int64(i + 1)
+			_, err := m.downloadAndSaveImage(jobID, srv.URL+"/img.png")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			matches, _ := filepath.Glob(filepath.Join(root, "thumbnails", fmt.Sprintf("%d.*", jobID)))
+			if tt.wantErr != nil && len(matches) != 0 {
+				t.Fatalf("expected no file to be left behind, found %v", matches)
+			}
+			if tt.wantErr == nil && len(matches) != 1 {
+				t.Fatalf("expected saved thumbnail, found %v", matches)
+			}
+		})
+	}
+}
+
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadAndSaveImageRejectsNonImages(t *testing.T) {
+	root := t.TempDir()
+	m := &Manager{Cfg: &config.Config{}, downloadsRoot: root}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{"html served as png", "image/png", "<html><body>" + strings.Repeat("404 not found ", 20) + "</body></html>", true},
+		{"html served as svg", "image/svg+xml", "<html><body>" + strings.Repeat("oops ", 40) + "</body></html>", true},
+		{"real svg", "image/svg+xml", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10"/></svg>`, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			jobID := int64(i + 1)
+			imagePath, err := m.downloadAndSaveImage(jobID, srv.URL)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotImage) || imagePath != "" {
+					t.Fatalf("expected ErrNotImage and no path, got %q, %v", imagePath, err)
+				}
+				matches, _ := filepath.Glob(filepath.Join(root, "thumbnails", fmt.Sprintf("%d.*", jobID)))
+				if len(matches) != 0 {
+					t.Fatalf("expected no file to be left behind, found %v", matches)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeImageDataURI(t *testing.T) {
+	m := &Manager{Cfg: &config.Config{}, downloadsRoot: t.TempDir()}
+	png := pngBytes(t)
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+
+	if got := resolveImageURL(uri, "http://example.com/page"); got != uri {
+		t.Fatalf("expected data URI to be passed through, got %q", got)
+	}
+
+	ext, data, err := m.decodeImageDataURI(uri)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ext != ".png" || !bytes.Equal(data, png) {
+		t.Fatalf("expected decoded png, got ext %q (%d bytes)", ext, len(data))
+	}
+
+	for _, bad := range []string{
+		"data:image/png,rawbytes",
+		"data:text/html;base64," + base64.StdEncoding.EncodeToString([]byte("<html></html>")),
+		"data:image/png;base64",
+	} {
+		if _, _, err := m.decodeImageDataURI(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+
+	small := &Manager{Cfg: &config.Config{MaxThumbnailBytes: 16}, downloadsRoot: t.TempDir()}
+	if _, _, err := small.decodeImageDataURI(uri); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+}
+
+func TestFetchAbortsStalledConnections(t *testing.T) {
+	defer func(d time.Duration) { readStallTimeout = d }(readStallTimeout)
+	readStallTimeout = 200 * time.Millisecond
+
+	// Each response starts promptly, then stops sending well within the
+	// clients' overall Timeout.
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/img.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngBytes(t)[:20])
+		} else {
+			w.Write([]byte("<html><head><title>Slow"))
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	if _, err := fetchMetadata(srv.URL+"/page", false, nil); !errors.Is(err, errReadStalled) {
+		t.Fatalf("expected errReadStalled from fetchMetadata, got %v", err)
+	}
+	m := &Manager{Cfg: &config.Config{}, downloadsRoot: t.TempDir()}
+	if _, err := m.downloadAndSaveImage(1, srv.URL+"/img.png"); !errors.Is(err, errReadStalled) {
+		t.Fatalf("expected errReadStalled from image download, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("stalled fetches took %s to abort", elapsed)
+	}
+}
+
+func TestFetchToleratesSlowButSteadyBody(t *testing.T) {
+	defer func(d time.Duration) { readStallTimeout = d }(readStallTimeout)
+	readStallTimeout = 200 * time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, chunk := range []string{"<html><head>", "<title>Steady", "</title>", "</head></html>"} {
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	md, err := fetchMetadata(srv.URL, false, nil)
+	if err != nil || md.Title != "Steady" {
+		t.Fatalf("expected steady body to be read, got %+v, %v", md, err)
+	}
+}
+
+func TestFetchResolvesImageAgainstRedirectTarget(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta property="og:image" content="thumb.png"></head></html>`))
+	}))
+	defer cdn.Close()
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, cdn.URL+"/videos/1", http.StatusFound)
+	}))
+	defer short.Close()
+
+	md, err := fetchMetadata(short.URL+"/x", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := cdn.URL + "/videos/thumb.png"; md.ImageURL != want {
+		t.Fatalf("expected the image relative to the redirect target %q, got %q", want, md.ImageURL)
+	}
+}
+
+func TestRecoverJobsResumesPendingMetadata(t *testing.T) {
+	noFetch := false
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{
+			{ID: "page", Command: "true"},
+			{ID: "direct", Command: "true", FetchMetadata: &noFetch},
+		},
+	})
+
+	var hits sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Store(r.URL.Path, true)
+		w.Write([]byte("<html><head><title>Recovered</title></head></html>"))
+	}))
+	defer srv.Close()
+
+	// As left behind by a restart: one job never got its fetch, one finished it
+	// before the restart, and one belongs to an app that doesn't fetch metadata.
+	pending, _ := store.InsertJob(db, "page", "Page", srv.URL+"/pending", time.Now())
+	done, _ := store.InsertJob(db, "page", "Page", srv.URL+"/done", time.Now())
+	if err := store.MarkMetadataFetched(db, done); err != nil {
+		t.Fatal(err)
+	}
+	direct, _ := store.InsertJob(db, "direct", "Direct", srv.URL+"/direct", time.Now())
+
+	m.RecoverJobs()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left, err := store.ListJobsPendingMetadata(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs still pending metadata: %v", left)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if j, _ := store.GetJob(db, pending); j.Title != "Recovered" {
+		t.Fatalf("expected pending job to get its title, got %q", j.Title)
+	}
+	if _, ok := hits.Load("/done"); ok {
+		t.Fatalf("job %d was fetched again after completing", done)
+	}
+	if _, ok := hits.Load("/direct"); ok {
+		t.Fatalf("job %d fetched metadata for an app with fetch_metadata: false", direct)
+	}
+}
+
+func TestImageDownloadsCappedPerHost(t *testing.T) {
+	// Queued downloads wait longer than this for a connection; that mustn't
+	// count as a stall.
+	defer func(d time.Duration) { readStallTimeout = d }(readStallTimeout)
+	readStallTimeout = 150 * time.Millisecond
+
+	root := t.TempDir()
+	m := &Manager{Cfg: &config.Config{}, downloadsRoot: root}
+
+	body := append(pngBytes(t), bytes.Repeat([]byte{0}, 512)...)
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	// A burst well over the cap, as if every job in a batch shared a CDN.
+	var wg sync.WaitGroup
+	for i := range 4 * maxImageConnsPerHost {
+		wg.Add(1)
+		go func(jobID int64) {
+			defer wg.Done()
+			if _, err := m.downloadAndSaveImage(jobID, srv.URL+"/img.png"); err != nil {
+				t.Errorf("job %d: %v", jobID, err)
+			}
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	if peak > maxImageConnsPerHost {
+		t.Fatalf("expected at most %d concurrent downloads from one host, got %d", maxImageConnsPerHost, peak)
+	}
+	if saved, _ := filepath.Glob(filepath.Join(root, "thumbnails", "*.png")); len(saved) != 4*maxImageConnsPerHost {
+		t.Fatalf("expected every queued download to finish, got %d thumbnails", len(saved))
+	}
+}
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+//go:build !unix
+
+package jobs
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// signalProcessGroup signals only cmd's own process where there are no process
+// groups to signal. Windows supports nothing but SIGKILL.
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(sig)
+}
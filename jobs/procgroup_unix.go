@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+//go:build unix
+
+package jobs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// signalProcessGroup sends sig to the process group led by cmd's process.
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
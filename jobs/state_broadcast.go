@@ -4,8 +4,10 @@ package jobs
 import (
 	"bytes"
 	"encoding/json"
+	"strconv"
 	"time"
 
+	"low-tide/internal/terminal"
 	"low-tide/store"
 )
 
@@ -15,11 +17,38 @@ type JobSnapshotEvent struct {
 	At   time.Time  `json:"updated_at"`
 }
 
+// JobLogEvent carries terminal changes: the client drops Scroll lines from the
+// top, shifting the rest up, then replaces or appends Lines by index.
 type JobLogEvent struct {
-	Type  string         `json:"type"`
-	JobID int64          `json:"job_id"`
-	Lines map[int]string `json:"lines,omitempty"`
-	When  time.Time      `json:"when"`
+	Type   string         `json:"type"`
+	JobID  int64          `json:"job_id"`
+	Scroll int            `json:"scroll,omitempty"`
+	Lines  map[int]string `json:"lines,omitempty"`
+	When   time.Time      `json:"when"`
+}
+
+// JobSnapshotsEvent carries every snapshot produced in one publisher tick when
+// batch_snapshots is enabled.
+type JobSnapshotsEvent struct {
+	Type string       `json:"type"`
+	Jobs []*store.Job `json:"jobs"`
+	At   time.Time    `json:"updated_at"`
+}
+
+// JobDoneEvent is sent once per run after the job is finalized. Logs is the
+// authoritative full render; no more job_log deltas follow for this run.
+type JobDoneEvent struct {
+	Type   string          `json:"type"`
+	JobID  int64           `json:"job_id"`
+	Status store.JobStatus `json:"status"`
+	Logs   string          `json:"logs"`
+	At     time.Time       `json:"finished_at"`
+}
+
+// QueueIdleEvent is sent when the worker finishes a job and finds the queue empty.
+type QueueIdleEvent struct {
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
 }
 
 // logPublisher sends terminal log deltas at a regular interval.
@@ -31,29 +60,38 @@ func (m *Manager) logPublisher() {
 		rj := m.current
 		m.mu.Unlock()
 		if rj != nil {
-			if delta := rj.term.GetDeltaHTML(); len(delta) > 0 {
-				m.broadcastLogDelta(rj.jobID, delta)
+			if scroll, lines := rj.term.GetDeltaHTML(); scroll > 0 || len(lines) > 0 {
+				m.broadcastLogDelta(rj.jobID, scroll, lines)
 			}
 		}
 	}
 }
 
-func (m *Manager) broadcastLogDelta(jobID int64, lines map[int]string) {
+func (m *Manager) broadcastLogDelta(jobID int64, scroll int, lines map[int]string) {
 	ev := JobLogEvent{
-		Type:  "job_log",
-		JobID: jobID,
-		Lines: lines,
-		When:  time.Now(),
+		Type:   "job_log",
+		JobID:  jobID,
+		Scroll: scroll,
+		Lines:  lines,
+		When:   time.Now(),
 	}
 	m.BroadcastState(ev)
 }
 
 func (m *Manager) SubscribeState() chan []byte {
+	ch, _ := m.SubscribeStateSeq()
+	return ch
+}
+
+// SubscribeStateSeq subscribes like SubscribeState and also returns the seq of
+// the last event broadcast before the subscription, taken under the same lock:
+// the channel gets exactly the events after it.
+func (m *Manager) SubscribeStateSeq() (chan []byte, uint64) {
 	ch := make(chan []byte, 64)
 	m.stateSubsMutex.Lock()
+	defer m.stateSubsMutex.Unlock()
 	m.stateSubs[ch] = struct{}{}
-	m.stateSubsMutex.Unlock()
-	return ch
+	return ch, m.stateSeq
 }
 
 func (m *Manager) UnsubscribeState(ch chan []byte) {
@@ -65,18 +103,21 @@ func (m *Manager) UnsubscribeState(ch chan []byte) {
 	}
 }
 
+// BroadcastState sends an event to every subscriber, stamped with the next
+// "seq". Slow subscribers drop events rather than block; the seq gap lets the
+// client notice and resync.
 func (m *Manager) BroadcastState(v interface{}) {
 	b, err := json.Marshal(v)
-	if err != nil {
+	if err != nil || len(b) < 2 || b[0] != '{' {
 		return
 	}
+	// Assign seq and deliver under the same lock so every subscriber sees
+	// events in seq order.
 	m.stateSubsMutex.Lock()
-	subs := make([]chan []byte, 0, len(m.stateSubs))
+	defer m.stateSubsMutex.Unlock()
+	m.stateSeq++
+	b = withSeq(b, m.stateSeq)
 	for ch := range m.stateSubs {
-		subs = append(subs, ch)
-	}
-	m.stateSubsMutex.Unlock()
-	for _, ch := range subs {
 		select {
 		case ch <- b:
 		default:
@@ -84,14 +125,44 @@ func (m *Manager) BroadcastState(v interface{}) {
 	}
 }
 
+// StateSeq returns the seq of the most recent broadcast event.
+func (m *Manager) StateSeq() uint64 {
+	m.stateSubsMutex.Lock()
+	defer m.stateSubsMutex.Unlock()
+	return m.stateSeq
+}
+
+// withSeq prepends a "seq" field to a marshaled JSON object.
+func withSeq(obj []byte, seq uint64) []byte {
+	out := make([]byte, 0, len(obj)+24)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendUint(out, seq, 10)
+	if len(obj) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, obj[1:]...)
+}
+
 func (m *Manager) BroadcastJobSnapshot(jobID int64) {
+	j, changed := m.jobSnapshot(jobID)
+	if !changed {
+		return
+	}
+	ev := JobSnapshotEvent{Type: "job_snapshot", Job: j, At: time.Now()}
+	m.BroadcastState(ev)
+}
+
+// jobSnapshot loads a job with its files (paths relative to the downloads root)
+// and reports whether it differs from the last snapshot sent for it. A changed
+// snapshot is recorded as sent, so callers must broadcast it.
+func (m *Manager) jobSnapshot(jobID int64) (*store.Job, bool) {
 	j, err := store.GetJob(m.DB, jobID)
 	if err != nil {
-		return
+		return nil, false
 	}
 	files, err := store.ListJobFiles(m.DB, jobID)
 	if err != nil {
-		return
+		return nil, false
 	}
 	relFiles := make([]store.JobFile, 0, len(files))
 	for _, f := range files {
@@ -106,10 +177,11 @@ func (m *Manager) BroadcastJobSnapshot(jobID int64) {
 	// Marshal just the job data for comparison
 	jobData, err := json.Marshal(j)
 	if err != nil {
-		return
+		return nil, false
 	}
 
 	m.jobChangesMu.Lock()
+	defer m.jobChangesMu.Unlock()
 	ch := m.jobChanges[jobID]
 	if ch == nil {
 		ch = &jobChange{}
@@ -118,16 +190,24 @@ func (m *Manager) BroadcastJobSnapshot(jobID int64) {
 
 	// Compare with the last sent job data
 	if bytes.Equal(ch.lastSent, jobData) {
-		m.jobChangesMu.Unlock()
-		return // Data is the same, no need to broadcast
+		return nil, false // Data is the same, no need to broadcast
 	}
 
 	// Data has changed, update our record of what was sent
 	ch.lastSent = jobData
-	m.jobChangesMu.Unlock()
+	return j, true
+}
 
-	ev := JobSnapshotEvent{Type: "job_snapshot", Job: j, At: time.Now()}
-	m.BroadcastState(ev)
+func (m *Manager) broadcastJobDone(jobID int64) {
+	j, err := store.GetJob(m.DB, jobID)
+	if err != nil {
+		return
+	}
+	at := time.Now()
+	if j.FinishedAt != nil {
+		at = *j.FinishedAt
+	}
+	m.BroadcastState(JobDoneEvent{Type: "job_done", JobID: jobID, Status: j.Status, Logs: j.Logs, At: store.DisplayTime(at)})
 }
 
 func (m *Manager) GetJobLogs(jobID int64) ([]byte, bool) {
@@ -138,6 +218,39 @@ func (m *Manager) GetJobLogs(jobID int64) ([]byte, bool) {
 	return []byte(j.Logs), true
 }
 
+// GetJobLogLines returns the job's log as a line map, the same shape as the
+// "job_log" deltas: live from the terminal while the job runs, otherwise parsed
+// from the stored render.
+func (m *Manager) GetJobLogLines(jobID int64) (map[int]string, bool) {
+	m.mu.Lock()
+	rj := m.current
+	m.mu.Unlock()
+	if rj != nil && rj.jobID == jobID {
+		return rj.term.Lines(), true
+	}
+	logs, ok := m.GetJobLogs(jobID)
+	if !ok {
+		return nil, false
+	}
+	return terminal.ParseLines(string(logs)), true
+}
+
+// GetJobLogANSI returns the job's log as text with ANSI color sequences: live
+// from the terminal while the job runs, otherwise converted from the stored render.
+func (m *Manager) GetJobLogANSI(jobID int64) (string, bool) {
+	m.mu.Lock()
+	rj := m.current
+	m.mu.Unlock()
+	if rj != nil && rj.jobID == jobID {
+		return rj.term.RenderANSI(), true
+	}
+	logs, ok := m.GetJobLogs(jobID)
+	if !ok {
+		return "", false
+	}
+	return terminal.HTMLToANSI(string(logs)), true
+}
+
 func (m *Manager) GetJobLogBuffer(jobID int64) []byte {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -0,0 +1,219 @@
+package jobs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"low-tide/config"
+	"low-tide/store"
+)
+
+func TestJobDoneEventSentOnce(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "echo", Command: "sh", Args: []string{"-c", "echo done > out.txt"}}},
+	})
+	ch := m.SubscribeState()
+	defer m.UnsubscribeState(ch)
+
+	jobID, err := store.InsertJob(db, "echo", "Echo", "http://example.com/", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Queue <- jobID
+
+	var done []JobDoneEvent
+	timeout := time.After(5 * time.Second)
+	// Keep listening a little past the first job_done to catch duplicates.
+	var settle <-chan time.Time
+	for {
+		select {
+		case b := <-ch:
+			var ev JobDoneEvent
+			if json.Unmarshal(b, &ev) == nil && ev.Type == "job_done" && ev.JobID == jobID {
+				done = append(done, ev)
+				if settle == nil {
+					settle = time.After(300 * time.Millisecond)
+				}
+			}
+		case <-settle:
+			if len(done) != 1 {
+				t.Fatalf("expected exactly one job_done event, got %d", len(done))
+			}
+			if done[0].Status != store.StatusSuccess || done[0].Logs == "" {
+				t.Fatalf("unexpected job_done payload: %+v", done[0])
+			}
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for job_done")
+		}
+	}
+}
+
+func TestBroadcastStateSeq(t *testing.T) {
+	m := &Manager{stateSubs: make(map[chan []byte]struct{})}
+	ch := m.SubscribeState()
+
+	var seqs []uint64
+	for i := 0; i < 3; i++ {
+		m.BroadcastState(JobLogEvent{Type: "job_log", JobID: 1})
+		var ev struct {
+			Seq   uint64 `json:"seq"`
+			Type  string `json:"type"`
+			JobID int64  `json:"job_id"`
+		}
+		if err := json.Unmarshal(<-ch, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != "job_log" || ev.JobID != 1 {
+			t.Fatalf("event fields lost when adding seq: %+v", ev)
+		}
+		seqs = append(seqs, ev.Seq)
+	}
+	if seqs[0] != 1 || seqs[1] != 2 || seqs[2] != 3 {
+		t.Fatalf("expected seq 1,2,3, got %v", seqs)
+	}
+	if m.StateSeq() != 3 {
+		t.Fatalf("expected StateSeq 3, got %d", m.StateSeq())
+	}
+
+	// A fresh manager (i.e. a restart) starts over.
+	m2 := &Manager{stateSubs: make(map[chan []byte]struct{})}
+	ch2 := m2.SubscribeState()
+	m2.BroadcastState(struct {
+		Type string `json:"type"`
+	}{"ping"})
+	if got := string(<-ch2); got != `{"seq":1,"type":"ping"}` {
+		t.Fatalf("unexpected first event after restart: %s", got)
+	}
+}
+
+func TestSubscribeStateSeqMatchesStream(t *testing.T) {
+	m := &Manager{stateSubs: make(map[chan []byte]struct{})}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				m.BroadcastState(JobLogEvent{Type: "job_log", JobID: 1})
+			}
+		}
+	}()
+
+	// Events racing the subscription must be either before seq or delivered.
+	for i := 0; i < 200; i++ {
+		ch, seq := m.SubscribeStateSeq()
+		var ev struct {
+			Seq uint64 `json:"seq"`
+		}
+		if err := json.Unmarshal(<-ch, &ev); err != nil {
+			t.Fatal(err)
+		}
+		m.UnsubscribeState(ch)
+		if ev.Seq != seq+1 {
+			t.Fatalf("subscribed at seq %d but the first event was %d", seq, ev.Seq)
+		}
+	}
+}
+
+func TestWithSeqEmptyObject(t *testing.T) {
+	if got := string(withSeq([]byte(`{}`), 7)); got != `{"seq":7}` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestPublishDirtyBatchesSnapshots(t *testing.T) {
+	m, first := newResyncManager(t)
+	m.Cfg.BatchSnapshots = true
+	m.stateSubs = make(map[chan []byte]struct{})
+	ids := []int64{first}
+	for i := 0; i < 2; i++ {
+		id, err := store.InsertJob(m.DB, "test", "Test", "http://example.com/", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	ch := m.SubscribeState()
+	for _, id := range ids {
+		m.markDirty(id)
+	}
+	m.publishDirty()
+
+	var ev struct {
+		Type string       `json:"type"`
+		Jobs []*store.Job `json:"jobs"`
+	}
+	select {
+	case b := <-ch:
+		if err := json.Unmarshal(b, &ev); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+	if ev.Type != "job_snapshots" || len(ev.Jobs) != len(ids) {
+		t.Fatalf("expected one job_snapshots frame with %d jobs, got %s with %d", len(ids), ev.Type, len(ev.Jobs))
+	}
+	select {
+	case b := <-ch:
+		t.Fatalf("unexpected extra frame: %s", b)
+	default:
+	}
+
+	// Nothing changed since, so the next tick publishes nothing.
+	for _, id := range ids {
+		m.markDirty(id)
+	}
+	m.publishDirty()
+	select {
+	case b := <-ch:
+		t.Fatalf("unchanged jobs were republished: %s", b)
+	default:
+	}
+}
+
+func TestQueueIdleAfterLastJob(t *testing.T) {
+	m, db := startTestManager(t, &config.Config{
+		Apps: []config.AppConfig{{ID: "slow", Command: "sh", Args: []string{"-c", "sleep 0.2"}}},
+	})
+	ch := m.SubscribeState()
+	defer m.UnsubscribeState(ch)
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := store.InsertJob(db, "slow", "Slow", "http://example.com/", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		m.Queue <- id
+	}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case b := <-ch:
+			var ev struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(b, &ev) != nil || ev.Type != "queue_idle" {
+				continue
+			}
+			for _, id := range ids {
+				if j, _ := store.GetJob(db, id); j.Status == store.StatusQueued || j.Status == store.StatusRunning {
+					t.Fatalf("queue_idle sent before job %d finished (status %s)", id, j.Status)
+				}
+			}
+			if st := m.QueueStatus(); !st.Idle || st.Queued != 0 || st.Running != 0 {
+				t.Fatalf("expected idle queue status, got %+v", st)
+			}
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for queue_idle")
+		}
+	}
+}
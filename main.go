@@ -3,6 +3,7 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"log"
 	"net/http"
 	"path/filepath"
@@ -10,17 +11,47 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	"low-tide/config"
+	"low-tide/internal/logging"
 	"low-tide/jobs"
 	"low-tide/store"
 )
 
 func main() {
-	log.Printf("Starting Low Tide ⛵️")
+	configPath := flag.String("config", "", "path to the config file (default: LOWTIDE_CONFIG, then the standard search locations)")
+	initConfig := flag.Bool("init", false, "write a starter config if none exists yet")
+	flag.Parse()
 
-	cfg, err := config.Load(config.GetConfigPath())
+	logging.Infof("Starting Low Tide ⛵️ %s", currentVersion())
+
+	path, err := config.ResolveConfigPath(*configPath)
+	if *initConfig {
+		if err != nil {
+			// Nothing found anywhere: create it at the most specific search location.
+			path = config.SearchPaths()[0]
+		}
+		created, err := config.WriteDefault(path)
+		if err != nil {
+			log.Fatalf("write starter config: %v", err)
+		}
+		if created {
+			logging.Infof("created starter config at %s", path)
+		}
+	} else if err != nil {
+		log.Fatalf("config: %v (run with --init to create one)", err)
+	}
+	logging.Infof("using config %s", path)
+
+	cfg, err := config.Load(path)
 	if err != nil {
 		log.Fatalf("load config: %v", err)
 	}
+	level, _ := logging.ParseLevel(cfg.LogLevel) // already checked by Validate
+	logging.SetLevel(level)
+	for _, w := range cfg.Warnings() {
+		logging.Warnf("config: %s", w)
+	}
+	loc, _ := cfg.Location() // already checked by Validate
+	store.SetDisplayLocation(loc)
 
 	db, err := sql.Open("sqlite3", cfg.DBPath+"?_fk=1")
 	if err != nil {
@@ -32,6 +63,14 @@ func main() {
 		log.Fatalf("init db: %v", err)
 	}
 
+	if cfg.VacuumOnStartup {
+		before, after, err := store.Vacuum(db)
+		if err != nil {
+			log.Fatalf("vacuum db: %v", err)
+		}
+		logging.Infof("vacuum: database %d -> %d bytes", before, after)
+	}
+
 	// Normalize downloads dir
 	cfg.DownloadsDir, err = filepath.Abs(cfg.DownloadsDir)
 	if err != nil {
@@ -46,6 +85,6 @@ func main() {
 
 	srv := NewServer(db, cfg, mgr)
 
-	log.Printf("🌊 Low Tide listening on %s", cfg.ListenAddr)
+	logging.Infof("🌊 Low Tide listening on %s", cfg.ListenAddr)
 	log.Fatal(http.ListenAndServe(cfg.ListenAddr, srv.Routes()))
 }
@@ -0,0 +1,55 @@
+// Low Tide service worker: keeps the app shell (the page and its static assets)
+// available offline. API calls and the websocket always go to the network.
+const CACHE = 'low-tide-shell-v1';
+
+self.addEventListener('install', () => self.skipWaiting());
+
+self.addEventListener('activate', (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+      .then(() => self.clients.claim())
+  );
+});
+
+self.addEventListener('fetch', (event) => {
+  const req = event.request;
+  const url = new URL(req.url);
+  if (req.method !== 'GET' || url.origin !== self.location.origin) return;
+
+  if (url.pathname.startsWith('/static/')) {
+    // Assets are linked with ?v=<boot time>, so a cached copy never goes stale.
+    event.respondWith(cacheFirst(req));
+  } else if (req.mode === 'navigate' && !url.pathname.startsWith('/api/')) {
+    event.respondWith(networkFirst(req));
+  }
+});
+
+async function cacheFirst(req) {
+  const cached = await caches.match(req);
+  if (cached) return cached;
+  const res = await fetch(req);
+  if (res.ok) {
+    const cache = await caches.open(CACHE);
+    // Drop the bundles of earlier deploys, they only differ in ?v=.
+    const path = new URL(req.url).pathname;
+    for (const old of await cache.keys()) {
+      if (new URL(old.url).pathname === path) await cache.delete(old);
+    }
+    await cache.put(req, res.clone());
+  }
+  return res;
+}
+
+async function networkFirst(req) {
+  const cache = await caches.open(CACHE);
+  try {
+    const res = await fetch(req);
+    if (res.ok) await cache.put('/', res.clone());
+    return res;
+  } catch (err) {
+    const cached = await cache.match('/');
+    if (cached) return cached;
+    throw err;
+  }
+}
@@ -2,40 +2,55 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 
 	"low-tide/config"
+	"low-tide/internal/logging"
+	"low-tide/internal/slug"
 	"low-tide/jobs"
 	"low-tide/store"
 )
 
-//go:embed templates/*.html static/*
+//go:embed templates/*.html static/* pwa/*
 var assets embed.FS
 
 var indexTmpl = template.Must(template.ParseFS(assets, "templates/index.html"))
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+var notFoundTmpl = template.Must(template.ParseFS(assets, "templates/404.html"))
 
 type Server struct {
 	DB       *sql.DB
 	Cfg      *config.Config
 	Mgr      *jobs.Manager
 	BootTime int64
+
+	// appsMu serializes app edits made through /api/apps.
+	appsMu sync.Mutex
+
+	upgrader websocket.Upgrader
 }
 
 func NewServer(db *sql.DB, cfg *config.Config, mgr *jobs.Manager) *Server {
@@ -44,34 +59,229 @@ func NewServer(db *sql.DB, cfg *config.Config, mgr *jobs.Manager) *Server {
 		Cfg:      cfg,
 		Mgr:      mgr,
 		BootTime: time.Now().Unix(),
+		upgrader: websocket.Upgrader{
+			CheckOrigin:       func(r *http.Request) bool { return true },
+			EnableCompression: cfg.ShouldCompressWS(),
+		},
 	}
 }
 
 func (s *Server) Routes() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.handleIndex)
-	mux.HandleFunc("/job/", s.handleIndex)
-	mux.Handle("/static/", http.FileServer(http.FS(assets)))
-	mux.HandleFunc("/api/jobs", s.handleJobs)
-	mux.HandleFunc("/api/jobs/", s.handleJobAction)
-	mux.HandleFunc("/thumbnails/", s.handleThumbnails)
-	mux.HandleFunc("/ws/state", s.handleStateWS)
-	return loggingMiddleware(mux)
+	// Page routes are GET-only and don't overlap /api/, so a wrong method on an
+	// API route gets a 405 from the mux rather than falling through to the index.
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /job/", s.handleIndex)
+	mux.Handle("GET /static/", s.staticFiles())
+	mux.HandleFunc("GET /manifest.json", pwaFile("manifest.json", "application/manifest+json"))
+	mux.HandleFunc("GET /sw.js", pwaFile("sw.js", "text/javascript; charset=utf-8"))
+	mux.HandleFunc("GET /icon.svg", pwaFile("icon.svg", "image/svg+xml"))
+	mux.HandleFunc("GET /thumbnails/", s.handleThumbnails)
+	mux.HandleFunc("GET /ws/state", s.handleStateWS)
+
+	mux.HandleFunc("GET /api/jobs", s.handleListJobs)
+	mux.HandleFunc("POST /api/jobs", s.handleSubmitJobs)
+	mux.HandleFunc("POST /api/jobs/retry-failed", s.handleRetryFailed)
+	mux.HandleFunc("GET /api/jobs/zip", s.handleBulkArchive("zip"))
+	mux.HandleFunc("GET /api/jobs/tar.gz", s.handleBulkArchive("tar.gz"))
+	mux.HandleFunc("GET /api/jobs/{id}", withJobID(s.handleGetJobSnapshot))
+	mux.HandleFunc("POST /api/jobs/{id}/retry", withJobID(s.handleRetry))
+	mux.HandleFunc("POST /api/jobs/{id}/clone", withJobID(s.handleClone))
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", withJobID(s.handleCancel))
+	mux.HandleFunc("GET /api/jobs/{id}/zip", withJobID(s.handleZip))
+	mux.HandleFunc("GET /api/jobs/{id}/logs", withJobID(s.handleJobLogs))
+	mux.HandleFunc("GET /api/jobs/{id}/wait", withJobID(s.handleWaitJob))
+	mux.HandleFunc("GET /api/jobs/{id}/dupes", withJobID(s.handleDupes))
+	mux.HandleFunc("GET /api/jobs/{id}/checksums", withJobID(s.handleChecksums))
+	mux.HandleFunc("DELETE /api/jobs/{id}/files", withJobID(s.handleDeleteFiles))
+	mux.HandleFunc("GET /api/jobs/{id}/files/raw", s.requireAdmin(withJobID(s.handleRawJobFiles)))
+	mux.HandleFunc("GET /api/jobs/{id}/files/{fid}", withJobID(s.handleDownloadArtifact))
+	mux.HandleFunc("POST /api/jobs/{id}/refetch", withJobID(s.handleRefetch))
+	mux.HandleFunc("PUT /api/jobs/{id}/thumbnail", withJobID(s.handleUploadThumbnail))
+	mux.HandleFunc("POST /api/jobs/{id}/archive", withJobID(s.handleArchive))
+	mux.HandleFunc("POST /api/jobs/{id}/cleanup", withJobID(s.handleCleanup))
+	mux.HandleFunc("GET /api/queue", s.handleQueue)
+	mux.HandleFunc("GET /api/disk", s.handleDisk)
+	mux.HandleFunc("GET /api/version", s.handleVersion)
+	mux.HandleFunc("GET /api/activity", s.handleActivity)
+
+	mux.HandleFunc("POST /api/admin/vacuum", s.requireAdmin(s.handleVacuum))
+	mux.HandleFunc("POST /api/admin/pause", s.requireAdmin(s.handlePause))
+	mux.HandleFunc("POST /api/admin/resume", s.requireAdmin(s.handleResume))
+	mux.HandleFunc("GET /api/apps", s.requireAdmin(s.handleListApps))
+	mux.HandleFunc("POST /api/apps", s.requireAdmin(s.handleCreateApp))
+	mux.HandleFunc("GET /api/apps/{id}", s.requireAdmin(s.handleGetApp))
+	mux.HandleFunc("GET /api/apps/{id}/preview", s.requireAdmin(s.handleAppPreview))
+	mux.HandleFunc("PUT /api/apps/{id}", s.requireAdmin(s.handlePutApp))
+	mux.HandleFunc("DELETE /api/apps/{id}", s.requireAdmin(s.handleDeleteApp))
+	return loggingMiddleware(gzipMiddleware(normalizeAPIPath(s.withFallbacks(mux))))
 }
 
-func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" && !strings.HasPrefix(r.URL.Path, "/job/") {
-		http.NotFound(w, r)
+// probedMethods are tried when answering OPTIONS; HEAD is implied by GET routes.
+var probedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// withFallbacks handles requests no route matches: OPTIONS gets a 204 listing
+// the methods routed for the path, unknown paths get notFound (or 400 when they
+// go deeper than any job action), and wrong methods are left to the mux, which
+// answers 405 with an Allow header.
+func (s *Server) withFallbacks(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		allowed := allowedMethods(mux, r)
+		switch {
+		case len(allowed) == 0 && tooDeepJobPath(r.URL.Path):
+			writeJSONError(w, 400, "malformed path: unexpected segments after action")
+		case len(allowed) == 0:
+			s.notFound(w, r)
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+// tooDeepJobPath reports whether path has segments past /api/jobs/{id}/{action}.
+func tooDeepJobPath(path string) bool {
+	rest, ok := strings.CutPrefix(path, "/api/jobs/")
+	return ok && strings.Count(rest, "/") >= 2
+}
+
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	for _, m := range probedMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		// Method-less patterns (catch-alls) don't describe a real endpoint.
+		if _, pattern := mux.Handler(probe); strings.HasPrefix(pattern, m+" ") {
+			allowed = append(allowed, m)
+			if m == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+	return allowed
+}
+
+// notFound answers API paths with a JSON error and everything else with the
+// branded 404 page.
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONError(w, http.StatusNotFound, "not found")
 		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := notFoundTmpl.Execute(w, map[string]any{"Path": r.URL.Path, "Version": s.BootTime}); err != nil {
+		logging.Errorf("execute 404 template: %v", err)
+	}
+}
+
+// staticFiles serves the embedded static/ tree. The URL path maps directly onto
+// the embed root (/static/js/bundle.js -> static/js/bundle.js). Missing files and
+// directories get the 404 page instead of FileServer's plain text or listing.
+//
+// embed.FS has no modtimes, so caching relies on content-hash ETags computed
+// once here; ServeContent answers matching If-None-Match with a 304.
+func (s *Server) staticFiles() http.Handler {
+	etags := make(map[string]string)
+	_ = fs.WalkDir(assets, "static", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := assets.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		etags[path] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		return nil
+	})
+
+	files := http.FileServer(http.FS(assets))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag, ok := etags[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			s.notFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if r.URL.Query().Has("v") {
+			// The index links assets with ?v=<boot time>, so a deploy changes the URL.
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// pwaFile serves one of the embedded pwa/ files (web app manifest, service
+// worker, icon). They live at the site root because a service worker only
+// controls pages under its own path.
+func pwaFile(name, contentType string) http.HandlerFunc {
+	b, err := assets.ReadFile("pwa/" + name)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", etag)
+		// Browsers check the service worker for updates; don't let caches hide them.
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(b))
+	}
+}
+
+// normalizeAPIPath drops a single trailing slash from API paths, so /api/jobs/5/
+// routes like /api/jobs/5, and answers paths containing "//" with a 400. ServeMux
+// would otherwise redirect those to the cleaned path, turning a POST into a GET.
+func normalizeAPIPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "//") {
+			writeJSONError(w, 400, "malformed path: empty segment")
+			return
+		}
+		if len(r.URL.Path) > len("/api/") && strings.HasSuffix(r.URL.Path, "/") {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = strings.TrimSuffix(r.URL.Path, "/")
+			r2.URL.RawPath = ""
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withJobID adapts a job handler to a route with an {id} wildcard.
+func withJobID(h func(w http.ResponseWriter, r *http.Request, jobID int64)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, 400, "invalid id")
+			return
+		}
+		h(w, r, id)
+	}
+}
 
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	// will be used to populate app list in JS
 	type AppInfo struct {
 		ID   string `json:"id"`
 		Name string `json:"name"`
 	}
 	var apps []AppInfo
-	for _, app := range s.Cfg.Apps {
+	for _, app := range s.Cfg.AppList() {
 		apps = append(apps, AppInfo{ID: app.ID, Name: app.Name})
 	}
 	appsJSON, _ := json.Marshal(apps)
@@ -82,290 +292,1072 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		"Version":  s.BootTime,
 	})
 	if err != nil {
-		log.Printf("execute template: %v", err)
+		logging.Errorf("execute template: %v", err)
 		http.Error(w, err.Error(), 500)
 	}
 }
 
-func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		jobsList, err := store.ListJobs(s.DB, 100)
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	limit, err := s.jobsListLimit(r)
+	if err != nil {
+		writeJSONError(w, 400, err.Error())
+		return
+	}
+	s.writeJobsJSON(w, limit)
+}
+
+func (s *Server) handleSubmitJobs(w http.ResponseWriter, r *http.Request) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		s.handleSubmitJobsJSON(w, r)
+		return
+	}
+
+	// Use FormValue so Go handles both urlencoded and multipart/form-data.
+	appID := r.FormValue("app_id")
+	urlsRaw := r.FormValue("urls")
+	submitted, rejected := splitURLs(urlsRaw, s.Cfg.URLNormalization)
+	logging.Debugf("/api/jobs POST app_id=%q urls=%d rejected=%d", appID, len(submitted), len(rejected))
+	if len(submitted) == 0 {
+		logging.Debugf("/api/jobs rejecting: len(urls)=%d appID=%q", len(submitted), appID)
+		msg := "missing urls"
+		for _, rj := range rejected {
+			msg += fmt.Sprintf("; line %d: %s (%s)", rj.Line, rj.Input, rj.Reason)
+		}
+		writeJSONError(w, 400, msg)
+		return
+	}
+
+	var urls []string
+	for _, su := range submitted {
+		if s.Cfg.StrictURLValidation && !isPublicURL(su.URL) {
+			logging.Infof("/api/jobs: rejecting URL (strict validation enabled): %q", logging.RedactURL(su.URL))
+			rejected = append(rejected, rejectedURL{Line: su.Line, Input: su.Input, Reason: "not a public URL"})
+			continue
+		}
+		urls = append(urls, su.URL)
+	}
+
+	if len(urls) == 0 {
+		logging.Debugf("/api/jobs: all URLs were filtered out")
+		msg := "no valid public URLs provided"
+		for _, rj := range rejected {
+			msg += fmt.Sprintf("; line %d: %s (%s)", rj.Line, rj.Input, rj.Reason)
+		}
+		writeJSONError(w, 400, msg)
+		return
+	}
+
+	// Create one job per URL (single-URL-per-job model)
+	var ids []int64
+	var errors []string
+
+	for _, u := range urls {
+		app, err := s.appForURL(appID, u)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
+			errors = append(errors, err.Error())
+			continue
 		}
-		if jobsList == nil {
-			jobsList = []store.Job{}
+		jid, err := s.enqueueJob(app, jobSubmission{URL: u})
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("failed to insert job for %s: %v", u, err))
+			continue
 		}
+		ids = append(ids, jid)
+	}
+
+	if len(ids) == 0 && len(errors) > 0 {
+		writeJSONError(w, 400, strings.Join(errors, "; "))
+		return
+	}
+	writeSubmitResult(w, ids, rejected, errors)
+}
+
+// jobSubmission is one item of a JSON batch submitted to POST /api/jobs.
+type jobSubmission struct {
+	URL string `json:"url"`
+	// AppID picks the app; empty or "auto" matches it by URL like the form does.
+	AppID string `json:"app_id"`
+	// Title, if set, is kept instead of the one from page metadata.
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+}
+
+// maxSubmitJSONBytes caps the size of a JSON batch submission.
+const maxSubmitJSONBytes = 1 << 20
+
+// handleSubmitJobsJSON takes a JSON array of jobSubmission, for scripts that
+// want a different app, title or tags per URL. The response is shaped like the
+// form's; "line" in rejected entries is the item's 1-based position.
+func (s *Server) handleSubmitJobsJSON(w http.ResponseWriter, r *http.Request) {
+	var items []jobSubmission
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSubmitJSONBytes)).Decode(&items); err != nil {
+		writeJSONError(w, 400, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+	logging.Debugf("/api/jobs POST json items=%d", len(items))
+	if len(items) == 0 {
+		writeJSONError(w, 400, "missing urls")
+		return
+	}
+
+	seen := map[string]struct{}{}
+	var ids []int64
+	var rejected []rejectedURL
+	var errors []string
+	for i, item := range items {
+		raw := strings.TrimSpace(item.URL)
+		u, reason := parseSubmittedURL(raw)
+		if reason == "" {
+			key := s.Cfg.URLNormalization.NormalizeURL(u)
+			if _, ok := seen[key]; ok {
+				reason = "duplicate"
+			}
+			seen[key] = struct{}{}
+		}
+		if reason != "" {
+			rejected = append(rejected, rejectedURL{Line: i + 1, Input: raw, Reason: reason})
+			continue
+		}
+
+		item.URL = u.String()
+		if s.Cfg.StrictURLValidation && !isPublicURL(item.URL) {
+			logging.Infof("/api/jobs: rejecting URL (strict validation enabled): %q", logging.RedactURL(item.URL))
+			errors = append(errors, fmt.Sprintf("not a public URL: %s", item.URL))
+			continue
+		}
+		app, err := s.appForURL(item.AppID, item.URL)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		item.Title = strings.TrimSpace(item.Title)
+		item.Tags = cleanTags(item.Tags)
+		jid, err := s.enqueueJob(app, item)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("failed to insert job for %s: %v", item.URL, err))
+			continue
+		}
+		ids = append(ids, jid)
+	}
+
+	if len(ids) == 0 {
+		msg := "no jobs created"
+		for _, rj := range rejected {
+			msg += fmt.Sprintf("; item %d: %s (%s)", rj.Line, rj.Input, rj.Reason)
+		}
+		for _, e := range errors {
+			msg += "; " + e
+		}
+		writeJSONError(w, 400, msg)
+		return
+	}
+	writeSubmitResult(w, ids, rejected, errors)
+}
+
+// writeSubmitResult reports the jobs created by a submission, plus whatever was
+// skipped along the way.
+func writeSubmitResult(w http.ResponseWriter, ids []int64, rejected []rejectedURL, errors []string) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]any{"ids": ids}
+	if len(rejected) > 0 {
+		resp["rejected"] = rejected
+	}
+	if len(errors) > 0 {
+		resp["errors"] = errors
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// appForURL resolves the app a submitted URL runs with: appID, or the first app
+// matching the URL when appID is empty or "auto". The host policy is checked too.
+func (s *Server) appForURL(appID, u string) (*config.AppConfig, error) {
+	if appID == "auto" || appID == "" {
+		a := s.Cfg.MatchAppForURL(u)
+		if a == nil {
+			logging.Debugf("/api/jobs: could not auto-match app for url=%q", logging.RedactURL(u))
+			return nil, fmt.Errorf("could not auto-match app for url: %s", u)
+		}
+		appID = a.ID
+	}
+
+	app := s.Cfg.GetApp(appID)
+	if app == nil {
+		logging.Warnf("/api/jobs unknown app_id=%q for url=%q", appID, logging.RedactURL(u))
+		return nil, fmt.Errorf("unknown app_id=%q for url: %s", appID, u)
+	}
+
+	if pu, err := url.Parse(u); err == nil {
+		if err := app.CheckHost(pu.Hostname()); err != nil {
+			logging.Infof("/api/jobs: rejecting url=%q: %v", logging.RedactURL(u), err)
+			return nil, fmt.Errorf("%v: %s", err, u)
+		}
+	}
+	return app, nil
+}
+
+// cleanTags trims tags and drops empty and repeated ones, keeping their order.
+func cleanTags(tags []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// enqueueJob inserts a new job for sub.URL, queues it and kicks off its
+// metadata fetch. The title and tags are stored before the job is queued, so
+// the job runs with them.
+func (s *Server) enqueueJob(app *config.AppConfig, sub jobSubmission) (int64, error) {
+	u := sub.URL
+	jid, err := store.InsertJob(s.DB, app.ID, app.Name, u, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if sub.Title != "" {
+		if err := store.SetJobCustomTitle(s.DB, jid, sub.Title); err != nil {
+			logging.Errorf("failed to set title of job %d: %v", jid, err)
+		}
+	}
+	if len(sub.Tags) > 0 {
+		if err := store.SetJobTags(s.DB, jid, sub.Tags); err != nil {
+			logging.Errorf("failed to set tags of job %d: %v", jid, err)
+		}
+	}
+	s.Mgr.Queue <- jid
+	s.Mgr.BroadcastJobSnapshot(jid)
+	if app.ShouldFetchMetadata() {
+		go s.Mgr.FetchAndSaveMetadata(jid, u)
+	} else if err := store.MarkMetadataFetched(s.DB, jid); err != nil {
+		logging.Errorf("failed to mark job %d as not needing metadata: %v", jid, err)
+	}
+	return jid, nil
+}
+
+// writeJobsJSON streams the jobs list straight from the database cursor as a
+// JSON array, matching what json.Encoder would produce for the whole slice.
+func (s *Server) writeJobsJSON(w http.ResponseWriter, limit int) {
+	started := false
+	err := store.EachJob(s.DB, limit, func(j *store.Job) error {
+		b, err := json.Marshal(j)
+		if err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("["))
+			started = true
+		} else {
+			w.Write([]byte(","))
+		}
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		if !started {
+			writeJSONError(w, 500, err.Error())
+			return
+		}
+		// Headers are already out; the truncated array tells the client something broke.
+		logging.Errorf("error streaming jobs list: %v", err)
+		return
+	}
+	if !started {
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(jobsList)
-	case http.MethodPost:
-		// Use FormValue so Go handles both urlencoded and multipart/form-data.
-		appID := r.FormValue("app_id")
-		urlsRaw := r.FormValue("urls")
-		log.Printf("/api/jobs POST app_id=%q urlsRaw=%q", appID, urlsRaw)
-		log.Printf("/api/jobs PostForm=%v MultipartForm=%v", r.PostForm, r.MultipartForm)
-
-		urls := splitURLs(urlsRaw)
-		if len(urls) == 0 {
-			log.Printf("/api/jobs rejecting: len(urls)=%d appID=%q", len(urls), appID)
-			http.Error(w, "missing urls", 400)
+		w.Write([]byte("[]\n"))
+		return
+	}
+	w.Write([]byte("]\n"))
+}
+
+// jobsListLimit reads the optional ?limit= param, falling back to the configured
+// default and capping at config.MaxJobsListLimit.
+func (s *Server) jobsListLimit(r *http.Request) (int, error) {
+	limit := s.Cfg.JobsListLimit
+	if limit <= 0 {
+		limit = config.DefaultJobsListLimit
+	}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid limit %q", raw)
+		}
+		limit = n
+	}
+	return min(limit, config.MaxJobsListLimit), nil
+}
+
+const (
+	// defaultActivityLimit and maxActivityLimit bound GET /api/activity pages.
+	defaultActivityLimit = 100
+	maxActivityLimit     = 1000
+)
+
+// handleActivity serves a history feed of job and file events, newest first,
+// for catching up without the websocket. ?since= and ?before= (RFC 3339 or unix
+// seconds) bound it; a full page's next_cursor, passed as ?cursor=, fetches the
+// one after it.
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err := parseTimeParam(q.Get("since"))
+	if err != nil {
+		writeJSONError(w, 400, fmt.Sprintf("invalid since: %v", err))
+		return
+	}
+	before, err := parseTimeParam(q.Get("before"))
+	if err != nil {
+		writeJSONError(w, 400, fmt.Sprintf("invalid before: %v", err))
+		return
+	}
+	limit := defaultActivityLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeJSONError(w, 400, fmt.Sprintf("invalid limit %q", raw))
+			return
+		}
+		limit = min(n, maxActivityLimit)
+	}
+	var after *store.ActivityCursor
+	if raw := q.Get("cursor"); raw != "" {
+		if after, err = parseActivityCursor(raw); err != nil {
+			writeJSONError(w, 400, fmt.Sprintf("invalid cursor %q", raw))
 			return
 		}
+	}
 
-		if s.Cfg.StrictURLValidation {
-			var validURLs []string
-			for _, u := range urls {
-				if isPublicURL(u) {
-					validURLs = append(validURLs, u)
-				} else {
-					log.Printf("/api/jobs: rejecting URL (strict validation enabled): %q", u)
-				}
+	events, err := store.ListActivity(s.DB, since, before, after, limit)
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	resp := map[string]any{}
+	if len(events) == limit {
+		// Taken before paths are made relative, as the cursor compares stored ones.
+		resp["next_cursor"] = encodeActivityCursor(events[len(events)-1].Cursor())
+	}
+	for i, e := range events {
+		if e.Path != "" {
+			events[i].Path = toRelPath(filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", e.JobID)), e.Path)
+		}
+		events[i].At = store.DisplayTime(e.At)
+	}
+	resp["events"] = events
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// encodeActivityCursor makes c opaque and safe to pass back in a query string.
+func encodeActivityCursor(c store.ActivityCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseActivityCursor(raw string) (*store.ActivityCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c store.ActivityCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// parseTimeParam parses an RFC 3339 time or unix seconds; "" is the zero time.
+func parseTimeParam(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, raw)
+}
+
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := store.ResetJobForRetry(s.DB, id); err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	s.Mgr.Queue <- id
+	s.Mgr.BroadcastJobSnapshot(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxRetryFailedUnconfirmed is how many failed jobs retry-failed requeues
+// without ?confirm=true, so a stray request can't restart a whole history.
+const maxRetryFailedUnconfirmed = 25
+
+// maxRetryFailed is how many failed jobs retry-failed requeues even with
+// ?confirm=true; larger histories are retried in slices via app_id and since.
+const maxRetryFailed = 1000
+
+// handleRetryFailed retries every failed job that isn't archived, oldest first.
+// ?app_id= limits it to one app and ?since= (e.g. "2h") to recent failures.
+func (s *Server) handleRetryFailed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	appID := q.Get("app_id")
+	if appID != "" && s.Cfg.GetApp(appID) == nil {
+		writeJSONError(w, 400, fmt.Sprintf("unknown app_id=%q", appID))
+		return
+	}
+	var since time.Time
+	if raw := q.Get("since"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			writeJSONError(w, 400, fmt.Sprintf("invalid since %q", raw))
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+
+	ids, err := store.ListFailedJobIDs(s.DB, appID, since)
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	if len(ids) > maxRetryFailed {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("%d failed jobs match; narrow them down with app_id or since to at most %d", len(ids), maxRetryFailed))
+		return
+	}
+	if len(ids) > maxRetryFailedUnconfirmed && q.Get("confirm") != "true" {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("%d failed jobs match; pass confirm=true to retry more than %d at once", len(ids), maxRetryFailedUnconfirmed))
+		return
+	}
+
+	requeued := []int64{}
+	for _, id := range ids {
+		// Another retry may have picked the job up since it was listed.
+		var reset bool
+		if reset, err = store.ResetFailedJobForRetry(s.DB, id); err != nil {
+			break
+		}
+		if !reset {
+			continue
+		}
+		requeued = append(requeued, id)
+		s.Mgr.BroadcastJobSnapshot(id)
+	}
+	// The queue holds fewer jobs than may be retried here; feed it in order
+	// without holding up the response.
+	go func() {
+		for _, id := range requeued {
+			s.Mgr.Queue <- id
+		}
+	}()
+	logging.Infof("retry-failed: requeued %d jobs", len(requeued))
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		// The jobs reset before the error are already queued; say which.
+		w.WriteHeader(500)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": err.Error(), "ids": requeued})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string][]int64{"ids": requeued})
+}
+
+// handleClone runs a job's URL again as a brand-new job. Unlike retry, it leaves
+// the original job (and its files) untouched.
+func (s *Server) handleClone(w http.ResponseWriter, r *http.Request, id int64) {
+	j, err := store.GetJob(s.DB, id)
+	if err != nil {
+		writeJSONError(w, 404, "job not found")
+		return
+	}
+	// The app's host policy may have changed since the job was submitted.
+	app, err := s.appForURL(j.AppID, j.OriginalURL)
+	if err != nil {
+		writeJSONError(w, 400, err.Error())
+		return
+	}
+	sub := jobSubmission{URL: j.OriginalURL, Tags: j.Tags}
+	if j.CustomTitle {
+		sub.Title = j.Title
+	}
+	jid, err := s.enqueueJob(app, sub)
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int64{"id": jid})
+}
+
+// handleCancel stops a queued or running job. Its partial files are kept
+// unless ?cleanup=true, which removes them once the job has stopped.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request, id int64) {
+	cleanup := r.URL.Query().Get("cleanup") == "true"
+	if err := s.Mgr.CancelJob(id); err != nil {
+		writeJSONError(w, 400, err.Error())
+		return
+	}
+	if cleanup {
+		if err := s.cleanupCancelled(r.Context(), id); err != nil {
+			writeJSONError(w, 500, err.Error())
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cleanupCancelled waits for a cancelled job to stop (a kill grace lets the
+// command clean up first) and then removes its directory and forgets its files.
+func (s *Server) cleanupCancelled(ctx context.Context, id int64) error {
+	j, err := store.GetJob(s.DB, id)
+	if err != nil {
+		return err
+	}
+	timeout := s.Cfg.KillGraceFor(s.Cfg.GetApp(j.AppID)) + cancelCleanupTimeout
+	if _, err := s.awaitJob(ctx, id, timeout); err != nil {
+		return fmt.Errorf("job %d did not stop, keeping its files: %v", id, err)
+	}
+	if err := s.deleteJobArtifacts(id); err != nil {
+		return err
+	}
+	if err := store.DeleteJobFiles(s.DB, id); err != nil {
+		return err
+	}
+	logging.Infof("job %d: cancelled and removed its partial files", id)
+	s.Mgr.BroadcastJobSnapshot(id)
+	return nil
+}
+
+func (s *Server) handleRefetch(w http.ResponseWriter, r *http.Request, id int64) {
+	j, err := store.GetJob(s.DB, id)
+	if err != nil {
+		writeJSONError(w, 404, "job not found")
+		return
+	}
+	go s.Mgr.RefetchMetadata(id, j.OriginalURL)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := store.ArchiveJob(s.DB, id); err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	s.Mgr.BroadcastJobSnapshot(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCleanup(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := store.MarkJobCleaned(s.DB, id); err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	if err := s.deleteJobArtifacts(id); err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	s.Mgr.BroadcastJobSnapshot(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleZip(w http.ResponseWriter, r *http.Request, jobID int64) {
+	j, err := store.GetJob(s.DB, jobID)
+	if err != nil {
+		writeJSONError(w, 404, "job not found")
+		return
+	}
+	present, missing, err := s.archiveFiles(jobID, r.Method != http.MethodHead)
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	if len(present) == 0 && len(missing) == 0 {
+		writeJSONError(w, 404, "no files for job")
+		return
+	}
+	if len(present) == 0 {
+		writeJSONError(w, http.StatusGone, "all files for this job were removed from disk")
+		return
+	}
+
+	jobDir := filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID))
+
+	safeTitle := slug.Parameterize(j.Title, fmt.Sprintf("job-%d", jobID), s.Cfg.TransliterateFilenames)
+	setDownloadHeaders(w, safeTitle+".zip")
+	if len(missing) > 0 {
+		w.Header().Set("X-Missing-Files", strconv.Itoa(len(missing)))
+	}
+	w.Header().Set("Content-Type", archiveContentTypes["zip"])
+	// Archives are streamed, so HEAD gets no Content-Length and there's nothing
+	// to build.
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	zw := newZipWriter(w, jobDir)
+	defer zw.Close()
+	addToArchive(zw, jobDir, present, missing)
+}
+
+// archiveFiles lists the job's files, split into those on disk and those
+// deleted out-of-band. It checks before the 200 goes out, so the response can
+// say which ones are missing. With prune set, the missing ones are also dropped
+// from the database; HEAD requests leave them be, as HEAD must not change state.
+func (s *Server) archiveFiles(jobID int64, prune bool) (present []store.JobFile, missing []string, err error) {
+	files, err := store.ListJobFiles(s.DB, jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f.Path); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, f.Path)
+			continue
+		}
+		present = append(present, f)
+	}
+	if prune && len(missing) > 0 {
+		s.pruneMissingFiles(jobID, missing)
+	}
+	return present, missing, nil
+}
+
+// addToArchive writes one job's files, plus a MISSING.txt note listing the
+// files that were removed from disk.
+func addToArchive(aw archiveWriter, jobDir string, present []store.JobFile, missing []string) {
+	for _, f := range present {
+		if !resolvesWithin(jobDir, f.Path) {
+			logging.Warnf("archive file %s: resolves outside %s, skipping", f.Path, jobDir)
+			continue
+		}
+		if err := aw.AddFile(f.Path); err != nil {
+			logging.Errorf("archive file %s: %v", f.Path, err)
+		}
+	}
+	if len(missing) > 0 {
+		var note strings.Builder
+		note.WriteString("These files were removed from disk and are not included:\n")
+		for _, p := range missing {
+			note.WriteString(toRelPath(jobDir, p) + "\n")
+		}
+		if err := aw.AddNote("MISSING.txt", note.String()); err != nil {
+			logging.Errorf("archive note: %v", err)
+		}
+	}
+}
+
+// maxBulkArchiveJobs caps how many jobs one bulk archive request may include.
+const maxBulkArchiveJobs = 100
+
+// handleBulkArchive streams the files of several jobs (?ids=1,2,3) as one zip
+// or tar.gz, each job in a folder named after its title. Jobs without files on
+// disk are left out.
+func (s *Server) handleBulkArchive(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := parseJobIDs(r.URL.Query().Get("ids"))
+		if err != nil {
+			writeJSONError(w, 400, err.Error())
+			return
+		}
+
+		type archivedJob struct {
+			dir, folder string
+			present     []store.JobFile
+			missing     []string
+		}
+		var included []archivedJob
+		folders := map[string]bool{}
+		missingCount := 0
+		for _, id := range ids {
+			j, err := store.GetJob(s.DB, id)
+			if err != nil {
+				writeJSONError(w, 404, fmt.Sprintf("job %d not found", id))
+				return
+			}
+			present, missing, err := s.archiveFiles(id, r.Method != http.MethodHead)
+			if err != nil {
+				writeJSONError(w, 500, err.Error())
+				return
+			}
+			if len(present) == 0 {
+				continue
 			}
-			urls = validURLs
+			folder := slug.Parameterize(j.Title, fmt.Sprintf("job-%d", id), s.Cfg.TransliterateFilenames)
+			if folders[folder] {
+				folder = fmt.Sprintf("%s-%d", folder, id)
+			}
+			folders[folder] = true
+			missingCount += len(missing)
+			included = append(included, archivedJob{
+				dir:     filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", id)),
+				folder:  folder,
+				present: present,
+				missing: missing,
+			})
+		}
+		if len(included) == 0 {
+			writeJSONError(w, 404, "no files on disk for these jobs")
+			return
 		}
 
-		if len(urls) == 0 {
-			log.Printf("/api/jobs: all URLs were filtered out")
-			http.Error(w, "no valid public URLs provided", 400)
+		name := fmt.Sprintf("low-tide-%d-jobs-%s.%s", len(included), store.DisplayTime(time.Now()).Format("2006-01-02"), format)
+		setDownloadHeaders(w, name)
+		if missingCount > 0 {
+			w.Header().Set("X-Missing-Files", strconv.Itoa(missingCount))
+		}
+
+		w.Header().Set("Content-Type", archiveContentTypes[format])
+		if r.Method == http.MethodHead {
 			return
 		}
 
-		isAuto := appID == "auto" || appID == ""
+		var aw archiveWriter
+		if format == "zip" {
+			aw = newZipWriter(w, "")
+		} else {
+			aw = newTarGzWriter(w, "")
+		}
+		defer aw.Close()
+		for _, a := range included {
+			aw.SetRoot(a.dir, a.folder)
+			addToArchive(aw, a.dir, a.present, a.missing)
+		}
+	}
+}
+
+// parseJobIDs parses a comma-separated list of job ids, dropping repeats.
+func parseJobIDs(raw string) ([]int64, error) {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid job id %q", part)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("missing ids")
+	}
+	if len(ids) > maxBulkArchiveJobs {
+		return nil, fmt.Errorf("too many jobs: at most %d per archive", maxBulkArchiveJobs)
+	}
+	return ids, nil
+}
+
+func (s *Server) handleGetJobSnapshot(w http.ResponseWriter, r *http.Request, jobID int64) {
+	s.writeJobSnapshot(w, jobID, http.StatusOK)
+}
+
+// writeJobSnapshot responds with the job and its files, using code on success.
+func (s *Server) writeJobSnapshot(w http.ResponseWriter, jobID int64, code int) {
+	j, err := store.GetJob(s.DB, jobID)
+	if err != nil {
+		writeJSONError(w, 404, "job not found")
+		return
+	}
+
+	files, err := store.ListJobFiles(s.DB, jobID)
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+
+	jobDir := filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID))
 
-		// Create one job per URL (single-URL-per-job model)
-		var ids []int64
-		var errors []string
+	rel := make([]store.JobFile, 0, len(files))
+	for _, f := range files {
+		f.Path = toRelPath(jobDir, f.Path)
+		if f.Path == "" {
+			continue
+		}
+		rel = append(rel, f)
+	}
+	j.Files = rel
 
-		for _, u := range urls {
-			finalAppID := appID
-			if isAuto {
-				if a := s.Cfg.MatchAppForURL(u); a != nil {
-					finalAppID = a.ID
-				} else {
-					log.Printf("/api/jobs: could not auto-match app for url=%q", u)
-					errors = append(errors, fmt.Sprintf("could not auto-match app for url: %s", u))
-					continue
-				}
-			}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(j)
+}
 
-			if s.Cfg.GetApp(finalAppID) == nil {
-				log.Printf("/api/jobs unknown app_id=%q for url=%q", finalAppID, u)
-				errors = append(errors, fmt.Sprintf("unknown app_id=%q for url: %s", finalAppID, u))
-				continue
-			}
+const (
+	// defaultWaitTimeout is how long GET /api/jobs/{id}/wait blocks without ?timeout=.
+	defaultWaitTimeout = 60 * time.Second
+	// maxWaitTimeout caps ?timeout= for GET /api/jobs/{id}/wait.
+	maxWaitTimeout = 10 * time.Minute
+	// waitRecheckInterval re-reads the job while waiting, in case the event
+	// that finished it was dropped.
+	waitRecheckInterval = time.Second
+	// cancelCleanupTimeout is how long cancel?cleanup=true waits for the job to
+	// stop, on top of its kill grace.
+	cancelCleanupTimeout = 30 * time.Second
+)
 
-			jid, err := store.InsertJob(s.DB, finalAppID, u, time.Now())
-			if err != nil {
-				errors = append(errors, fmt.Sprintf("failed to insert job for %s: %v", u, err))
-				continue
-			}
-			ids = append(ids, jid)
-			s.Mgr.Queue <- jid
-			s.Mgr.BroadcastJobSnapshot(jid)
-			go s.Mgr.FetchAndSaveMetadata(jid, u)
+// handleWaitJob blocks until the job has finished (or was cancelled or cleaned)
+// and responds with its snapshot, for scripts that just want to wait for a job
+// without a websocket. If ?timeout= (e.g. "90s" or "90") passes first, the
+// current snapshot is sent with 504.
+func (s *Server) handleWaitJob(w http.ResponseWriter, r *http.Request, jobID int64) {
+	timeout := defaultWaitTimeout
+	if raw := r.URL.Query().Get("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if n, nerr := strconv.Atoi(raw); nerr == nil {
+			d, err = time.Duration(n)*time.Second, nil
 		}
-
-		if len(ids) == 0 && len(errors) > 0 {
-			http.Error(w, strings.Join(errors, "; "), 400)
+		if err != nil || d <= 0 {
+			writeJSONError(w, 400, fmt.Sprintf("invalid timeout %q", raw))
 			return
 		}
+		timeout = min(d, maxWaitTimeout)
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{"ids": ids})
+	j, err := s.awaitJob(r.Context(), jobID, timeout)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		s.writeJobSnapshot(w, jobID, http.StatusGatewayTimeout)
+	case r.Context().Err() != nil:
+	case err != nil:
+		writeJSONError(w, 404, "job not found")
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		s.writeJobSnapshot(w, j.ID, http.StatusOK)
 	}
 }
 
-func (s *Server) handleJobAction(w http.ResponseWriter, r *http.Request) {
-	// /api/jobs/{id}/{action} or /api/jobs/{id}/files/{fileid}
-	pathSuffix := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
-	parts := strings.Split(pathSuffix, "/")
+// errWaitTimeout is returned by awaitJob when the job is still going.
+var errWaitTimeout = errors.New("timed out waiting for job")
+
+// awaitJob blocks until jobID has finished and returns it, or until timeout
+// passes (errWaitTimeout) or ctx is done.
+func (s *Server) awaitJob(ctx context.Context, jobID int64, timeout time.Duration) (*store.Job, error) {
+	// Subscribe before the first check, so a job finishing in between still
+	// wakes us up.
+	events := s.Mgr.SubscribeState()
+	defer s.Mgr.UnsubscribeState(events)
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	recheck := time.NewTicker(waitRecheckInterval)
+	defer recheck.Stop()
 
-	if len(parts) == 1 {
-		// GET /api/jobs/{id}
-		idStr := parts[0]
-		id, err := strconv.ParseInt(idStr, 10, 64)
+	for {
+		j, err := store.GetJob(s.DB, jobID)
 		if err != nil {
-			http.Error(w, "invalid id", 400)
-			return
+			return nil, err
 		}
-		if r.Method == http.MethodGet {
-			s.handleGetJobSnapshot(w, r, id)
-			return
+		if j.Status.Finished() {
+			return j, nil
 		}
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+	wait:
+		for {
+			select {
+			case b := <-events:
+				if jobEventConcerns(b, jobID) {
+					break wait
+				}
+			case <-recheck.C:
+				break wait
+			case <-deadline.C:
+				return j, errWaitTimeout
+			case <-ctx.Done():
+				return j, ctx.Err()
+			}
+		}
+	}
+}
+
+// jobEventConcerns reports whether a state event may change jobID's status.
+// Log deltas are the bulk of the stream and never do.
+func jobEventConcerns(b []byte, jobID int64) bool {
+	var ev struct {
+		Type  string `json:"type"`
+		JobID int64  `json:"job_id"`
+		Job   *struct {
+			ID int64 `json:"id"`
+		} `json:"job"`
+	}
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return false
 	}
+	switch ev.Type {
+	case "job_done":
+		return ev.JobID == jobID
+	case "job_snapshot":
+		return ev.Job != nil && ev.Job.ID == jobID
+	case "job_snapshots":
+		return true
+	}
+	return false
+}
 
-	idStr := parts[0]
-	action := parts[1]
-	id, err := strconv.ParseInt(idStr, 10, 64)
+// handleChecksums writes "<sha256>  <path>" lines for the job's files, in the
+// format `sha256sum -c` reads from inside the job directory. Hashes recorded at
+// resync are reused while the file is unchanged; others are computed one file
+// at a time and cached.
+func (s *Server) handleChecksums(w http.ResponseWriter, r *http.Request, jobID int64) {
+	if _, err := store.GetJob(s.DB, jobID); err != nil {
+		writeJSONError(w, 404, "job not found")
+		return
+	}
+	files, err := store.ListJobFiles(s.DB, jobID)
 	if err != nil {
-		http.Error(w, "invalid id", 400)
+		writeJSONError(w, 500, err.Error())
 		return
 	}
+	jobDir := filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID))
 
-	switch action {
-	case "retry":
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		if err := store.ResetJobForRetry(s.DB, id); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		s.Mgr.Queue <- id
-		s.Mgr.BroadcastJobSnapshot(id)
-		w.WriteHeader(http.StatusNoContent)
-	case "cancel":
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		if err := s.Mgr.CancelJob(id); err != nil {
-			http.Error(w, err.Error(), 400)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	case "zip":
-		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, f := range files {
+		rel := strings.TrimPrefix(toRelPath(jobDir, f.Path), string(os.PathSeparator))
+		if rel == "" {
+			continue
 		}
-		s.handleZip(w, r, id)
-	case "logs":
-		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+		if !resolvesWithin(jobDir, f.Path) {
+			logging.Warnf("checksums: %s resolves outside %s, skipping", f.Path, jobDir)
+			continue
 		}
-		s.handleJobLogs(w, r, id)
-	case "files":
-		// If URL is /api/jobs/{id}/files -> manage files
-		// e.g. DELETE to remove all files for job
-		if len(parts) == 2 {
-			if r.Method == http.MethodDelete {
-				s.handleDeleteFiles(w, r, id)
-				return
-			}
-			http.NotFound(w, r)
-			return
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			logging.Warnf("checksums: skipping %s: %v", f.Path, err)
+			continue
 		}
-		// If URL is /api/jobs/{id}/files/{fid} -> serve file/dir download
-		if len(parts) >= 3 {
-			fidStr := parts[2]
-			fid, err := strconv.ParseInt(fidStr, 10, 64)
-			if err != nil {
-				http.Error(w, "invalid file id", 400)
-				return
+		sum := f.SHA256
+		if sum == "" || info.Size() != f.SizeBytes || !info.ModTime().Equal(f.CreatedAt) {
+			if sum, err = jobs.FileSHA256(f.Path); err != nil {
+				logging.Warnf("checksums: skipping %s: %v", f.Path, err)
+				continue
 			}
-			if r.Method != http.MethodGet {
-				w.WriteHeader(http.StatusMethodNotAllowed)
-				return
+			if info.Size() == f.SizeBytes && info.ModTime().Equal(f.CreatedAt) {
+				_ = store.UpdateJobFileHash(s.DB, f.ID, sum)
 			}
-			s.handleDownloadArtifact(w, r, id, fid)
-			return
 		}
-	case "archive":
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+		if _, err := fmt.Fprintf(w, "%s  %s\n", sum, rel); err != nil {
 			return
 		}
-		if err := store.ArchiveJob(s.DB, id); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		s.Mgr.BroadcastJobSnapshot(id)
-		w.WriteHeader(http.StatusNoContent)
-		return
-	case "cleanup":
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		if err := store.MarkJobCleaned(s.DB, id); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		if err := s.deleteJobArtifacts(id); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		s.Mgr.BroadcastJobSnapshot(id)
-		w.WriteHeader(http.StatusNoContent)
-		return
-	default:
-		http.NotFound(w, r)
 	}
 }
 
-func (s *Server) handleZip(w http.ResponseWriter, r *http.Request, jobID int64) {
-	j, err := store.GetJob(s.DB, jobID)
-	if err != nil {
-		http.Error(w, "job not found", 404)
+// dupeGroup is a set of a job's files with identical content.
+type dupeGroup struct {
+	SHA256    string   `json:"sha256"`
+	SizeBytes int64    `json:"size_bytes"`
+	Files     []string `json:"files"`
+}
+
+// handleDupes reports a job's files grouped by content hash, listing only groups
+// with more than one file. Files that haven't been hashed are left out.
+func (s *Server) handleDupes(w http.ResponseWriter, r *http.Request, jobID int64) {
+	if _, err := store.GetJob(s.DB, jobID); err != nil {
+		writeJSONError(w, 404, "job not found")
 		return
 	}
 	files, err := store.ListJobFiles(s.DB, jobID)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	if len(files) == 0 {
-		http.Error(w, "no files for job", 404)
+		writeJSONError(w, 500, err.Error())
 		return
 	}
-
 	jobDir := filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID))
 
-	safeTitle := parameterize(j.Title, fmt.Sprintf("job-%d", jobID))
-	setDownloadHeaders(w, safeTitle+".zip")
-
-	zw := newZipWriter(w, jobDir)
-	defer zw.Close()
-
+	var order []string
+	byHash := make(map[string]*dupeGroup)
 	for _, f := range files {
-		if err := zw.AddFile(f.Path); err != nil {
-			log.Printf("zip file %s: %v", f.Path, err)
+		rel := toRelPath(jobDir, f.Path)
+		if f.SHA256 == "" || rel == "" || !resolvesWithin(jobDir, f.Path) {
+			continue
+		}
+		g, ok := byHash[f.SHA256]
+		if !ok {
+			g = &dupeGroup{SHA256: f.SHA256, SizeBytes: f.SizeBytes}
+			byHash[f.SHA256] = g
+			order = append(order, f.SHA256)
+		}
+		g.Files = append(g.Files, rel)
+	}
+
+	groups := []dupeGroup{}
+	var wasted int64
+	for _, h := range order {
+		if g := byHash[h]; len(g.Files) > 1 {
+			groups = append(groups, *g)
+			wasted += g.SizeBytes * int64(len(g.Files)-1)
 		}
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"groups": groups, "wasted_bytes": wasted})
 }
 
-func (s *Server) handleGetJobSnapshot(w http.ResponseWriter, r *http.Request, jobID int64) {
-	j, err := store.GetJob(s.DB, jobID)
-	if err != nil {
-		http.Error(w, "job not found", 404)
+// rawJobFile is a job_files row as stored, for operators. Safe is false when the
+// path resolves outside the job dir; the regular API hides those rows.
+type rawJobFile struct {
+	store.JobFile
+	RelPath string `json:"rel_path,omitempty"`
+	Safe    bool   `json:"safe"`
+}
+
+// handleRawJobFiles lists a job's stored file rows with absolute paths, flagging
+// the ones handleGetJobSnapshot would drop so they can be cleaned up.
+func (s *Server) handleRawJobFiles(w http.ResponseWriter, r *http.Request, jobID int64) {
+	if _, err := store.GetJob(s.DB, jobID); err != nil {
+		writeJSONError(w, 404, "job not found")
 		return
 	}
-
 	files, err := store.ListJobFiles(s.DB, jobID)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	jobDir, err := filepath.Abs(filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID)))
+	if err != nil {
+		writeJSONError(w, 500, "internal error")
 		return
 	}
 
-	jobDir := filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID))
-
-	rel := make([]store.JobFile, 0, len(files))
+	out := make([]rawJobFile, 0, len(files))
 	for _, f := range files {
-		f.Path = toRelPath(jobDir, f.Path)
-		if f.Path == "" {
-			continue
-		}
-		rel = append(rel, f)
+		rel := toRelPath(jobDir, f.Path)
+		out = append(out, rawJobFile{JobFile: f, RelPath: rel, Safe: rel != ""})
 	}
-	j.Files = rel
-
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(j)
+	_ = json.NewEncoder(w).Encode(map[string]any{"job_dir": jobDir, "files": out})
 }
 
+// handleJobLogs returns the job's rendered log. ?format=lines returns it as a
+// JSON line map instead, shaped like the live "job_log" deltas, and ?format=ansi
+// as text with color escapes for viewing in a terminal.
 func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request, jobID int64) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "html":
+	case "lines":
+		lines, ok := s.Mgr.GetJobLogLines(jobID)
+		if !ok {
+			writeJSONError(w, 404, "logs not available")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"job_id": jobID, "lines": lines})
+		return
+	case "ansi":
+		text, ok := s.Mgr.GetJobLogANSI(jobID)
+		if !ok {
+			writeJSONError(w, 404, "logs not available")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, text)
+		return
+	default:
+		writeJSONError(w, 400, fmt.Sprintf("unknown format %q", format))
+		return
+	}
+
 	logs := s.Mgr.GetJobLogBuffer(jobID)
 	if logs == nil {
-		http.Error(w, "logs not available", 404)
+		writeJSONError(w, 404, "logs not available")
 		return
 	}
 
@@ -373,31 +1365,58 @@ func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request, jobID int
 	_, _ = w.Write(logs)
 }
 
-func (s *Server) handleDownloadArtifact(w http.ResponseWriter, r *http.Request, jobID int64, fid int64) {
+func (s *Server) handleDownloadArtifact(w http.ResponseWriter, r *http.Request, jobID int64) {
+	fid, err := strconv.ParseInt(r.PathValue("fid"), 10, 64)
+	if err != nil {
+		writeJSONError(w, 400, "invalid file id")
+		return
+	}
 	if f, err := store.GetJobFileByID(s.DB, fid); err == nil {
 		if f.JobID != jobID {
-			http.Error(w, "file not part of job", 404)
+			writeJSONError(w, 404, "file not part of job")
 			return
 		}
 
 		jobDir := filepath.Join(s.Cfg.DownloadsDir, fmt.Sprintf("%d", jobID))
 		absJobDir, err := filepath.Abs(jobDir)
 		if err != nil {
-			http.Error(w, "internal error", 500)
+			writeJSONError(w, 500, "internal error")
 			return
 		}
 
 		// Security: ensure path is under the job's downloads dir
 		rel, err := filepath.Rel(absJobDir, f.Path)
 		if err != nil || strings.HasPrefix(rel, "..") {
-			http.Error(w, "invalid path", 400)
+			writeJSONError(w, 400, "invalid path")
+			return
+		}
+		if _, err := os.Stat(f.Path); errors.Is(err, fs.ErrNotExist) {
+			s.pruneMissingFiles(jobID, []string{f.Path})
+			writeJSONError(w, http.StatusGone, "file was removed from disk")
+			return
+		}
+		// The job's command could have left a symlink pointing anywhere.
+		if !resolvesWithin(absJobDir, f.Path) {
+			writeJSONError(w, 400, "invalid path")
 			return
 		}
 		setDownloadHeaders(w, f.Path)
 		http.ServeFile(w, r, f.Path)
 		return
 	}
-	http.NotFound(w, r)
+	writeJSONError(w, 404, "file not found")
+}
+
+// pruneMissingFiles drops job_files rows whose file was deleted outside Low Tide,
+// so clients stop offering them.
+func (s *Server) pruneMissingFiles(jobID int64, paths []string) {
+	for _, p := range paths {
+		logging.Warnf("job %d: %s is gone from disk, forgetting it", jobID, p)
+		if err := store.DeleteJobFileByPath(s.DB, jobID, p); err != nil {
+			logging.Errorf("job %d: failed to prune missing file %s: %v", jobID, p, err)
+		}
+	}
+	s.Mgr.BroadcastJobSnapshot(jobID)
 }
 
 func (s *Server) deleteJobArtifacts(jobID int64) error {
@@ -426,7 +1445,7 @@ func (s *Server) deleteJobArtifacts(jobID int64) error {
 
 func (s *Server) handleDeleteFiles(w http.ResponseWriter, r *http.Request, jobID int64) {
 	if err := s.deleteJobArtifacts(jobID); err != nil {
-		http.Error(w, err.Error(), 500)
+		writeJSONError(w, 500, err.Error())
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -434,14 +1453,34 @@ func (s *Server) handleDeleteFiles(w http.ResponseWriter, r *http.Request, jobID
 
 // State websocket: broadcasts job/file metadata updates to all clients
 func (s *Server) handleStateWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
+	if s.Cfg.ShouldCompressWS() {
+		level := s.Cfg.WSCompressionLevel
+		if level == 0 {
+			level = flate.BestSpeed
+		}
+		_ = conn.SetCompressionLevel(level)
+	}
 
-	ch := s.Mgr.SubscribeState()
+	// Tell the client where the stream starts. A reconnecting client passes the
+	// last seq (and boot) it saw; we can't replay, but we can tell it to resync.
+	ch, seq := s.Mgr.SubscribeStateSeq()
 	defer s.Mgr.UnsubscribeState(ch)
+	hello := map[string]any{"type": "hello", "seq": seq, "boot": s.BootTime}
+	if last := r.URL.Query().Get("last_seq"); last != "" {
+		lastSeq, err := strconv.ParseUint(last, 10, 64)
+		boot := r.URL.Query().Get("boot")
+		if err != nil || boot != strconv.FormatInt(s.BootTime, 10) || lastSeq != seq {
+			hello["resync"] = true
+		}
+	}
+	if err := conn.WriteJSON(hello); err != nil {
+		return
+	}
 
 	for b := range ch {
 		if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
@@ -450,15 +1489,301 @@ func (s *Server) handleStateWS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// thumbnailUploadOverhead leaves room for multipart headers and boundaries on
+// top of the thumbnail size cap.
+const thumbnailUploadOverhead = 64 << 10
+
+// handleUploadThumbnail replaces a job's thumbnail with an uploaded image.
+// The image can be sent as the raw request body or as the "image" field of a multipart form.
+func (s *Server) handleUploadThumbnail(w http.ResponseWriter, r *http.Request, jobID int64) {
+	if _, err := store.GetJob(s.DB, jobID); err != nil {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	// Bound the body before a multipart form gets spooled to disk; the cap on
+	// the image itself is enforced when it's read.
+	r.Body = http.MaxBytesReader(w, r.Body, s.Mgr.MaxThumbnailBytes()+thumbnailUploadOverhead)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("image")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, jobs.ErrImageTooLarge.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "missing image")
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	if _, err := s.Mgr.SaveUploadedThumbnail(jobID, body); err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotImage):
+			writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, jobs.ErrImageTooLarge):
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Mgr.QueueStatus())
+}
+
+// diskStatus is the response of GET /api/disk. The filesystem figures are nil
+// where statfs isn't available.
+type diskStatus struct {
+	Path           string  `json:"path"`
+	UsedBytes      int64   `json:"used_bytes"`
+	TotalBytes     *uint64 `json:"total_bytes,omitempty"`
+	FreeBytes      *uint64 `json:"free_bytes,omitempty"`
+	AvailableBytes *uint64 `json:"available_bytes,omitempty"`
+}
+
+// handleDisk reports the space used under downloads_dir and, where supported,
+// the size and free space of the filesystem it lives on.
+func (s *Server) handleDisk(w http.ResponseWriter, r *http.Request) {
+	st := diskStatus{Path: s.Cfg.DownloadsDir}
+	err := filepath.WalkDir(s.Cfg.DownloadsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			st.UsedBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	if total, free, avail, ok := fsSpace(s.Cfg.DownloadsDir); ok {
+		st.TotalBytes, st.FreeBytes, st.AvailableBytes = &total, &free, &avail
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(st)
+}
+
+// handlePause stops the worker from starting new jobs and reports the queue status.
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.Mgr.Pause()
+	s.handleQueue(w, r)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.Mgr.Resume()
+	s.handleQueue(w, r)
+}
+
+func (s *Server) handleVacuum(w http.ResponseWriter, r *http.Request) {
+	var before, after int64
+	err := s.Mgr.WhileIdle(func() error {
+		var err error
+		before, after, err = store.Vacuum(s.DB)
+		return err
+	})
+	if errors.Is(err, jobs.ErrBusy) {
+		writeJSONError(w, http.StatusConflict, "a job is running; try again when the queue is idle")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, 500, err.Error())
+		return
+	}
+	logging.Infof("vacuum: database %d -> %d bytes", before, after)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int64{
+		"size_before": before,
+		"size_after":  after,
+	})
+}
+
+func (s *Server) handleListApps(w http.ResponseWriter, r *http.Request) {
+	apps := s.Cfg.AppList()
+	if apps == nil {
+		apps = []config.AppConfig{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(apps)
+}
+
+func (s *Server) handleCreateApp(w http.ResponseWriter, r *http.Request) {
+	var app config.AppConfig
+	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+		writeJSONError(w, 400, "invalid JSON: "+err.Error())
+		return
+	}
+	s.editApps(w, func(apps []config.AppConfig) ([]config.AppConfig, int, error) {
+		return append(apps, app), http.StatusCreated, nil
+	}, &app)
+}
+
+func (s *Server) handleGetApp(w http.ResponseWriter, r *http.Request) {
+	app := s.Cfg.GetApp(r.PathValue("id"))
+	if app == nil {
+		writeJSONError(w, 404, "app not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(app)
+}
+
+// appPreview is the command an app would run for a URL.
+type appPreview struct {
+	AppID       string   `json:"app_id"`
+	URL         string   `json:"url"`
+	Command     string   `json:"command"`
+	Args        []string `json:"args"`
+	CommandLine string   `json:"command_line"`
+	JobDir      string   `json:"job_dir"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// handleAppPreview shows what an app would run for ?url= without running it,
+// built the same way the worker builds it. The job ID isn't known yet, so the
+// job dir has "<id>" in its place.
+func (s *Server) handleAppPreview(w http.ResponseWriter, r *http.Request) {
+	app := s.Cfg.GetApp(r.PathValue("id"))
+	if app == nil {
+		writeJSONError(w, 404, "app not found")
+		return
+	}
+	submitted, rejected := splitURLs(r.URL.Query().Get("url"), s.Cfg.URLNormalization)
+	if len(submitted) != 1 {
+		msg := "url must be a single http(s) URL"
+		if len(rejected) > 0 {
+			msg += ": " + rejected[0].Reason
+		}
+		writeJSONError(w, 400, msg)
+		return
+	}
+	root, err := filepath.Abs(s.Cfg.DownloadsDir)
+	if err != nil {
+		writeJSONError(w, 500, "internal error")
+		return
+	}
+	jobDir := filepath.Join(root, "<id>")
+	u := submitted[0].URL
+	args := jobs.ExpandArgs(app, u, jobDir)
+	p := appPreview{
+		AppID:       app.ID,
+		URL:         u,
+		Command:     app.Command,
+		Args:        args,
+		CommandLine: jobs.ShellJoin(append([]string{app.Command}, args...)),
+		JobDir:      jobDir,
+	}
+	if pu, err := url.Parse(u); err == nil {
+		if err := app.CheckHost(pu.Hostname()); err != nil {
+			p.Warnings = append(p.Warnings, err.Error())
+		}
+	}
+	if !app.TakesURL() {
+		p.Warnings = append(p.Warnings, "no arg contains %u, so the command isn't given the URL")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// handlePutApp replaces an inline app; the ID in the path wins if the body has none.
+func (s *Server) handlePutApp(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var app config.AppConfig
+	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+		writeJSONError(w, 400, "invalid JSON: "+err.Error())
+		return
+	}
+	if app.ID == "" {
+		app.ID = id
+	}
+	s.editApps(w, func(apps []config.AppConfig) ([]config.AppConfig, int, error) {
+		i, status, err := inlineAppIndex(apps, id)
+		if err != nil {
+			return nil, status, err
+		}
+		apps[i] = app
+		return apps, http.StatusOK, nil
+	}, &app)
+}
+
+func (s *Server) handleDeleteApp(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.editApps(w, func(apps []config.AppConfig) ([]config.AppConfig, int, error) {
+		i, status, err := inlineAppIndex(apps, id)
+		if err != nil {
+			return nil, status, err
+		}
+		return append(apps[:i], apps[i+1:]...), http.StatusNoContent, nil
+	}, nil)
+}
+
+// inlineAppIndex finds an app that can be edited through the API, or the status
+// to respond with if there is none.
+func inlineAppIndex(apps []config.AppConfig, id string) (int, int, error) {
+	i := appIndex(apps, id)
+	if i < 0 {
+		return -1, 404, errors.New("app not found")
+	}
+	if apps[i].FromAppsDir() {
+		return -1, http.StatusConflict, errors.New("app is defined in apps_dir; edit its file instead")
+	}
+	return i, 0, nil
+}
+
+// editApps applies edit to a copy of the app list, validates the result, writes
+// it to the config file and only then swaps it in. On success it responds with
+// the returned status and, if given, the edited app.
+func (s *Server) editApps(w http.ResponseWriter, edit func([]config.AppConfig) ([]config.AppConfig, int, error), result *config.AppConfig) {
+	s.appsMu.Lock()
+	defer s.appsMu.Unlock()
+
+	apps, code, err := edit(s.Cfg.AppList())
+	if err != nil {
+		writeJSONError(w, code, err.Error())
+		return
+	}
+	if err := (&config.Config{Apps: apps}).Validate(); err != nil {
+		writeJSONError(w, 400, err.Error())
+		return
+	}
+	if err := s.Cfg.SaveApps(apps); err != nil {
+		writeJSONError(w, 500, "save config: "+err.Error())
+		return
+	}
+	s.Cfg.SetApps(apps)
+	logging.Infof("apps: config updated (%d apps)", len(apps))
+
+	if result == nil {
+		w.WriteHeader(code)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func appIndex(apps []config.AppConfig, id string) int {
+	for i, a := range apps {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
 
+func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
 	const prefix = "/thumbnails/"
 	if !strings.HasPrefix(r.URL.Path, prefix) {
-		http.NotFound(w, r)
+		writeJSONError(w, http.StatusNotFound, "not found")
 		return
 	}
 
@@ -471,19 +1796,19 @@ func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
 
 	// reject empty, and reject any extra path segments
 	if jobIDStr == "" || strings.Contains(jobIDStr, "/") {
-		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid job ID")
 		return
 	}
 
 	jobID, err := strconv.ParseInt(jobIDStr, 10, 64)
 	if err != nil || jobID <= 0 {
-		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid job ID")
 		return
 	}
 
 	job, err := store.GetJob(s.DB, jobID)
 	if err != nil {
-		http.Error(w, "job not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "job not found")
 		return
 	}
 
@@ -491,7 +1816,7 @@ func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
 	// We need the raw path from the DB, but since we're inside the handler
 	// we just need to know if it HAS an image and what the job ID is.
 	if job.ImagePath == nil {
-		http.Error(w, "no image for this job", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "no image for this job")
 		return
 	}
 
@@ -501,7 +1826,7 @@ func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
 	thumbnailsDir := filepath.Join(s.Cfg.DownloadsDir, "thumbnails")
 	matches, _ := filepath.Glob(filepath.Join(thumbnailsDir, fmt.Sprintf("%d.*", jobID)))
 	if len(matches) == 0 {
-		http.Error(w, "image file not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "image file not found")
 		return
 	}
 
@@ -510,13 +1835,13 @@ func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
 
 	absRoot, err := filepath.Abs(s.Cfg.DownloadsDir)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
 
 	relCheck, err := filepath.Rel(absRoot, absPath)
 	if err != nil || relCheck == ".." || strings.HasPrefix(relCheck, ".."+string(filepath.Separator)) {
-		http.Error(w, "invalid image path", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid image path")
 		return
 	}
 
@@ -2,11 +2,14 @@
 package store
 
 import (
+	"cmp"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 )
@@ -23,6 +26,27 @@ const (
 	StatusCleaned   JobStatus = "cleaned"
 )
 
+// Finished reports whether a job in this state is done running: it succeeded,
+// failed, was cancelled or cleaned (until it is retried).
+func (s JobStatus) Finished() bool {
+	return s != StatusQueued && s != StatusRunning
+}
+
+// FailureKind says why a failed job failed, for filtering and stats; the job's
+// error_message has the details.
+type FailureKind string
+
+const (
+	FailureExitNonzero  FailureKind = "exit_nonzero"
+	FailureTimeout      FailureKind = "timeout"
+	FailureNoOutput     FailureKind = "no_output"
+	FailureUnknownApp   FailureKind = "unknown_app"
+	FailureTooManyFiles FailureKind = "too_many_files"
+	// FailureInternal covers Low Tide's own errors, like not being able to
+	// create the job directory or start the command.
+	FailureInternal FailureKind = "internal"
+)
+
 type Job struct {
 	ID           int64      `json:"id"`
 	AppID        string     `json:"app_id"`
@@ -38,8 +62,32 @@ type Job struct {
 	OriginalURL  string     `json:"original_url"`
 	Title        string     `json:"title"`
 	ImagePath    *string    `json:"image_path,omitempty"`
+	AppName      string     `json:"app_name,omitempty"`
+	CommandLine  string     `json:"command_line,omitempty"`
 	Logs         string     `json:"logs,omitempty"`
 	Files        []JobFile  `json:"files,omitempty"`
+
+	// CustomTitle is set when the title was given at submission; page metadata
+	// doesn't replace it.
+	CustomTitle bool     `json:"custom_title,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// RunEnv records how the command was last started, to reproduce it.
+	RunEnv *RunEnv `json:"run_env,omitempty"`
+	// FailureKind is set for failed jobs.
+	FailureKind FailureKind `json:"failure_kind,omitempty"`
+}
+
+// RunEnv is the context a job's command ran in, beyond its command line.
+type RunEnv struct {
+	// Command is the resolved executable path; Args excludes it.
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Dir     string   `json:"dir"`
+	// Env holds only the variables added on top of the server's environment,
+	// with credential-looking values redacted.
+	Env  map[string]string `json:"env,omitempty"`
+	Rows int               `json:"rows"`
+	Cols int               `json:"cols"`
 }
 
 type JobFile struct {
@@ -48,6 +96,44 @@ type JobFile struct {
 	Path      string    `json:"path"`
 	SizeBytes int64     `json:"size_bytes"`
 	CreatedAt time.Time `json:"created_at"`
+	// SHA256 is the hex content hash, recorded when the job's files are resynced.
+	// Empty for files that haven't been hashed (yet) or are too large to hash.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// displayLocation is the zone job timestamps are rendered in when marshaled to
+// JSON; nil leaves them as read from the database.
+var displayLocation *time.Location
+
+// SetDisplayLocation sets the zone used for job timestamps in JSON output.
+func SetDisplayLocation(loc *time.Location) {
+	displayLocation = loc
+}
+
+// DisplayTime converts t to the configured display zone.
+func DisplayTime(t time.Time) time.Time {
+	if displayLocation == nil {
+		return t
+	}
+	return t.In(displayLocation)
+}
+
+// MarshalJSON renders the job with its timestamps in the display zone.
+func (j Job) MarshalJSON() ([]byte, error) {
+	type plainJob Job
+	p := plainJob(j)
+	if displayLocation != nil {
+		p.CreatedAt = DisplayTime(p.CreatedAt)
+		if p.StartedAt != nil {
+			t := DisplayTime(*p.StartedAt)
+			p.StartedAt = &t
+		}
+		if p.FinishedAt != nil {
+			t := DisplayTime(*p.FinishedAt)
+			p.FinishedAt = &t
+		}
+	}
+	return json.Marshal(p)
 }
 
 func Init(db *sql.DB) error {
@@ -78,16 +164,177 @@ func Init(db *sql.DB) error {
             created_at DATETIME NOT NULL
         );`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_job_files_job_path ON job_files(job_id, path);`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs(created_at DESC);`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_app_id ON jobs(app_id);`,
 	}
 	for _, s := range stmts {
 		if _, err := db.Exec(s); err != nil {
 			return err
 		}
 	}
+
+	return migrate(db)
+}
+
+// migration is one forward-only schema change. Versions must be unique and
+// increasing; never edit or reorder a migration once it has shipped.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations evolve the base schema created in Init. Each one runs in its own
+// transaction and is recorded in schema_migrations, so it applies exactly once.
+var migrations = []migration{
+	{1, "add jobs.app_name", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "app_name", "TEXT")
+	}},
+	{2, "add jobs.command_line", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "command_line", "TEXT")
+	}},
+	// Jobs from before this migration are treated as already fetched.
+	{3, "add jobs.metadata_fetched", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "metadata_fetched", "INTEGER NOT NULL DEFAULT 1")
+	}},
+	{4, "add job_files.sha256", func(tx *sql.Tx) error {
+		return addColumn(tx, "job_files", "sha256", "TEXT")
+	}},
+	{5, "add jobs.custom_title", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "custom_title", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	// Tags are stored as a JSON array.
+	{6, "add jobs.tags", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "tags", "TEXT")
+	}},
+	// A JSON RunEnv.
+	{7, "add jobs.run_env", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "run_env", "TEXT")
+	}},
+	// For ForeignJobFiles, which looks files up by path across jobs.
+	{8, "index job_files.path", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_job_files_path ON job_files(path)`)
+		return err
+	}},
+	{9, "add jobs.failure_kind", func(tx *sql.Tx) error {
+		return addColumn(tx, "jobs", "failure_kind", "TEXT")
+	}},
+	// HTTP cache validators from the job's last metadata fetch.
+	{10, "add jobs.metadata validators", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "jobs", "metadata_etag", "TEXT"); err != nil {
+			return err
+		}
+		return addColumn(tx, "jobs", "metadata_last_modified", "TEXT")
+	}},
+}
+
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME NOT NULL
+        );`); err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, m.version, m.name, time.Now()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func InsertJob(db *sql.DB, appID string, url string, createdAt time.Time) (int64, error) {
+// addColumn adds a column unless it already exists. Databases from before the
+// migration table was introduced may already have some of these columns.
+func addColumn(tx *sql.Tx, table, column, decl string) error {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
+
+// Vacuum rebuilds the database file to reclaim space left behind by deletes.
+// It returns the database size in bytes before and after. VACUUM needs an
+// exclusive lock, so callers should avoid running it while jobs are writing.
+func Vacuum(db *sql.DB) (before, after int64, err error) {
+	if before, err = dbSize(db); err != nil {
+		return 0, 0, err
+	}
+	if _, err = db.Exec(`VACUUM`); err != nil {
+		return before, 0, err
+	}
+	after, err = dbSize(db)
+	return before, after, err
+}
+
+func dbSize(db *sql.DB) (int64, error) {
+	var pages, pageSize int64
+	if err := db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+// InsertJob queues a new job. appName is stored alongside appID so the job keeps
+// a readable label even if the app is later renamed or removed from config.
+// The job starts out pending a metadata fetch; see MarkMetadataFetched.
+func InsertJob(db *sql.DB, appID string, appName string, url string, createdAt time.Time) (int64, error) {
 	if strings.TrimSpace(url) == "" {
 		return 0, errors.New("no url")
 	}
@@ -95,7 +342,7 @@ func InsertJob(db *sql.DB, appID string, url string, createdAt time.Time) (int64
 	if u, err := parseURLTitle(url); err == nil {
 		title = u
 	}
-	res, err := db.Exec(`INSERT INTO jobs (app_id, url, original_url, status, created_at, archived, title) VALUES (?, ?, ?, ?, ?, 0, ?)`, appID, url, url, StatusQueued, createdAt, title)
+	res, err := db.Exec(`INSERT INTO jobs (app_id, app_name, url, original_url, status, created_at, archived, title, metadata_fetched) VALUES (?, ?, ?, ?, ?, ?, 0, ?, 0)`, appID, appName, url, url, StatusQueued, createdAt, title)
 	if err != nil {
 		return 0, err
 	}
@@ -112,40 +359,52 @@ func parseURLTitle(raw string) (string, error) {
 	return p, nil
 }
 
+// jobColumns is the column list scanJob expects, in order. Queries that include
+// logs append the logs column after these.
+const jobColumns = `id, app_id, url, status, pid, exit_code, error_message, created_at, started_at, finished_at, archived, original_url, title, image_path, app_name, command_line, custom_title, tags, run_env, failure_kind`
+
 func scanJob(row interface{ Scan(dest ...interface{}) error }, includeLogs bool) (*Job, error) {
 	var j Job
 	var logs sql.NullString
 	var imagePath sql.NullString
+	var appName sql.NullString
+	var commandLine sql.NullString
+	var tags sql.NullString
+	var runEnv sql.NullString
+	var failureKind sql.NullString
 	var urlStr string
 	var status string
 	var archivedInt int
 
 	scanArgs := []interface{}{
 		&j.ID, &j.AppID, &urlStr, &status, &j.PID, &j.ExitCode, &j.ErrorMessage,
-		&j.CreatedAt, &j.StartedAt, &j.FinishedAt, &archivedInt, &j.OriginalURL, &j.Title, &imagePath,
+		&j.CreatedAt, &j.StartedAt, &j.FinishedAt, &archivedInt, &j.OriginalURL, &j.Title, &imagePath, &appName, &commandLine, &j.CustomTitle, &tags, &runEnv, &failureKind,
 	}
 	if includeLogs {
 		scanArgs = append(scanArgs, &logs)
 	}
 
-	// This is a bit of a hack to dynamically call Scan with the right number of arguments
-	// because Scan doesn't support a variadic slice.
-	switch len(scanArgs) {
-	case 14:
-		if err := row.Scan(scanArgs[0], scanArgs[1], scanArgs[2], scanArgs[3], scanArgs[4], scanArgs[5], scanArgs[6], scanArgs[7], scanArgs[8], scanArgs[9], scanArgs[10], scanArgs[11], scanArgs[12], scanArgs[13]); err != nil {
-			return nil, err
-		}
-	case 15:
-		if err := row.Scan(scanArgs[0], scanArgs[1], scanArgs[2], scanArgs[3], scanArgs[4], scanArgs[5], scanArgs[6], scanArgs[7], scanArgs[8], scanArgs[9], scanArgs[10], scanArgs[11], scanArgs[12], scanArgs[13], scanArgs[14]); err != nil {
-			return nil, err
-		}
-	default:
-		return nil, errors.New("invalid number of scan arguments")
+	if err := row.Scan(scanArgs...); err != nil {
+		return nil, err
 	}
 
 	j.Status = JobStatus(status)
 	j.Archived = archivedInt != 0
 	j.URL = urlStr
+	j.AppName = appName.String
+	j.CommandLine = commandLine.String
+	j.FailureKind = FailureKind(failureKind.String)
+	if tags.String != "" {
+		if err := json.Unmarshal([]byte(tags.String), &j.Tags); err != nil {
+			return nil, fmt.Errorf("job %d: bad tags: %w", j.ID, err)
+		}
+	}
+	if runEnv.String != "" {
+		j.RunEnv = &RunEnv{}
+		if err := json.Unmarshal([]byte(runEnv.String), j.RunEnv); err != nil {
+			return nil, fmt.Errorf("job %d: bad run_env: %w", j.ID, err)
+		}
+	}
 	if imagePath.Valid {
 		ext := filepath.Ext(imagePath.String)
 		pathWithQuery := fmt.Sprintf("/thumbnails/%d%s?%d", j.ID, ext, j.CreatedAt.Unix())
@@ -158,13 +417,14 @@ func scanJob(row interface{ Scan(dest ...interface{}) error }, includeLogs bool)
 }
 
 func GetJob(db *sql.DB, id int64) (*Job, error) {
-	row := db.QueryRow(`SELECT id, app_id, url, status, pid, exit_code, error_message, created_at, started_at, finished_at, archived, original_url, title, image_path, logs FROM jobs WHERE id = ?`, id)
+	row := db.QueryRow(`SELECT `+jobColumns+`, logs FROM jobs WHERE id = ?`, id)
 	return scanJob(row, true)
 }
 
 
+// ListJobsByStatus returns the jobs with status, oldest first.
 func ListJobsByStatus(db *sql.DB, status JobStatus) ([]Job, error) {
-	rows, err := db.Query(`SELECT id, app_id, url, status, pid, exit_code, error_message, created_at, started_at, finished_at, archived, original_url, title, image_path, logs FROM jobs WHERE status = ?`, string(status))
+	rows, err := db.Query(`SELECT `+jobColumns+`, logs FROM jobs WHERE status = ? ORDER BY created_at ASC, id ASC`, string(status))
 	if err != nil {
 		return nil, err
 	}
@@ -180,8 +440,51 @@ func ListJobsByStatus(db *sql.DB, status JobStatus) ([]Job, error) {
 	return out, rows.Err()
 }
 
+// ListFailedJobIDs returns the ids of failed jobs that aren't archived, oldest
+// first. A non-empty appID keeps only that app's jobs and a non-zero since only
+// those that finished at or after it.
+func ListFailedJobIDs(db *sql.DB, appID string, since time.Time) ([]int64, error) {
+	q := `SELECT id FROM jobs WHERE status = ? AND archived = 0`
+	args := []any{string(StatusFailed)}
+	if appID != "" {
+		q += ` AND app_id = ?`
+		args = append(args, appID)
+	}
+	if !since.IsZero() {
+		// Timestamps are stored as text in local time, so the bound is compared in it too.
+		q += ` AND finished_at >= ?`
+		args = append(args, since.Local())
+	}
+	rows, err := db.Query(q+` ORDER BY created_at ASC, id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func ListJobs(db *sql.DB, limit int) ([]Job, error) {
-	q := `SELECT id, app_id, url, status, pid, exit_code, error_message, created_at, started_at, finished_at, archived, original_url, title, image_path FROM jobs`
+	var out []Job
+	err := EachJob(db, limit, func(j *Job) error {
+		out = append(out, *j)
+		return nil
+	})
+	return out, err
+}
+
+// EachJob calls fn for each job, newest first, without loading the whole list
+// into memory. A limit <= 0 means no limit. Iteration stops at the first error
+// returned by fn.
+func EachJob(db *sql.DB, limit int, fn func(*Job) error) error {
+	q := `SELECT ` + jobColumns + ` FROM jobs`
 	q += ` ORDER BY created_at DESC`
 	if limit > 0 {
 		q += ` LIMIT ?`
@@ -195,19 +498,20 @@ func ListJobs(db *sql.DB, limit int) ([]Job, error) {
 		rows, err = db.Query(q)
 	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var out []Job
 	for rows.Next() {
 		j, err := scanJob(rows, false)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(j); err != nil {
+			return err
 		}
-		out = append(out, *j)
 	}
-	return out, rows.Err()
+	return rows.Err()
 }
 
 func UpdateJobStatusRunning(db *sql.DB, id int64, startedAt time.Time) error {
@@ -215,6 +519,22 @@ func UpdateJobStatusRunning(db *sql.DB, id int64, startedAt time.Time) error {
 	return err
 }
 
+// UpdateJobCommandLine records the exact command executed for the job.
+func UpdateJobCommandLine(db *sql.DB, id int64, commandLine string) error {
+	_, err := db.Exec(`UPDATE jobs SET command_line = ? WHERE id = ?`, commandLine, id)
+	return err
+}
+
+// UpdateJobRunEnv records the context the job's command was started in.
+func UpdateJobRunEnv(db *sql.DB, id int64, env RunEnv) error {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE jobs SET run_env = ? WHERE id = ?`, string(b), id)
+	return err
+}
+
 func UpdateJobPID(db *sql.DB, id int64, pid int) error {
 	_, err := db.Exec(`UPDATE jobs SET pid = ? WHERE id = ?`, pid, id)
 	return err
@@ -235,8 +555,8 @@ func MarkJobCancelled(db *sql.DB, id int64, finishedAt time.Time, logs string) e
 	return err
 }
 
-func MarkJobFailed(db *sql.DB, id int64, finishedAt time.Time, msg string, logs string) error {
-	_, err := db.Exec(`UPDATE jobs SET status = ?, finished_at = ?, error_message = ?, logs = ? WHERE id = ?`, StatusFailed, finishedAt, msg, logs, id)
+func MarkJobFailed(db *sql.DB, id int64, finishedAt time.Time, kind FailureKind, msg string, logs string) error {
+	_, err := db.Exec(`UPDATE jobs SET status = ?, finished_at = ?, failure_kind = ?, error_message = ?, logs = ? WHERE id = ?`, StatusFailed, finishedAt, string(kind), msg, logs, id)
 	return err
 }
 
@@ -246,29 +566,81 @@ func MarkJobCleaned(db *sql.DB, id int64) error {
 }
 
 func ResetJobForRetry(db *sql.DB, id int64) error {
+	_, err := resetJobForRetry(db, id, "")
+	return err
+}
+
+// ResetFailedJobForRetry resets the job like ResetJobForRetry, but only while it
+// is still failed. It reports whether the job was reset, so a job retried by two
+// requests at once is queued only once.
+func ResetFailedJobForRetry(db *sql.DB, id int64) (bool, error) {
+	return resetJobForRetry(db, id, StatusFailed)
+}
+
+// resetJobForRetry clears the job's run and files; a non-empty status limits it
+// to jobs that still have that status.
+func resetJobForRetry(db *sql.DB, id int64, status JobStatus) (bool, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(`UPDATE jobs SET status=?, pid=NULL, exit_code=NULL, error_message=NULL, started_at=NULL, finished_at=NULL, logs=NULL, archived=0 WHERE id=?`, StatusQueued, id); err != nil {
-		return err
+	q := `UPDATE jobs SET status=?, pid=NULL, exit_code=NULL, error_message=NULL, failure_kind=NULL, started_at=NULL, finished_at=NULL, logs=NULL, command_line=NULL, archived=0 WHERE id=?`
+	args := []any{StatusQueued, id}
+	if status != "" {
+		q += ` AND status=?`
+		args = append(args, status)
+	}
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
 	}
 	if _, err := tx.Exec(`DELETE FROM job_files WHERE job_id = ?`, id); err != nil {
-		return err
+		return false, err
 	}
-	return tx.Commit()
+	return true, tx.Commit()
 }
 
-
-
 func ArchiveJob(db *sql.DB, id int64) error {
 	_, err := db.Exec(`UPDATE jobs SET archived = 1 WHERE id = ?`, id)
 	return err
 }
 
+// UpdateJobTitle sets the title found in the page's metadata. A title given at
+// submission (see SetJobCustomTitle) is kept.
 func UpdateJobTitle(db *sql.DB, id int64, title string) error {
-	_, err := db.Exec(`UPDATE jobs SET title = ? WHERE id = ?`, title, id)
+	_, err := db.Exec(`UPDATE jobs SET title = ? WHERE id = ? AND custom_title = 0`, title, id)
+	return err
+}
+
+// SetJobCustomTitle sets a title chosen by the submitter, which metadata
+// fetches then leave alone.
+func SetJobCustomTitle(db *sql.DB, id int64, title string) error {
+	_, err := db.Exec(`UPDATE jobs SET title = ?, custom_title = 1 WHERE id = ?`, title, id)
+	return err
+}
+
+// SetJobTags replaces the job's tags; nil or empty clears them.
+func SetJobTags(db *sql.DB, id int64, tags []string) error {
+	var v interface{}
+	if len(tags) > 0 {
+		b, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		v = string(b)
+	}
+	_, err := db.Exec(`UPDATE jobs SET tags = ? WHERE id = ?`, v, id)
+	return err
+}
+
+// UpdateJobFinalURL records where the submitted URL ended up after redirects.
+// original_url keeps the URL as submitted.
+func UpdateJobFinalURL(db *sql.DB, id int64, finalURL string) error {
+	_, err := db.Exec(`UPDATE jobs SET url = ? WHERE id = ?`, finalURL, id)
 	return err
 }
 
@@ -277,9 +649,132 @@ func UpdateJobImagePath(db *sql.DB, id int64, imagePath string) error {
 	return err
 }
 
+// MarkMetadataFetched records that the job's metadata fetch has finished (or
+// isn't wanted), so it won't be retried after a restart.
+func MarkMetadataFetched(db *sql.DB, id int64) error {
+	_, err := db.Exec(`UPDATE jobs SET metadata_fetched = 1 WHERE id = ?`, id)
+	return err
+}
+
+// ListJobsPendingMetadata returns jobs whose metadata fetch never finished, oldest first.
+func ListJobsPendingMetadata(db *sql.DB) ([]Job, error) {
+	rows, err := db.Query(`SELECT `+jobColumns+` FROM jobs WHERE metadata_fetched = 0 ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Job
+	for rows.Next() {
+		j, err := scanJob(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *j)
+	}
+	return out, rows.Err()
+}
+
+// Use UPSERT semantics so concurrent inserts by path/job coalesce atomically.
+// An upsert without a hash keeps the stored one as long as size and mtime are
+// unchanged, so watcher events don't throw away hashes from the last resync.
+const upsertJobFileSQL = `INSERT INTO job_files (job_id, path, size_bytes, created_at, sha256) VALUES (?, ?, ?, ?, ?) ON CONFLICT(job_id, path) DO UPDATE SET size_bytes = excluded.size_bytes, created_at = excluded.created_at, sha256 = CASE WHEN excluded.sha256 IS NOT NULL THEN excluded.sha256 WHEN job_files.size_bytes = excluded.size_bytes AND job_files.created_at = excluded.created_at THEN job_files.sha256 END`
+
+const jobFileColumns = `id, job_id, path, size_bytes, created_at, sha256`
+
+func scanJobFile(row interface{ Scan(dest ...interface{}) error }) (*JobFile, error) {
+	var f JobFile
+	var sum sql.NullString
+	if err := row.Scan(&f.ID, &f.JobID, &f.Path, &f.SizeBytes, &f.CreatedAt, &sum); err != nil {
+		return nil, err
+	}
+	f.SHA256 = sum.String
+	return &f, nil
+}
+
+// nullIfEmpty stores "" as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 func InsertJobFile(db *sql.DB, jobID int64, path string, size int64, createdAt time.Time) error {
-	// Use UPSERT semantics so concurrent inserts by path/job coalesce atomically.
-	_, err := db.Exec(`INSERT INTO job_files (job_id, path, size_bytes, created_at) VALUES (?, ?, ?, ?) ON CONFLICT(job_id, path) DO UPDATE SET size_bytes = excluded.size_bytes, created_at = excluded.created_at`, jobID, path, size, createdAt)
+	_, err := db.Exec(upsertJobFileSQL, jobID, path, size, createdAt, nil)
+	return err
+}
+
+// InsertJobFiles upserts several files for a job in a single transaction.
+func InsertJobFiles(db *sql.DB, jobID int64, files []JobFile) error {
+	return SyncJobFiles(db, jobID, files, nil)
+}
+
+// SyncJobFiles upserts files and deletes the removed paths for a job in a single
+// transaction, so large jobs don't pay for one commit per file.
+func SyncJobFiles(db *sql.DB, jobID int64, files []JobFile, removed []string) error {
+	if len(files) == 0 && len(removed) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(files) > 0 {
+		upsert, err := tx.Prepare(upsertJobFileSQL)
+		if err != nil {
+			return err
+		}
+		defer upsert.Close()
+		for _, f := range files {
+			if _, err := upsert.Exec(jobID, f.Path, f.SizeBytes, f.CreatedAt, nullIfEmpty(f.SHA256)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(removed) > 0 {
+		del, err := tx.Prepare(`DELETE FROM job_files WHERE job_id = ? AND path = ?`)
+		if err != nil {
+			return err
+		}
+		defer del.Close()
+		for _, p := range removed {
+			if _, err := del.Exec(jobID, p); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// RenameJobFile moves a recorded file to a new path, keeping its ID so existing
+// download links keep working. Any row already recorded at newPath (e.g. from a
+// watcher event racing the rename) is replaced.
+func RenameJobFile(db *sql.DB, jobID int64, oldPath, newPath string, size int64, createdAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM job_files WHERE job_id = ? AND path = ?`, jobID, newPath); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE job_files SET path = ? WHERE job_id = ? AND path = ?`, newPath, jobID, oldPath)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := tx.Exec(`INSERT INTO job_files (job_id, path, size_bytes, created_at) VALUES (?, ?, ?, ?)`, jobID, newPath, size, createdAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateJobFileHash caches a file's sha256 computed outside resync.
+func UpdateJobFileHash(db *sql.DB, id int64, sum string) error {
+	_, err := db.Exec(`UPDATE job_files SET sha256 = ? WHERE id = ?`, sum, id)
 	return err
 }
 
@@ -288,13 +783,36 @@ func DeleteJobFileByPath(db *sql.DB, jobID int64, path string) error {
 	return err
 }
 
-func GetJobFileByID(db *sql.DB, id int64) (*JobFile, error) {
-	row := db.QueryRow(`SELECT id, job_id, path, size_bytes, created_at FROM job_files WHERE id = ?`, id)
-	var f JobFile
-	if err := row.Scan(&f.ID, &f.JobID, &f.Path, &f.SizeBytes, &f.CreatedAt); err != nil {
+// DeleteJobFiles forgets all of a job's files.
+func DeleteJobFiles(db *sql.DB, jobID int64) error {
+	_, err := db.Exec(`DELETE FROM job_files WHERE job_id = ?`, jobID)
+	return err
+}
+
+// ForeignJobFiles returns the files at path, or under it if it's a directory,
+// that are recorded for jobs other than jobID.
+func ForeignJobFiles(db *sql.DB, jobID int64, path string) ([]JobFile, error) {
+	// Paths under dir sort between "dir/" and "dir0" ('0' follows '/').
+	dir := strings.TrimSuffix(path, "/")
+	rows, err := db.Query(`SELECT `+jobFileColumns+` FROM job_files WHERE job_id != ? AND (path = ? OR (path >= ? AND path < ?))`, jobID, path, dir+"/", dir+"0")
+	if err != nil {
 		return nil, err
 	}
-	return &f, nil
+	defer rows.Close()
+	var files []JobFile
+	for rows.Next() {
+		f, err := scanJobFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *f)
+	}
+	return files, rows.Err()
+}
+
+func GetJobFileByID(db *sql.DB, id int64) (*JobFile, error) {
+	row := db.QueryRow(`SELECT `+jobFileColumns+` FROM job_files WHERE id = ?`, id)
+	return scanJobFile(row)
 }
 
 func JobFileExists(db *sql.DB, jobID int64, path string) (bool, error) {
@@ -306,19 +824,216 @@ func JobFileExists(db *sql.DB, jobID int64, path string) (bool, error) {
 	return cnt > 0, nil
 }
 
+// CountJobFiles returns how many files are recorded for the job.
+func CountJobFiles(db *sql.DB, jobID int64) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM job_files WHERE job_id = ?`, jobID).Scan(&n)
+	return n, err
+}
+
 func ListJobFiles(db *sql.DB, jobID int64) ([]JobFile, error) {
-	rows, err := db.Query(`SELECT id, job_id, path, size_bytes, created_at FROM job_files WHERE job_id = ? ORDER BY created_at ASC`, jobID)
+	rows, err := db.Query(`SELECT `+jobFileColumns+` FROM job_files WHERE job_id = ? ORDER BY created_at ASC`, jobID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var files []JobFile
 	for rows.Next() {
-		var f JobFile
-		if err := rows.Scan(&f.ID, &f.JobID, &f.Path, &f.SizeBytes, &f.CreatedAt); err != nil {
+		f, err := scanJobFile(rows)
+		if err != nil {
 			return nil, err
 		}
-		files = append(files, f)
+		files = append(files, *f)
 	}
 	return files, rows.Err()
 }
+
+// MetadataValidators are the HTTP cache validators from a job's last metadata fetch.
+type MetadataValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// GetMetadataValidators returns the validators saved by the job's last metadata
+// fetch, or nil if there are none.
+func GetMetadataValidators(db *sql.DB, jobID int64) (*MetadataValidators, error) {
+	row := db.QueryRow(`SELECT metadata_etag, metadata_last_modified FROM jobs WHERE id = ?`, jobID)
+	var etag, lastModified sql.NullString
+	if err := row.Scan(&etag, &lastModified); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if etag.String == "" && lastModified.String == "" {
+		return nil, nil
+	}
+	return &MetadataValidators{ETag: etag.String, LastModified: lastModified.String}, nil
+}
+
+// SaveMetadataValidators replaces the job's validators; empty ones clear them.
+func SaveMetadataValidators(db *sql.DB, jobID int64, v MetadataValidators) error {
+	_, err := db.Exec(`UPDATE jobs SET metadata_etag = ?, metadata_last_modified = ? WHERE id = ?`, v.ETag, v.LastModified, jobID)
+	return err
+}
+
+// Activity is one event in the history feed, derived from job and file
+// timestamps.
+type Activity struct {
+	Type  string    `json:"type"`
+	At    time.Time `json:"at"`
+	JobID int64     `json:"job_id"`
+	Title string    `json:"title,omitempty"`
+	AppID string    `json:"app_id,omitempty"`
+	// Path and SizeBytes are set for file_added events.
+	Path      string `json:"path,omitempty"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+}
+
+// Activity types. A cleaned job's outcome is gone, so its end is job_finished.
+const (
+	ActivitySubmitted = "job_submitted"
+	ActivityStarted   = "job_started"
+	ActivitySucceeded = "job_succeeded"
+	ActivityFailed    = "job_failed"
+	ActivityCancelled = "job_cancelled"
+	ActivityFinished  = "job_finished"
+	ActivityFileAdded = "file_added"
+)
+
+// ActivityCursor marks an event in the feed so the next page can resume right
+// after it. Events can share a timestamp, so the time alone isn't enough.
+type ActivityCursor struct {
+	At    time.Time `json:"at"`
+	Type  string    `json:"type"`
+	JobID int64     `json:"job_id"`
+	Path  string    `json:"path,omitempty"`
+}
+
+// Cursor returns the position of a in the feed.
+func (a Activity) Cursor() ActivityCursor {
+	return ActivityCursor{At: a.At, Type: a.Type, JobID: a.JobID, Path: a.Path}
+}
+
+// ListActivity returns up to limit events after since and before before, newest
+// first; a zero time leaves that end open. A non-nil after starts the page past
+// that event. A file is added at its modification time.
+func ListActivity(db *sql.DB, since, before time.Time, after *ActivityCursor, limit int) ([]Activity, error) {
+	// Timestamps are stored as text in local time, so bounds are compared in it too.
+	// keyCols order one source's events that share a timestamp, matching
+	// compareActivity; rank places the source among the others.
+	window := func(keyCols []string, rank int) (string, []any) {
+		col := keyCols[0]
+		where := col + ` IS NOT NULL`
+		var args []any
+		if !since.IsZero() {
+			where += ` AND ` + col + ` > ?`
+			args = append(args, since.Local())
+		}
+		if !before.IsZero() {
+			where += ` AND ` + col + ` < ?`
+			args = append(args, before.Local())
+		}
+		if after != nil {
+			switch afterRank := activityRank(after.Type); {
+			case rank > afterRank:
+				where += ` AND ` + col + ` < ?`
+				args = append(args, after.At.Local())
+			case rank < afterRank:
+				where += ` AND ` + col + ` <= ?`
+				args = append(args, after.At.Local())
+			default:
+				where += ` AND (` + strings.Join(keyCols, ", ") + `) < (?` + strings.Repeat(", ?", len(keyCols)-1) + `)`
+				args = append(args, []any{after.At.Local(), after.JobID, after.Path}[:len(keyCols)]...)
+			}
+		}
+		return where, append(args, limit)
+	}
+
+	var out []Activity
+	// Each source yields its newest limit events; merged, the newest limit win.
+	for _, col := range []string{"created_at", "started_at", "finished_at"} {
+		where, args := window([]string{col, "id"}, activityRank(activityType(col, "")))
+		rows, err := db.Query(`SELECT id, app_id, title, status, `+col+` FROM jobs WHERE `+where+` ORDER BY `+col+` DESC, id DESC LIMIT ?`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var a Activity
+			var status JobStatus
+			if err := rows.Scan(&a.JobID, &a.AppID, &a.Title, &status, &a.At); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			a.Type = activityType(col, status)
+			out = append(out, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	where, args := window([]string{"f.created_at", "f.job_id", "f.path"}, activityRank(ActivityFileAdded))
+	rows, err := db.Query(`SELECT f.job_id, j.app_id, j.title, f.path, f.size_bytes, f.created_at FROM job_files f JOIN jobs j ON j.id = f.job_id WHERE `+where+` ORDER BY f.created_at DESC, f.job_id DESC, f.path DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := Activity{Type: ActivityFileAdded}
+		if err := rows.Scan(&a.JobID, &a.AppID, &a.Title, &a.Path, &a.SizeBytes, &a.At); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(out, compareActivity)
+	return out[:min(len(out), limit)], nil
+}
+
+// compareActivity orders the feed: newest first, and at the same time the later
+// stage (finished, file added, started, submitted) first, then by job and path.
+func compareActivity(a, b Activity) int {
+	return cmp.Or(
+		b.At.Compare(a.At),
+		cmp.Compare(activityRank(b.Type), activityRank(a.Type)),
+		cmp.Compare(b.JobID, a.JobID),
+		strings.Compare(b.Path, a.Path),
+	)
+}
+
+// activityRank is the stage an event type belongs to; every outcome of a job
+// counts as finishing it.
+func activityRank(typ string) int {
+	switch typ {
+	case ActivitySubmitted:
+		return 0
+	case ActivityStarted:
+		return 1
+	case ActivityFileAdded:
+		return 2
+	}
+	return 3
+}
+
+func activityType(col string, status JobStatus) string {
+	switch col {
+	case "created_at":
+		return ActivitySubmitted
+	case "started_at":
+		return ActivityStarted
+	}
+	switch status {
+	case StatusSuccess:
+		return ActivitySucceeded
+	case StatusFailed:
+		return ActivityFailed
+	case StatusCancelled:
+		return ActivityCancelled
+	}
+	return ActivityFinished
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(tb.TempDir(), "test.db")+"?_fk=1")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := Init(db); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// seedJobs inserts n jobs in a single transaction so large fixtures stay fast.
+func seedJobs(tb testing.TB, db *sql.DB, n int) {
+	tb.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO jobs (app_id, url, original_url, status, created_at, archived, title) VALUES (?, ?, ?, ?, ?, 0, ?)`)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	base := time.Now().Add(-time.Duration(n) * time.Second)
+	statuses := []JobStatus{StatusSuccess, StatusFailed, StatusQueued, StatusCleaned}
+	for i := 0; i < n; i++ {
+		u := fmt.Sprintf("http://example.com/%d", i)
+		if _, err := stmt.Exec(fmt.Sprintf("app%d", i%5), u, u, statuses[i%len(statuses)], base.Add(time.Duration(i)*time.Second), u); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func BenchmarkListJobs(b *testing.B) {
+	db := openTestDB(b)
+	seedJobs(b, db, 100000)
+
+	run := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ListJobs(db, 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("indexed", run)
+
+	if _, err := db.Exec(`DROP INDEX idx_jobs_created_at`); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("unindexed", run)
+}
+
+func TestMigrateOldSchema(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "old.db")+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The jobs table as it looked before any migrations existed.
+	if _, err := db.Exec(`CREATE TABLE jobs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            app_id TEXT NOT NULL,
+            url TEXT NOT NULL,
+            status TEXT NOT NULL,
+            pid INTEGER,
+            exit_code INTEGER,
+            error_message TEXT,
+            created_at DATETIME NOT NULL,
+            started_at DATETIME,
+            finished_at DATETIME,
+            archived INTEGER NOT NULL DEFAULT 0,
+            original_url TEXT,
+            title TEXT,
+            image_path TEXT,
+            logs TEXT
+        );`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO jobs (app_id, url, original_url, status, created_at, title) VALUES ('app', 'http://a', 'http://a', 'success', ?, 'a')`, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Init(db); err != nil {
+			t.Fatalf("Init run %d: %v", i+1, err)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(migrations) {
+		t.Fatalf("expected %d applied migrations, got %d", len(migrations), count)
+	}
+
+	j, err := GetJob(db, 1)
+	if err != nil {
+		t.Fatalf("existing job unreadable after migration: %v", err)
+	}
+	if j.AppName != "" || j.CommandLine != "" {
+		t.Fatalf("expected new columns to be empty, got %+v", j)
+	}
+	if err := UpdateJobCommandLine(db, 1, "echo hi"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateColumnsAddedBeforeTracking(t *testing.T) {
+	db := openTestDB(t)
+	// Simulate a database that gained the columns before schema_migrations existed.
+	if _, err := db.Exec(`DELETE FROM schema_migrations`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	db := openTestDB(t)
+	seedJobs(t, db, 2000)
+	if _, err := db.Exec(`DELETE FROM jobs`); err != nil {
+		t.Fatal(err)
+	}
+	before, after, err := Vacuum(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Fatalf("expected vacuum to shrink the database, got %d -> %d", before, after)
+	}
+}
+
+func TestJobJSONDisplayLocation(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	finished := created.Add(time.Minute)
+	j := Job{ID: 1, CreatedAt: created, FinishedAt: &finished}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"created_at":"2024-03-01T12:00:00Z"`) {
+		t.Fatalf("expected UTC timestamps by default, got %s", b)
+	}
+
+	SetDisplayLocation(time.FixedZone("UTC+2", 2*60*60))
+	defer SetDisplayLocation(nil)
+	b, err = json.Marshal(&j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"created_at":"2024-03-01T14:00:00+02:00"`, `"finished_at":"2024-03-01T14:01:00+02:00"`} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("expected %s in %s", want, b)
+		}
+	}
+	if j.FinishedAt != &finished || !finished.Equal(created.Add(time.Minute)) || finished.Location() != time.UTC {
+		t.Fatal("marshaling modified the job")
+	}
+}
+
+func TestUpsertKeepsHashForUnchangedFile(t *testing.T) {
+	db := openTestDB(t)
+	jobID, _ := InsertJob(db, "app", "App", "http://a", time.Now())
+	mtime := time.Now()
+	if err := SyncJobFiles(db, jobID, []JobFile{{Path: "/d/a", SizeBytes: 4, CreatedAt: mtime, SHA256: "abc"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A watcher event for the same, unchanged file doesn't know the hash.
+	if err := InsertJobFile(db, jobID, "/d/a", 4, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ListJobFiles(db, jobID); files[0].SHA256 != "abc" {
+		t.Fatalf("expected hash to survive an upsert of the unchanged file, got %q", files[0].SHA256)
+	}
+
+	if err := InsertJobFile(db, jobID, "/d/a", 8, mtime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ListJobFiles(db, jobID); files[0].SHA256 != "" {
+		t.Fatalf("expected stale hash to be cleared once the file changed, got %q", files[0].SHA256)
+	}
+}
+
+func TestListFailedJobIDs(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	fail := func(app string, finished time.Time) int64 {
+		id, _ := InsertJob(db, app, app, "http://a", now)
+		if err := MarkJobFailed(db, id, finished, FailureExitNonzero, "exit status 1", ""); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	old := fail("a", now.Add(-2*time.Hour))
+	recent := fail("a", now)
+	other := fail("b", now)
+	archived := fail("a", now)
+	if err := ArchiveJob(db, archived); err != nil {
+		t.Fatal(err)
+	}
+	InsertJob(db, "a", "a", "http://a", now) // still queued
+
+	tests := []struct {
+		appID string
+		since time.Time
+		want  []int64
+	}{
+		{"", time.Time{}, []int64{old, recent, other}},
+		{"a", time.Time{}, []int64{old, recent}},
+		{"a", now.Add(-time.Hour), []int64{recent}},
+	}
+	for _, tt := range tests {
+		got, err := ListFailedJobIDs(db, tt.appID, tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("ListFailedJobIDs(%q, %v) = %v, want %v", tt.appID, tt.since, got, tt.want)
+		}
+	}
+}
+
+func TestResetFailedJobForRetryOnlyOnce(t *testing.T) {
+	db := openTestDB(t)
+	id, _ := InsertJob(db, "app", "App", "http://a", time.Now())
+	if err := MarkJobFailed(db, id, time.Now(), FailureExitNonzero, "exit status 1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if reset, err := ResetFailedJobForRetry(db, id); err != nil || !reset {
+		t.Fatalf("expected the failed job to be reset, got %v, %v", reset, err)
+	}
+	// Now queued, as a concurrent retry would find it.
+	if err := InsertJobFile(db, id, "/d/a", 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if reset, err := ResetFailedJobForRetry(db, id); err != nil || reset {
+		t.Fatalf("expected a queued job to be left alone, got %v, %v", reset, err)
+	}
+	if n, _ := CountJobFiles(db, id); n != 1 {
+		t.Fatalf("expected the queued job's files to be kept, got %d", n)
+	}
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time (see the Makefile):
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.buildDate=2024-01-02T15:04:05Z"
+//
+// Without them, the commit and date Go stamps into builds from a git checkout
+// are used.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func currentVersion() versionInfo {
+	v := versionInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if v.Version == "" {
+		v.Version = "dev"
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	dirty := false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if v.Commit == "" {
+				v.Commit = s.Value
+			}
+		case "vcs.time":
+			if v.BuildDate == "" {
+				v.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if commit == "" && dirty && v.Commit != "" {
+		v.Commit += "-dirty"
+	}
+	return v
+}
+
+func (v versionInfo) String() string {
+	s := v.Version
+	if v.Commit != "" {
+		s += " (" + v.Commit + ")"
+	}
+	if v.BuildDate != "" {
+		s += " built " + v.BuildDate
+	}
+	return fmt.Sprintf("%s, %s", s, v.GoVersion)
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(currentVersion())
+}